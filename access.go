@@ -0,0 +1,36 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/access"
+	"github.com/obase/pbapi/trace"
+	"strconv"
+	"time"
+)
+
+// websocket每条消息的access log, code与响应的Response.Code一致
+func newWbskAccessHandlerFunc(a *access.Access, tag string) gin.HandlerFunc {
+	observer := func(c *gin.Context, rdata []byte, wdata []byte, err error, start time.Time) {
+		code := SUCCESS
+		if err != nil {
+			if ersp, ok := err.(*Response); ok {
+				code = ersp.Code
+			} else {
+				code = EXECUTE_SERVICE_ERROR
+			}
+		}
+		a.Log(&access.Entry{
+			Kind:     access.KindWbsk,
+			Peer:     c.Request.RemoteAddr,
+			Method:   tag,
+			Code:     strconv.Itoa(code),
+			Latency:  time.Since(start),
+			ReqBytes: len(rdata),
+			RspBytes: len(wdata),
+			TraceId:  trace.TraceIDFromContext(c),
+		})
+	}
+	return func(ctx *gin.Context) {
+		AddWbskObserver(ctx, observer)
+	}
+}
@@ -2,50 +2,183 @@ package access
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/obase/kit"
 	"github.com/obase/log"
+	"github.com/obase/pbapi/cache"
+	"github.com/obase/pbapi/proxy"
+	"github.com/obase/pbapi/trace"
+	"io"
 	"strconv"
+	"strings"
 	"time"
 )
 
-const SPACE = ' '
+const (
+	SPACE = ' '
 
+	HEADER_X_REQUEST_ID = "X-Request-Id"
+)
+
+// 结点元数据, 取自FlatNode
+type Node struct {
+	Package string
+	Service string
+	Method  string
+	Path    string
+}
+
+// 兼容旧接口: text格式, 默认字段
 func NewHandlerFunc(logger *log.Logger) gin.HandlerFunc {
+	a, _ := New(logger, nil)
+	return a.HandlerFunc(nil)
+}
+
+type Access struct {
+	logger *log.Logger
+	format string
+	fields []*field
+	reqlen bool // 是否需要统计请求字节数
+}
+
+func New(logger *log.Logger, c *Config) (*Access, error) {
 	if logger == nil {
+		return nil, nil
+	}
+	format, names := FormatText, DefaultFields
+	if c != nil {
+		if c.Format != "" {
+			format = strings.ToLower(c.Format)
+		}
+		if len(c.Fields) > 0 {
+			names = c.Fields
+		}
+	}
+	switch format {
+	case FormatText, FormatJson, FormatLogfmt:
+	default:
+		return nil, errInvalid("format", format)
+	}
+	a := &Access{
+		logger: logger,
+		format: format,
+	}
+	for _, name := range names {
+		f, err := newField(name)
+		if err != nil {
+			return nil, err
+		}
+		if f.name == FieldReqBytes {
+			a.reqlen = true
+		}
+		a.fields = append(a.fields, f)
+	}
+	return a, nil
+}
+
+// 结点的access句柄, node为空则不输出package/service/rpc
+func (a *Access) HandlerFunc(node *Node) gin.HandlerFunc {
+	if a == nil {
 		return nil
 	}
-	/*
-		- 请求来源
-		- 请求方法
-		- 请求路径
-		- 请求查询串
-		- 响应状态
-		- 响应时间
-	*/
+	if node == nil {
+		node = new(Node)
+	}
 	return func(ctx *gin.Context) {
-		start := time.Now().UnixNano()
-		source := ctx.ClientIP()
-		method := ctx.Request.Method
-		path := ctx.Request.URL.Path
-		query := ctx.Request.URL.RawQuery
+		r := &record{
+			ctx:   ctx,
+			node:  node,
+			start: time.Now(),
+		}
+		if a.reqlen && ctx.Request.Body != nil {
+			r.body = &countReader{ReadCloser: ctx.Request.Body}
+			ctx.Request.Body = r.body
+		}
+		r.remote = ctx.ClientIP()
+		r.method = ctx.Request.Method
+		r.path = ctx.Request.URL.Path
+		r.query = ctx.Request.URL.RawQuery
 		ctx.Next()
-		status := ctx.Writer.Status()
-		end := time.Now().UnixNano()
-		used := (end - start) / 1000000
-
-		buf := kit.GetStringBuffer()
-		buf.WriteString(source)
-		buf.WriteByte(SPACE)
-		buf.WriteString(method)
-		buf.WriteByte(SPACE)
-		buf.WriteString(path)
-		buf.WriteByte(SPACE)
-		buf.WriteString(query)
-		buf.WriteByte(SPACE)
-		buf.WriteString(strconv.Itoa(status))
-		buf.WriteByte(SPACE)
-		buf.WriteString(strconv.FormatInt(used, 10))
-		logger.Info(buf.String())
-		kit.PutStringBuffer(buf)
+		r.latency = time.Since(r.start)
+		a.logger.Info(a.format2(r))
+	}
+}
+
+// 单次请求的记录. 请求信息必须在Next之前读取, 后续handler可能修改Request
+type record struct {
+	ctx     *gin.Context
+	node    *Node
+	start   time.Time
+	latency time.Duration
+	remote  string
+	method  string
+	path    string
+	query   string
+	body    *countReader
+}
+
+type countReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countReader) Read(p []byte) (n int, err error) {
+	n, err = r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return
+}
+
+func (r *record) value(f *field) (string, bool) {
+	ctx := r.ctx
+	switch f.name {
+	case FieldRemote:
+		return r.remote, false
+	case FieldMethod:
+		return r.method, false
+	case FieldPath:
+		return r.path, false
+	case FieldQuery:
+		return r.query, false
+	case FieldStatus:
+		return strconv.Itoa(ctx.Writer.Status()), true
+	case FieldLatency:
+		return strconv.FormatInt(int64(r.latency/time.Millisecond), 10), true
+	case FieldLatencyUs:
+		return strconv.FormatInt(int64(r.latency/time.Microsecond), 10), true
+	case FieldReqBytes:
+		n := ctx.Request.ContentLength
+		if r.body != nil {
+			n = r.body.n
+		} else if n < 0 {
+			n = 0
+		}
+		return strconv.FormatInt(n, 10), true
+	case FieldRspBytes:
+		n := ctx.Writer.Size()
+		if n < 0 {
+			n = 0
+		}
+		return strconv.Itoa(n), true
+	case FieldUserAgent:
+		return ctx.Request.UserAgent(), false
+	case FieldReferer:
+		return ctx.Request.Referer(), false
+	case FieldRequestId:
+		return ctx.Request.Header.Get(HEADER_X_REQUEST_ID), false
+	case FieldTraceId:
+		return trace.TraceIDFromContext(ctx), false
+	case FieldPackage:
+		return r.node.Package, false
+	case FieldService:
+		return r.node.Service, false
+	case FieldRpc:
+		return r.node.Method, false
+	case FieldRoute:
+		return r.node.Path, false
+	case FieldCache:
+		return ctx.GetString(cache.CONTEXT_KEY), false
+	case FieldUpstream:
+		return ctx.GetString(proxy.CONTEXT_KEY), false
+	case FieldHeader:
+		return ctx.Request.Header.Get(f.header), false
 	}
+	return "", false
 }
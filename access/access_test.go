@@ -0,0 +1,170 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/log"
+	"github.com/obase/pbapi/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	w := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(w)
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/a?x=1", strings.NewReader("hello"))
+	ctx.Request.Header.Set("User-Agent", "test agent")
+	ctx.Request.Header.Set("X-Device-Id", "d1")
+	ctx.Set(cache.CONTEXT_KEY, cache.CacheMiss)
+	ctx.String(http.StatusOK, "world!")
+
+	r := &record{
+		ctx:     ctx,
+		node:    &Node{Service: "Demo", Method: "Hello"},
+		latency: 1500 * time.Microsecond,
+		remote:  "10.0.0.1",
+		method:  ctx.Request.Method,
+		path:    ctx.Request.URL.Path,
+		query:   ctx.Request.URL.RawQuery,
+	}
+	fields := []string{"remote", "status", "latency_us", "req_bytes", "rsp_bytes", "user_agent", "rpc", "cache", "header:X-Device-Id"}
+
+	a, err := New(nil, nil)
+	if a != nil || err != nil {
+		t.Fatalf("expect nil access without logger")
+	}
+	newAccess := func(format string, fields []string) *Access {
+		a := &Access{format: format}
+		for _, name := range fields {
+			f, err := newField(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.fields = append(a.fields, f)
+		}
+		return a
+	}
+
+	var m map[string]interface{}
+	line := newAccess(FormatJson, fields).format2(r)
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		t.Fatalf("invalid json %v: %v", line, err)
+	}
+	if m["status"] != float64(200) || m["latency_us"] != float64(1500) || m["req_bytes"] != float64(5) || m["rsp_bytes"] != float64(6) ||
+		m["user_agent"] != "test agent" || m["rpc"] != "Hello" || m["cache"] != "miss" || m["x_device_id"] != "d1" {
+		t.Fatalf("unexpected json: %v", line)
+	}
+
+	line = newAccess(FormatLogfmt, fields).format2(r)
+	if !strings.Contains(line, `user_agent="test agent"`) || !strings.Contains(line, "cache=miss") {
+		t.Fatalf("unexpected logfmt: %v", line)
+	}
+
+	line = newAccess(FormatText, DefaultFields).format2(r)
+	if line != "10.0.0.1 POST /a x=1 200 1" {
+		t.Fatalf("unexpected text: %q", line)
+	}
+
+	if _, err := newField("unknown"); err == nil {
+		t.Fatal("expect invalid field error")
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	reqs []string
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testServerStream) RecvMsg(m interface{}) error {
+	if len(s.reqs) == 0 {
+		return io.EOF
+	}
+	m.(*wrapperspb.StringValue).Value, s.reqs = s.reqs[0], s.reqs[1:]
+	return nil
+}
+
+func (s *testServerStream) SendMsg(m interface{}) error {
+	return nil
+}
+
+func TestGrpcAccess(t *testing.T) {
+	var lines []map[string]interface{}
+	a := &Access{format: FormatJson, logger: &log.Logger{Level: log.INFO, Log: func(level log.Level, args ...interface{}) {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(fmt.Sprint(args...)), &m); err != nil {
+			t.Fatalf("invalid json %v: %v", args, err)
+		}
+		lines = append(lines, m)
+	}}}
+	enabled := func(fullMethod string) bool {
+		return fullMethod != "/demo.Service/Off"
+	}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+
+	unary := a.UnaryServerInterceptor(enabled)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		time.Sleep(10 * time.Millisecond)
+		return &wrapperspb.StringValue{Value: "world!"}, status.Error(codes.NotFound, "not found")
+	}
+	for _, method := range []string{"/demo.Service/Off", "/demo.Service/Unary"} {
+		unary(ctx, &wrapperspb.StringValue{Value: "hello"}, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("expect 1 unary line, got %v", lines)
+	}
+	m := lines[0]
+	if m["kind"] != KindGrpc || m["peer"] != "10.0.0.1:1234" || m["method"] != "/demo.Service/Unary" || m["code"] != "NotFound" ||
+		m["req_bytes"] != float64(7) || m["rsp_bytes"] != float64(8) || m["req_msgs"] != nil {
+		t.Fatalf("unexpected unary line: %v", m)
+	}
+	if v, _ := m["latency_us"].(float64); v < 10000 {
+		t.Fatalf("unexpected unary latency: %v", m["latency_us"])
+	}
+
+	lines = nil
+	stream := a.StreamServerInterceptor(enabled)
+	echo := func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			req := new(wrapperspb.StringValue)
+			if err := ss.RecvMsg(req); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := ss.SendMsg(&wrapperspb.StringValue{Value: req.Value + "!"}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, method := range []string{"/demo.Service/Off", "/demo.Service/Stream"} {
+		stream(nil, &testServerStream{ctx: ctx, reqs: []string{"a", "bb"}}, &grpc.StreamServerInfo{FullMethod: method}, echo)
+	}
+	if len(lines) != 1 {
+		t.Fatalf("expect 1 stream line, got %v", lines)
+	}
+	m = lines[0]
+	if m["kind"] != KindGrpc || m["peer"] != "10.0.0.1:1234" || m["method"] != "/demo.Service/Stream" || m["code"] != "OK" ||
+		m["req_bytes"] != float64(7) || m["rsp_bytes"] != float64(9) || m["req_msgs"] != float64(2) || m["rsp_msgs"] != float64(2) {
+		t.Fatalf("unexpected stream line: %v", m)
+	}
+	if _, ok := m["latency_us"].(float64); !ok {
+		t.Fatalf("missing stream latency: %v", m)
+	}
+}
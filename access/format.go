@@ -0,0 +1,145 @@
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/obase/kit"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatText   = "text"   // 空格分隔的值
+	FormatJson   = "json"   // 每行一个json对象
+	FormatLogfmt = "logfmt" // key=value
+
+	FieldRemote    = "remote"     // 客户端IP
+	FieldMethod    = "method"     // 请求方法
+	FieldPath      = "path"       // 请求路径
+	FieldQuery     = "query"      // 查询串
+	FieldStatus    = "status"     // 响应状态
+	FieldLatency   = "latency"    // 耗时(毫秒)
+	FieldLatencyUs = "latency_us" // 耗时(微秒)
+	FieldReqBytes  = "req_bytes"  // 请求体字节数
+	FieldRspBytes  = "rsp_bytes"  // 响应体字节数
+	FieldUserAgent = "user_agent"
+	FieldReferer   = "referer"
+	FieldRequestId = "request_id" // X-Request-Id请求头
+	FieldTraceId   = "trace_id"
+	FieldPackage   = "package" // 结点的package
+	FieldService   = "service" // 结点的service
+	FieldRpc       = "rpc"     // 结点的service method
+	FieldRoute     = "route"   // 结点的路由模式
+	FieldCache     = "cache"   // 缓存命中: hit | miss, 未缓存为空
+	FieldUpstream  = "upstream"
+	FieldHeader    = "header:" // 请求头, 例如"header:X-Device-Id", 输出键为x_device_id
+)
+
+// 默认字段, 与旧版text格式一致(trace_id为空时省略)
+var DefaultFields = []string{FieldRemote, FieldMethod, FieldPath, FieldQuery, FieldStatus, FieldLatency, FieldTraceId}
+
+var knownFields = map[string]bool{
+	FieldRemote: true, FieldMethod: true, FieldPath: true, FieldQuery: true, FieldStatus: true,
+	FieldLatency: true, FieldLatencyUs: true, FieldReqBytes: true, FieldRspBytes: true,
+	FieldUserAgent: true, FieldReferer: true, FieldRequestId: true, FieldTraceId: true,
+	FieldPackage: true, FieldService: true, FieldRpc: true, FieldRoute: true,
+	FieldCache: true, FieldUpstream: true,
+}
+
+type field struct {
+	name   string
+	key    string // json/logfmt的输出键
+	header string
+}
+
+func errInvalid(kind string, val string) error {
+	return errors.New(fmt.Sprintf("invalid access %s: %v", kind, val))
+}
+
+func newField(name string) (*field, error) {
+	name = strings.TrimSpace(name)
+	if strings.HasPrefix(name, FieldHeader) {
+		header := strings.TrimSpace(name[len(FieldHeader):])
+		if header == "" {
+			return nil, errInvalid("field", name)
+		}
+		return &field{
+			name:   FieldHeader,
+			key:    strings.ToLower(strings.Replace(header, "-", "_", -1)),
+			header: header,
+		}, nil
+	}
+	name = strings.ToLower(name)
+	if !knownFields[name] {
+		return nil, errInvalid("field", name)
+	}
+	return &field{name: name, key: name}, nil
+}
+
+// 一个输出项, num为true时json输出不加引号
+type kv struct {
+	key string
+	val string
+	num bool
+}
+
+func (a *Access) format2(r *record) string {
+	kvs := make([]kv, len(a.fields))
+	for i, f := range a.fields {
+		val, num := r.value(f)
+		kvs[i] = kv{key: f.key, val: val, num: num}
+	}
+	return a.line(kvs)
+}
+
+func (a *Access) line(kvs []kv) string {
+	buf := kit.GetStringBuffer()
+	defer kit.PutStringBuffer(buf)
+
+	switch a.format {
+	case FormatJson:
+		buf.WriteByte('{')
+		for i, v := range kvs {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJsonString(buf, v.key)
+			buf.WriteByte(':')
+			if v.num {
+				buf.WriteString(v.val)
+			} else {
+				writeJsonString(buf, v.val)
+			}
+		}
+		buf.WriteByte('}')
+	case FormatLogfmt:
+		for i, v := range kvs {
+			if i > 0 {
+				buf.WriteByte(SPACE)
+			}
+			buf.WriteString(v.key)
+			buf.WriteByte('=')
+			if v.val == "" || strings.ContainsAny(v.val, " =\"\t\r\n") {
+				buf.WriteString(strconv.Quote(v.val))
+			} else {
+				buf.WriteString(v.val)
+			}
+		}
+	default:
+		for i, v := range kvs {
+			if i > 0 {
+				buf.WriteByte(SPACE)
+			}
+			buf.WriteString(v.val)
+		}
+		// 兼容旧格式: 末尾的空值(例如没有trace_id)不输出分隔符
+		return strings.TrimRight(buf.String(), " ")
+	}
+	return buf.String()
+}
+
+func writeJsonString(buf *kit.StringBuffer, s string) {
+	bs, _ := json.Marshal(s)
+	buf.Write(bs)
+}
@@ -14,6 +14,8 @@ type Config struct {
 	RotateBytes     int64         `json:"rotateBytes" bson:"rotateBytes" yaml:"rotateBytes"`
 	RotateCycle     string        `json:"rotateCycle" bson:"rotateCycle" yaml:"rotateCycle"`             //轮转周期,目前仅支持
 	BufioWriterSize int           `json:"bufioWriterSize" bson:"bufioWriterSize" yaml:"bufioWriterSize"` //Buffer写缓存大小
+	Format          string        `json:"format" bson:"format" yaml:"format"`                            // text | json | logfmt, 默认text
+	Fields          []string      `json:"fields" bson:"fields" yaml:"fields"`                            // 输出字段, 默认DefaultFields
 }
 
 func NewLogger(ctx context.Context, c *Config) (ret *log.Logger, err error) {
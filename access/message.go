@@ -0,0 +1,136 @@
+package access
+
+import (
+	"context"
+	"github.com/obase/pbapi/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"strconv"
+	"time"
+)
+
+const (
+	KindGrpc = "grpc"
+	KindWbsk = "wbsk"
+)
+
+/*
+grpc调用或websocket消息的access记录, 与http共用logger与format, 字段固定:
+kind, peer, method, code, latency_us, req_bytes, rsp_bytes, trace_id
+stream调用的req_bytes/rsp_bytes为全部消息累计, 另加req_msgs/rsp_msgs
+*/
+type Entry struct {
+	Kind     string
+	Peer     string
+	Method   string // grpc为FullMethod, websocket为service.method
+	Code     string // grpc为codes.Code, websocket为Response.Code
+	Latency  time.Duration
+	ReqBytes int
+	RspBytes int
+	ReqMsgs  int // 仅stream
+	RspMsgs  int // 仅stream
+	TraceId  string
+}
+
+func (a *Access) Log(e *Entry) {
+	if a == nil {
+		return
+	}
+	kvs := []kv{
+		{key: "kind", val: e.Kind},
+		{key: "peer", val: e.Peer},
+		{key: "method", val: e.Method},
+		{key: "code", val: e.Code},
+		{key: FieldLatencyUs, val: strconv.FormatInt(int64(e.Latency/time.Microsecond), 10), num: true},
+		{key: FieldReqBytes, val: strconv.Itoa(e.ReqBytes), num: true},
+		{key: FieldRspBytes, val: strconv.Itoa(e.RspBytes), num: true},
+	}
+	if e.ReqMsgs > 0 || e.RspMsgs > 0 {
+		kvs = append(kvs,
+			kv{key: "req_msgs", val: strconv.Itoa(e.ReqMsgs), num: true},
+			kv{key: "rsp_msgs", val: strconv.Itoa(e.RspMsgs), num: true})
+	}
+	kvs = append(kvs, kv{key: FieldTraceId, val: e.TraceId})
+	a.logger.Info(a.line(kvs))
+}
+
+func messageSize(m interface{}) int {
+	if pm, ok := m.(proto.Message); ok {
+		return proto.Size(pm)
+	}
+	return 0
+}
+
+func peerAddr(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// enabled判断FullMethod是否需要记录, 由ServerConfig的grpcAccess决定
+func (a *Access) UnaryServerInterceptor(enabled func(fullMethod string) bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !enabled(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		rsp, err := handler(ctx, req)
+		a.Log(&Entry{
+			Kind:     KindGrpc,
+			Peer:     peerAddr(ctx),
+			Method:   info.FullMethod,
+			Code:     status.Code(err).String(),
+			Latency:  time.Since(start),
+			ReqBytes: messageSize(req),
+			RspBytes: messageSize(rsp),
+			TraceId:  trace.TraceIDFromContext(ctx),
+		})
+		return rsp, err
+	}
+}
+
+type countStream struct {
+	grpc.ServerStream
+	entry *Entry
+}
+
+func (s *countStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.entry.ReqMsgs++
+		s.entry.ReqBytes += messageSize(m)
+	}
+	return err
+}
+
+func (s *countStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.entry.RspMsgs++
+		s.entry.RspBytes += messageSize(m)
+	}
+	return err
+}
+
+func (a *Access) StreamServerInterceptor(enabled func(fullMethod string) bool) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !enabled(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		entry := &Entry{
+			Kind:   KindGrpc,
+			Peer:   peerAddr(ss.Context()),
+			Method: info.FullMethod,
+		}
+		err := handler(srv, &countStream{ServerStream: ss, entry: entry})
+		entry.Code = status.Code(err).String()
+		entry.Latency = time.Since(start)
+		entry.TraceId = trace.TraceIDFromContext(ss.Context())
+		a.Log(entry)
+		return err
+	}
+}
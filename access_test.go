@@ -0,0 +1,190 @@
+package pbapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/obase/log"
+	"github.com/obase/pbapi/access"
+	"github.com/obase/pbapi/grpc_health_v1"
+	"google.golang.org/grpc"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 以json格式收集access记录
+type accessLines struct {
+	sync.Mutex
+	lines []map[string]interface{}
+}
+
+func (a *accessLines) take() []map[string]interface{} {
+	a.Lock()
+	defer a.Unlock()
+	ret := a.lines
+	a.lines = nil
+	return ret
+}
+
+func newTestAccess(t *testing.T) (*access.Access, *accessLines) {
+	ret := new(accessLines)
+	a, err := access.New(&log.Logger{Level: log.INFO, Log: func(level log.Level, args ...interface{}) {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(fmt.Sprint(args...)), &m); err != nil {
+			t.Errorf("invalid json %v: %v", args, err)
+			return
+		}
+		ret.Lock()
+		ret.lines = append(ret.lines, m)
+		ret.Unlock()
+	}}, &access.Config{Format: access.FormatJson})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, ret
+}
+
+func TestWbskAccess(t *testing.T) {
+	s := NewServer()
+	s.RegisterService(grpc_health_v1.RegisterHealthServerHandler, demoHealth{})
+	a, lines := newTestAccess(t)
+
+	chat := func(config *Config, reqs ...string) (string, []string) {
+		engine, err := s.compileHttpEngine(mergeConfig(config), &httpRuntime{accesslog: a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts := httptest.NewServer(engine)
+		defer ts.Close()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/v1/health/check", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var rsps []string
+		for _, req := range reqs {
+			conn.WriteMessage(websocket.TextMessage, []byte(req))
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rsps = append(rsps, string(msg))
+		}
+		return conn.LocalAddr().String(), rsps
+	}
+
+	// 默认关闭
+	wbsk := &ServerConfig{WbskOff: false, SetWbskOff: true}
+	if chat(&Config{ServerConfig: []*ServerConfig{wbsk}}, `{"service":"demo"}`); len(lines.take()) != 0 {
+		t.Fatal("expect no access log without wbskAccess")
+	}
+	reqs := []string{`{"service":"demo"}`, `{"service":"none"}`}
+	local, rsps := chat(&Config{ServerConfig: []*ServerConfig{wbsk, {WbskAccess: true}}}, reqs...)
+	ms := lines.take()
+	if len(ms) != 2 {
+		t.Fatalf("expect 2 access lines, got %v", ms)
+	}
+	for i, code := range []float64{SUCCESS, EXECUTE_SERVICE_ERROR} {
+		m := ms[i]
+		if m["kind"] != access.KindWbsk || m["peer"] != local || m["method"] != "Health.Check" || m["code"] != fmt.Sprint(code) ||
+			m["req_bytes"] != float64(len(reqs[i])) || m["rsp_bytes"] != float64(len(rsps[i])) {
+			t.Errorf("unexpected access line %v: %v", i, m)
+		}
+		if _, ok := m["latency_us"].(float64); !ok {
+			t.Errorf("missing latency %v: %v", i, m)
+		}
+	}
+	// 显式关闭
+	if chat(&Config{ServerConfig: []*ServerConfig{wbsk, {WbskAccess: false, SetWbskAccess: true}}}, reqs[0]); len(lines.take()) != 0 {
+		t.Fatal("expect no access log with wbskAccess off")
+	}
+}
+
+func TestGrpcAccess(t *testing.T) {
+	s := NewServer()
+	s.RegisterService(grpc_health_v1.RegisterHealthServerHandler, demoHealth{})
+	newStreamServer(s)
+	a, lines := newTestAccess(t)
+
+	call := func(config *Config) string {
+		config = mergeConfig(config)
+		for _, handler := range s.serviceHandlers {
+			handler.setting = s.serviceSetting(handler, config)
+		}
+		opts, err := s.compileServerOptions(config, &grpcRuntime{accesslog: a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gs := grpc.NewServer(opts...)
+		for _, handler := range s.serviceHandlers {
+			gs.RegisterService(handler.ServiceDesc, handler.ServiceImpl)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go gs.Serve(ln)
+		defer gs.Stop()
+		cc, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cc.Close()
+
+		client := grpc_health_v1.NewHealthClient(cc)
+		for _, service := range []string{"demo", "none"} {
+			client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		}
+		cs, err := cc.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/demo.StreamService/Watch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs.SendMsg(&grpc_health_v1.HealthCheckRequest{Service: "demo"})
+		cs.CloseSend()
+		for {
+			if err := cs.RecvMsg(new(grpc_health_v1.HealthCheckResponse)); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return ln.Addr().String()
+	}
+
+	if call(&Config{}); len(lines.take()) != 0 {
+		t.Fatal("expect no access log without grpcAccess")
+	}
+	call(&Config{ServerConfig: []*ServerConfig{{GrpcAccess: true}}})
+	ms := lines.take()
+	if len(ms) != 3 {
+		t.Fatalf("expect 3 access lines, got %v", ms)
+	}
+	for i, expect := range []map[string]interface{}{
+		{"method": "/grpc.health.v1.Health/Check", "code": "OK", "req_bytes": float64(6), "rsp_bytes": float64(2)},
+		{"method": "/grpc.health.v1.Health/Check", "code": "Unknown", "req_bytes": float64(6), "rsp_bytes": float64(0)},
+		{"method": "/demo.StreamService/Watch", "code": "OK", "req_bytes": float64(6), "rsp_bytes": float64(6), "req_msgs": float64(1), "rsp_msgs": float64(3)},
+	} {
+		m := ms[i]
+		if m["kind"] != access.KindGrpc || !strings.HasPrefix(fmt.Sprint(m["peer"]), "127.0.0.1:") {
+			t.Errorf("unexpected access line %v: %v", i, m)
+		}
+		if _, ok := m["latency_us"].(float64); !ok {
+			t.Errorf("missing latency %v: %v", i, m)
+		}
+		for k, v := range expect {
+			if m[k] != v {
+				t.Errorf("access line %v: expect %v=%v, got %v", i, k, v, m[k])
+			}
+		}
+	}
+	// 仅开启的方法记录
+	call(&Config{ServerConfig: []*ServerConfig{{Method: "Watch", GrpcAccess: true}}})
+	if ms := lines.take(); len(ms) != 1 || ms[0]["method"] != "/demo.StreamService/Watch" {
+		t.Fatalf("expect only Watch logged, got %v", ms)
+	}
+}
@@ -0,0 +1,73 @@
+package pbapi
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/proxy"
+	"net/http"
+)
+
+/*
+管理接口, 挂在adminPath下:
+GET {adminPath}/breakers: 代理熔断器状态
+POST {adminPath}/cache/purge: 删除http缓存, 请求体{"keys":[], "prefixes":[], "tags":[]}
+指标接口metrics.path只经过adminPlugins, 不经routerPlugins以免鉴权类插件拦截抓取
+注意: 请通过adminPlugins(例如hostsallow)限制访问来源, adminPlugins为空时不注册cache/purge
+*/
+const (
+	ADMIN_BREAKERS_PATH    = "/breakers"
+	ADMIN_CACHE_PURGE_PATH = "/cache/purge"
+)
+
+func (server *Server) registerAdmin(engine *gin.Engine, config *Config, routerFilter gin.HandlersChain, breakers []*proxy.Breaker, runtime *httpRuntime) error {
+	// 结点路径均以/开头, admin不会与之冲突
+	adminFilter, err := server.compileRouterPlugins(config.AdminPlugins, "admin", runtime.states, nil)
+	if err != nil {
+		return err
+	}
+	stat, httpCache := runtime.metrics, runtime.cache
+	if stat != nil {
+		engine.GET(stat.Config.Path, newHandlersChain(false, nil, adminFilter, nil, nil, stat.Handler())...)
+	}
+	if config.AdminPath == "" {
+		return nil
+	}
+	group := engine.Group(config.AdminPath, newHandlersChain(false, nil, routerFilter, adminFilter, nil, nil)...)
+	group.GET(ADMIN_BREAKERS_PATH, func(ctx *gin.Context) {
+		states := make([]*proxy.BreakerState, len(breakers))
+		for i, b := range breakers {
+			states[i] = b.State()
+		}
+		ctx.JSON(http.StatusOK, &Response{
+			Code: SUCCESS,
+			Data: states,
+		})
+	})
+	// 删除缓存属于写操作, 未配置adminPlugins限制来源时不注册
+	if httpCache != nil && len(adminFilter) > 0 {
+		group.POST(ADMIN_CACHE_PURGE_PATH, newPurgeHandlerFunc(httpCache))
+	}
+	return nil
+}
+
+// 熔断打开时的响应: 503 + Response
+func createFallbackHandler(fallback *Response) http.HandlerFunc {
+	if fallback == nil {
+		fallback = &Response{
+			Code: CIRCUIT_OPEN_ERROR,
+			Msg:  "circuit breaker open",
+		}
+	}
+	bs, err := json.Marshal(fallback)
+	if err != nil {
+		bs, _ = json.Marshal(&Response{
+			Code: CIRCUIT_OPEN_ERROR,
+			Msg:  err.Error(),
+		})
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(bs)
+	}
+}
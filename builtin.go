@@ -1,14 +1,206 @@
 package pbapi
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/obase/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
 )
 
-func HostsallowServerPlugin(args []string) grpc.ServerOption {
-	return nil
+const (
+	HEADER_X_FORWARDED_FOR = "X-Forwarded-For"
+	MD_X_FORWARDED_FOR     = "x-forwarded-for" // grpc的metadata一律小写
+	HOSTSALLOW_PROXY       = "proxy:"          // 可信代理前缀, 例如"proxy:10.0.0.0/8"
+)
+
+/*
+hostsallow的参数规则:
+1. IP: 127.0.0.1, ::1
+2. CIDR: 192.168.0.0/16
+3. 通配: 10.11.*, 192.168.?.1
+4. 可信代理: proxy:<上述任意形式>, 仅当请求直连来源是可信代理时才采信X-Forwarded-For
+*/
+type HostsMatcher struct {
+	ips      []net.IP
+	nets     []*net.IPNet
+	patterns []*regexp.Regexp
+}
+
+func NewHostsMatcher(args []string) *HostsMatcher {
+	m := new(HostsMatcher)
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if arg == "" {
+			continue
+		}
+		if strings.ContainsAny(arg, "*?") {
+			m.patterns = append(m.patterns, compileHostPattern(arg))
+		} else if strings.IndexByte(arg, '/') != -1 {
+			if _, ipnet, err := net.ParseCIDR(arg); err == nil {
+				m.nets = append(m.nets, ipnet)
+			} else {
+				log.Errorf("hostsallow invalid cidr: %v, %v", arg, err)
+			}
+		} else if ip := net.ParseIP(arg); ip != nil {
+			m.ips = append(m.ips, ip)
+		} else {
+			log.Errorf("hostsallow invalid host: %v", arg)
+		}
+	}
+	return m
+}
+
+func (m *HostsMatcher) Match(host string) bool {
+	if m == nil || host == "" {
+		return false
+	}
+	for _, p := range m.patterns {
+		if p.MatchString(host) {
+			return true
+		}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, v := range m.ips {
+		if v.Equal(ip) {
+			return true
+		}
+	}
+	for _, v := range m.nets {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 与PatternMatchs相同的通配规则, 但其余字符按字面匹配(例如'.')
+func compileHostPattern(p string) *regexp.Regexp {
+	buf := new(strings.Builder)
+	buf.WriteByte('^')
+	for _, c := range p {
+		switch c {
+		case '?':
+			buf.WriteByte('.')
+		case '*':
+			buf.WriteString(".*")
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	buf.WriteByte('$')
+	return regexp.MustCompile(buf.String())
+}
+
+type Hostsallow struct {
+	Allows  *HostsMatcher
+	Proxies *HostsMatcher
+}
+
+func NewHostsallow(args []string) *Hostsallow {
+	var allows, proxies []string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, HOSTSALLOW_PROXY) {
+			proxies = append(proxies, arg[len(HOSTSALLOW_PROXY):])
+		} else {
+			allows = append(allows, arg)
+		}
+	}
+	return &Hostsallow{
+		Allows:  NewHostsMatcher(allows),
+		Proxies: NewHostsMatcher(proxies),
+	}
+}
+
+/*
+计算真实客户端IP: 直连来源若是可信代理, 则从X-Forwarded-For自右向左跳过可信代理, 首个不可信地址即为客户端.
+注意: 不能直接使用gin.Context.ClientIP(), 它无条件采信X-Forwarded-For, 可被伪造.
+*/
+func (h *Hostsallow) ClientIP(remote string, forwarded []string) string {
+	if !h.Proxies.Match(remote) {
+		return remote
+	}
+	client := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hops := strings.Split(forwarded[i], ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := strings.TrimSpace(hops[j])
+			if hop == "" {
+				continue
+			}
+			client = hop
+			if !h.Proxies.Match(hop) {
+				return client
+			}
+		}
+	}
+	return client
+}
+
+func (h *Hostsallow) Allow(remote string, forwarded []string) bool {
+	return h.Allows.Match(h.ClientIP(remote, forwarded))
+}
+
+func RemoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(strings.TrimSpace(addr)); err == nil {
+		return host
+	}
+	return addr
 }
 
 func HostsallowRouterPlugin(args []string) gin.HandlersChain {
-	return nil
+	hosts := NewHostsallow(args)
+	return gin.HandlersChain{func(ctx *gin.Context) {
+		if !hosts.Allow(RemoteHost(ctx.Request.RemoteAddr), ctx.Request.Header[HEADER_X_FORWARDED_FOR]) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, &Response{
+				Code: ACCESS_DENIED_ERROR,
+				Msg:  "host not allowed",
+			})
+		}
+	}}
+}
+
+// 兼容旧的ServerPlugin签名. 一个ServerOption只能携带一种拦截器, 故仅作用于unary方法, 需覆盖stream方法时使用HostsallowInterceptorPlugin
+func HostsallowServerPlugin(args []string) grpc.ServerOption {
+	unary, _ := HostsallowInterceptorPlugin(args)
+	return grpc.ChainUnaryInterceptor(unary)
+}
+
+func HostsallowInterceptorPlugin(args []string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	hosts := NewHostsallow(args)
+	allow := func(ctx context.Context) error {
+		var remote string
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			remote = RemoteHost(p.Addr.String())
+		}
+		var forwarded []string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			forwarded = md.Get(MD_X_FORWARDED_FOR)
+		}
+		if !hosts.Allow(remote, forwarded) {
+			return status.Error(codes.PermissionDenied, "host not allowed")
+		}
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := allow(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := allow(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
 }
@@ -0,0 +1,68 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostsallow(t *testing.T) {
+	h := NewHostsallow([]string{"127.0.0.1", "192.168.0.0/16", "10.11.*", "proxy:172.16.0.1"})
+	cases := []struct {
+		remote    string
+		forwarded []string
+		allow     bool
+	}{
+		{"127.0.0.1", nil, true},
+		{"192.168.2.21", nil, true},
+		{"10.11.165.44", nil, true},
+		{"10.12.165.44", nil, false},
+		{"8.8.8.8", []string{"127.0.0.1"}, false},   // 不可信来源不采信X-Forwarded-For
+		{"172.16.0.1", []string{"127.0.0.1"}, true}, // 可信代理
+		{"172.16.0.1", []string{"127.0.0.1, 8.8.8.8"}, false},
+		{"172.16.0.1", []string{"8.8.8.8, 127.0.0.1"}, true},
+	}
+	for _, c := range cases {
+		if ret := h.Allow(c.remote, c.forwarded); ret != c.allow {
+			t.Errorf("allow %v %v: expect %v, got %v", c.remote, c.forwarded, c.allow, ret)
+		}
+	}
+	// 旧签名仍可通过ServerPlugin注册
+	s := NewServer()
+	s.ServerPlugin("hostsallow", HostsallowServerPlugin)
+	if opts, err := s.compileServerOptions(mergeConfig(&Config{ServerPlugins: [][]string{{"hostsallow", "127.0.0.1"}}}), &grpcRuntime{}); err != nil || len(opts) == 0 {
+		t.Fatalf("compile hostsallow server plugin: %v %v", opts, err)
+	}
+}
+
+func TestJwt(t *testing.T) {
+	engine := gin.New()
+	engine.POST("/test", append(JwtRouterPlugin([]string{"secret:s3cret", "iss:pbapi"}), CreateHandlerFunc4Http("test", func(ctx context.Context, _ []byte) (interface{}, error) {
+		return jwt.FromContext(ctx).Subject(), nil
+	}))...)
+	token, _ := jwt.Sign(jwt.HS256, "", []byte("s3cret"), jwt.Claims{"sub": "u1", "iss": "pbapi", "exp": time.Now().Unix() + 60})
+	for auth, expect := range map[string]int{
+		"":                  http.StatusUnauthorized,
+		"Bearer invalid":    http.StatusUnauthorized,
+		"Bearer " + token:   http.StatusOK,
+		"bearer   " + token: http.StatusOK,
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/test", nil)
+		if auth != "" {
+			req.Header.Set(HEADER_AUTHORIZATION, auth)
+		}
+		engine.ServeHTTP(w, req)
+		if w.Code != expect {
+			t.Fatalf("%q: expect %v, got %v", auth, expect, w.Code)
+		}
+		if expect == http.StatusOK && !strings.Contains(w.Body.String(), `"data":"u1"`) {
+			t.Fatalf("claims not injected: %s", w.Body.String())
+		}
+	}
+}
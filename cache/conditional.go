@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 按缓存策略的Cache-Control. key包含请求头或cookie时为private, 避免共享缓存返回给其他用户
+func cacheControl(policy *Policy) string {
+	ret := "max-age=" + strconv.FormatInt(policy.Seconds, 10)
+	if policy.Key != nil && (len(policy.Key.Headers) > 0 || len(policy.Key.Cookies) > 0) {
+		ret = "private, " + ret
+	}
+	if policy.StaleWhileRevalidate > 0 {
+		ret += ", stale-while-revalidate=" + strconv.FormatInt(policy.StaleWhileRevalidate, 10)
+	}
+	if policy.StaleIfError > 0 {
+		ret += ", stale-if-error=" + strconv.FormatInt(policy.StaleIfError, 10)
+	}
+	return ret
+}
+
+// 优先沿用处理器设置的ETag与Last-Modified, 否则ETag为内容的哈希, Last-Modified为保存时间
+func validators(rsp *Response, now time.Time) {
+	for i, n := range rsp.Hname {
+		if len(rsp.Hvals[i]) == 0 {
+			continue
+		}
+		switch n {
+		case "Etag":
+			rsp.Etag = rsp.Hvals[i][0]
+		case "Last-Modified":
+			if t, err := http.ParseTime(rsp.Hvals[i][0]); err == nil {
+				rsp.Mtime = t.Unix()
+			}
+		}
+	}
+	if rsp.Etag == "" {
+		m5 := md5.Sum(rsp.Rdata)
+		rsp.Etag = `"` + hex.EncodeToString(m5[:8]) + `"`
+	}
+	if rsp.Mtime == 0 {
+		rsp.Mtime = now.Unix()
+	}
+}
+
+// 弱比较, 忽略W/前缀
+func etagMatch(inm string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range strings.Split(inm, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// 只对GET/HEAD的200结果生效, If-None-Match优先于If-Modified-Since
+func notModified(r *http.Request, rsp *Response) bool {
+	if (r.Method != http.MethodGet && r.Method != http.MethodHead) || rsp.Status != http.StatusOK {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return rsp.Etag != "" && etagMatch(inm, rsp.Etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && rsp.Mtime > 0 {
+		if t, err := http.ParseTime(ims); err == nil {
+			return rsp.Mtime <= t.Unix()
+		}
+	}
+	return false
+}
+
+// 返回缓存的结果, 附加ETag, Last-Modified, Cache-Control(处理器未设置时)与Age, 条件请求命中时返回304
+func serve(ctx *gin.Context, e *entry, policy *Policy, cc string, now int64) {
+	writer := ctx.Writer
+	header := writer.Header()
+	for i, n := 0, len(e.Hname); i < n; i++ {
+		header[e.Hname[i]] = e.Hvals[i]
+	}
+	if e.Etag != "" {
+		header.Set("ETag", e.Etag)
+	}
+	if e.Mtime > 0 {
+		header.Set("Last-Modified", time.Unix(e.Mtime, 0).UTC().Format(http.TimeFormat))
+	}
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", cc)
+	}
+	age := (now - e.fresh + policy.Seconds*int64(time.Second)) / int64(time.Second)
+	if age < 0 {
+		age = 0
+	}
+	header.Set("Age", strconv.FormatInt(age, 10))
+
+	if notModified(ctx.Request, e.Response) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		writer.WriteHeader(http.StatusNotModified)
+		writer.WriteHeaderNow()
+		return
+	}
+	writer.WriteHeader(int(e.Status))
+	writer.Write(e.Rdata)
+}
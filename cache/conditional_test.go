@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheConditional(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/a", c.CacheWith(&Policy{Seconds: 60, StaleWhileRevalidate: 10}, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, "a")
+	}))
+	engine.GET("/b", c.Cache(60, func(ctx *gin.Context) {
+		ctx.Header("ETag", `"v1"`)
+		ctx.String(http.StatusOK, "b")
+	}))
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/a")
+	etag, modified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag == "" || modified == "" || w.Header().Get("Age") != "0" ||
+		w.Header().Get("Cache-Control") != "max-age=60, stale-while-revalidate=10" {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Header())
+	}
+	cases := []struct {
+		header []string
+		status int
+	}{
+		{[]string{"If-None-Match", etag}, http.StatusNotModified},
+		{[]string{"If-None-Match", `"x", W/` + etag}, http.StatusNotModified},
+		{[]string{"If-None-Match", `"x"`}, http.StatusOK},
+		{[]string{"If-Modified-Since", modified}, http.StatusNotModified},
+		{[]string{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"}, http.StatusOK},
+		// If-None-Match优先
+		{[]string{"If-None-Match", `"x"`, "If-Modified-Since", modified}, http.StatusOK},
+	}
+	for _, cs := range cases {
+		w := get("/a", cs.header...)
+		if w.Code != cs.status || w.Header().Get("ETag") != etag {
+			t.Errorf("%v: expect %v, got %v %v", cs.header, cs.status, w.Code, w.Header())
+		}
+		if cs.status == http.StatusNotModified && w.Body.Len() > 0 {
+			t.Errorf("%v: expect empty body, got %q", cs.header, w.Body.String())
+		}
+	}
+
+	// 沿用处理器的ETag
+	get("/b")
+	if w := get("/b", "If-None-Match", `"v1"`); w.Code != http.StatusNotModified {
+		t.Errorf("expect handler etag: %v %v", w.Code, w.Header())
+	}
+}
@@ -3,14 +3,18 @@ package cache
 import (
 	"github.com/obase/redis.v2"
 	"math"
+	"time"
 )
 
 type Config struct {
-	redis.Config  `bson:",inline" yaml:",inline"`
-	Type          string `json:"type" bson:"type" yaml:"type"`
-	MaxMemorySize int    `json:"maxMemorySize" bson:"maxMemorySize" yaml:"maxMemorySize"`
-	MinStatusCode int    `json:"minStatusCode" bson:"minStatusCode" yaml:"minStatusCode"`
-	MaxStatusCode int    `json:"maxStatusCode" bson:"maxStatusCode" yaml:"maxStatusCode"`
+	redis.Config   `bson:",inline" yaml:",inline"`
+	Type           string        `json:"type" bson:"type" yaml:"type"`
+	MaxMemorySize  int           `json:"maxMemorySize" bson:"maxMemorySize" yaml:"maxMemorySize"`    // memory缓存最大条目数
+	MaxMemoryBytes int64         `json:"maxMemoryBytes" bson:"maxMemoryBytes" yaml:"maxMemoryBytes"` // memory缓存最大字节数(响应体与头部), 默认64M
+	MemoryShards   int           `json:"memoryShards" bson:"memoryShards" yaml:"memoryShards"`       // memory缓存分片数, 默认16
+	ExpireInterval time.Duration `json:"expireInterval" bson:"expireInterval" yaml:"expireInterval"` // memory缓存清理过期条目的间隔, 默认1m
+	MinStatusCode  int           `json:"minStatusCode" bson:"minStatusCode" yaml:"minStatusCode"`
+	MaxStatusCode  int           `json:"maxStatusCode" bson:"maxStatusCode" yaml:"maxStatusCode"`
 }
 
 func mergeConfig(config *Config) *Config {
@@ -22,6 +26,18 @@ func mergeConfig(config *Config) *Config {
 		config.MaxMemorySize = math.MaxInt16
 	}
 
+	if config.MaxMemoryBytes == 0 {
+		config.MaxMemoryBytes = 64 << 20
+	}
+
+	if config.MemoryShards <= 0 {
+		config.MemoryShards = 16
+	}
+
+	if config.ExpireInterval <= 0 {
+		config.ExpireInterval = time.Minute
+	}
+
 	if config.MinStatusCode == 0 {
 		config.MinStatusCode = 200
 	}
@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"sync"
+)
+
+type call struct {
+	done chan struct{}
+	e    *entry   // 返回给等待者的结果, 为空时等待者各自处理
+	key  string   // e对应的缓存key
+	vary []string // e的Vary请求头
+}
+
+// 结果有Vary时, 只返回给请求头取值相同的等待者
+func (c *call) match(ctx *gin.Context, base string) bool {
+	key := base
+	if len(c.vary) > 0 {
+		key = varyKey(ctx, base, c.vary)
+	}
+	return c.e != nil && key == c.key
+}
+
+// 同key的并发请求只由领头请求执行处理器, 其余等待其结果. 仅合并进程内的请求
+type flight struct {
+	sync.Mutex
+	calls map[string]*call
+}
+
+func newFlight() *flight {
+	return &flight{calls: make(map[string]*call)}
+}
+
+// 返回true表示领头请求, 处理结束后必须调用leave
+func (f *flight) join(key string) (*call, bool) {
+	f.Lock()
+	defer f.Unlock()
+	if c, ok := f.calls[key]; ok {
+		return c, false
+	}
+	c := &call{done: make(chan struct{})}
+	f.calls[key] = c
+	return c, true
+}
+
+func (f *flight) leave(key string, c *call) {
+	f.Lock()
+	delete(f.calls, key)
+	f.Unlock()
+	close(c.done)
+}
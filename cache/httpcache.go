@@ -2,11 +2,14 @@ package cache
 
 import (
 	"bytes"
-	"crypto/md5"
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/obase/kit"
+	"github.com/obase/log"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 )
 
 func New(config *Config) Cache {
@@ -24,24 +27,6 @@ func New(config *Config) Cache {
 	return nil
 }
 
-func ckey(r *http.Request, rbuf *bytes.Buffer) string {
-
-	buffer := kit.GetBytesBuffer()
-	defer kit.PutBytesBuffer(buffer)
-
-	buffer.Reset()
-	buffer.WriteString(r.Method)
-	buffer.WriteString(":")
-	buffer.WriteString(r.URL.Path)
-	buffer.WriteString(":")
-	buffer.WriteString(r.URL.RawQuery)
-	if r.Method == http.MethodPost || r.Method == http.MethodPatch || r.Method == http.MethodPut {
-		m5 := md5.Sum(rbuf.Bytes())
-		buffer.Write(m5[:])
-	}
-	return buffer.String()
-}
-
 func chead(header http.Header) [][]string {
 	ret := make([][]string, 0, len(header))
 	for k, v := range header {
@@ -77,3 +62,220 @@ func read(writer *CacheResponseWriter) *Response {
 	copy(resp.Rdata, writer.Buffer.Bytes()) // 因为buffer需要重用,此处必须复制
 	return resp
 }
+
+// 缓存条目, fresh为新鲜期截止(unix纳秒). path/base/tags仅在保存时使用: 请求路径, Vary条目的原key, 标签
+type entry struct {
+	fresh int64
+	path  string
+	base  string
+	tags  []string
+	*Response
+}
+
+// memory与redis的存储, 条目在新鲜期后还需保留policy.grace()秒
+type store interface {
+	load(key string, now int64) *entry
+	save(key string, e *entry, policy *Policy)
+}
+
+// memory与redis共用的处理: 合并同key的并发未命中, 过期后按策略返回旧条目
+type wrapper struct {
+	*stats
+	config *Config
+	store  store
+	flight *flight
+}
+
+func (w *wrapper) wrap(policy *Policy, f gin.HandlerFunc) gin.HandlerFunc {
+
+	swr := policy.StaleWhileRevalidate * int64(time.Second)
+	sie := policy.StaleIfError * int64(time.Second)
+	timeout := policy.timeout()
+	cc := cacheControl(policy)
+
+	return func(ctx *gin.Context) {
+		buf := kit.GetBytesBuffer()
+		defer kit.PutBytesBuffer(buf)
+
+		buf.Reset()
+		body, err := CopyCacheRequestBody(ctx.Request.Body, buf)
+		if err != nil {
+			ctx.Request.Body = errorRequestBody{err}
+			passthrough(ctx, f)
+			return
+		}
+		ctx.Request.Body = body
+		base := ckey(ctx, buf, policy.Key)
+
+		now := time.Now().UnixNano()
+		key, vary, e := w.lookup(ctx, base, now)
+		if e != nil && now < e.fresh {
+			w.hit()
+			ctx.Set(CONTEXT_KEY, CacheHit)
+			serve(ctx, e, policy, cc, now)
+			return
+		}
+		if e != nil && now < e.fresh+swr {
+			w.stale()
+			ctx.Set(CONTEXT_KEY, CacheStale)
+			serve(ctx, e, policy, cc, now)
+			// 已有刷新中的请求则不再发起
+			if c, leader := w.flight.join(key); leader {
+				go w.refresh(detach(ctx, buf.Bytes()), base, key, c, policy, f)
+			}
+			return
+		}
+
+		c, leader := w.flight.join(key)
+		if !leader {
+			var matched bool
+			timer := time.NewTimer(timeout)
+			select {
+			case <-c.done:
+				matched = c.match(ctx, base)
+			case <-timer.C:
+			case <-ctx.Request.Context().Done():
+				timer.Stop()
+				ctx.Abort()
+				return
+			}
+			timer.Stop()
+			if matched {
+				w.coalesce()
+				ctx.Set(CONTEXT_KEY, CacheCoalesced)
+				serve(ctx, c.e, policy, cc, time.Now().UnixNano())
+				return
+			}
+			// 领头请求没有可缓存的结果或超时未返回, 各自处理
+			w.miss()
+			ctx.Set(CONTEXT_KEY, CacheMiss)
+			passthrough(ctx, f)
+			return
+		}
+		defer w.flight.leave(key, c)
+		w.miss()
+		ctx.Set(CONTEXT_KEY, CacheMiss)
+
+		// 请求体仍引用buf, 响应使用另外的buffer. 先暂存响应, 以便计算ETag或失败时返回旧条目
+		wbuf := kit.GetBytesBuffer()
+		defer kit.PutBytesBuffer(wbuf)
+		wbuf.Reset()
+
+		stale := e != nil && now < e.fresh+sie
+		writer := ctx.Writer
+		cw := NewCacheResponseWriter(newBufferWriter(writer), wbuf)
+		withTags(ctx)
+		failed := invoke(ctx, cw, key, f, stale)
+		if stale && (failed || cw.Status() >= http.StatusInternalServerError) {
+			w.stale()
+			ctx.Set(CONTEXT_KEY, CacheStale)
+			serve(ctx, e, policy, cc, time.Now().UnixNano())
+			c.e, c.key, c.vary = e, key, vary
+			return
+		}
+		if c.e, c.key, c.vary = w.save(ctx, base, cw, policy); c.e != nil {
+			serve(ctx, c.e, policy, cc, time.Now().UnixNano())
+		} else {
+			write(writer, read(cw))
+		}
+	}
+}
+
+// 原key保存Vary标记时, 改为查找附加请求头取值的key
+func (w *wrapper) lookup(ctx *gin.Context, base string, now int64) (string, []string, *entry) {
+	e := w.store.load(base, now)
+	if e == nil || e.Status != 0 {
+		return base, nil, e
+	}
+	vary := e.Hvals[0]
+	key := varyKey(ctx, base, vary)
+	return key, vary, w.store.load(key, now)
+}
+
+/*
+只会缓存state位于MinStatusCode~MaxStatusCode之间的结果, 返回条目及其key与Vary请求头.
+遵循Vary时条目保存到附加请求头取值的key, 原key保存Vary标记(status为0); Vary为*时不缓存
+*/
+func (w *wrapper) save(ctx *gin.Context, base string, writer *CacheResponseWriter, policy *Policy) (*entry, string, []string) {
+	// 标签头仅供内部使用, 不缓存的响应同样不能返回给客户端
+	tags := tagsOf(ctx, writer.Header())
+	writer.Header().Del(TAGS_HEADER)
+	if status := writer.Status(); status < w.config.MinStatusCode || status > w.config.MaxStatusCode {
+		return nil, "", nil
+	}
+	var vary []string
+	if policy.Key != nil && policy.Key.Vary {
+		var ok bool
+		if vary, ok = varyNames(writer.Header()); !ok {
+			return nil, "", nil
+		}
+	}
+	now := time.Now()
+	fresh := now.UnixNano() + policy.Seconds*int64(time.Second)
+	path := ctx.Request.URL.Path
+	e := &entry{fresh: fresh, path: path, tags: tags}
+	key := base
+	if len(vary) > 0 {
+		w.store.save(base, &entry{fresh: fresh, path: path, Response: &Response{Hname: []string{"Vary"}, Hvals: [][]string{vary}}}, policy)
+		key = varyKey(ctx, base, vary)
+		e.base = base
+	}
+	e.Response = read(writer)
+	validators(e.Response, now)
+	w.store.save(key, e, policy)
+	return e, key, vary
+}
+
+// 后台刷新, 失败时保留旧条目. 请求已与客户端分离, 须有期限以免上游挂起时一直占用刷新
+func (w *wrapper) refresh(ctx *gin.Context, base string, key string, c *call, policy *Policy, f gin.HandlerFunc) {
+	defer w.flight.leave(key, c)
+	rctx, cancel := context.WithTimeout(ctx.Request.Context(), policy.timeout())
+	defer cancel()
+	ctx.Request = ctx.Request.WithContext(rctx)
+	defer func() {
+		if perr := recover(); perr != nil {
+			log.Errorf("refresh cache %v: %v", key, perr)
+		}
+	}()
+
+	wbuf := kit.GetBytesBuffer()
+	defer kit.PutBytesBuffer(wbuf)
+	wbuf.Reset()
+	ctx.Writer = NewCacheResponseWriter(ctx.Writer, wbuf)
+	withTags(ctx) // detach复制了原请求的Keys, 须另建
+	f(ctx)
+	c.e, c.key, c.vary = w.save(ctx, base, ctx.Writer.(*CacheResponseWriter), policy)
+}
+
+// 以暂存的writer执行处理器, 结束后恢复原writer. recoverable为true时拦截panic并返回true, 由调用方返回旧条目
+func invoke(ctx *gin.Context, cw *CacheResponseWriter, key string, f gin.HandlerFunc, recoverable bool) (failed bool) {
+	writer := ctx.Writer
+	ctx.Writer = cw
+	defer func() {
+		ctx.Writer = writer
+		if !recoverable {
+			return
+		}
+		if perr := recover(); perr != nil {
+			log.Errorf("serve stale cache %v: %v", key, perr)
+			failed = true
+		}
+	}()
+	f(ctx)
+	return
+}
+
+// 复制请求供后台刷新, 原gin.Context在处理结束后会被重用
+func detach(ctx *gin.Context, body []byte) *gin.Context {
+	ret, _ := gin.CreateTestContext(newBufferWriter(nil))
+	ret.Request = ctx.Request.Clone(context.Background())
+	ret.Request.Body = ioutil.NopCloser(bytes.NewReader(append([]byte(nil), body...)))
+	ret.Params = append(ret.Params, ctx.Params...)
+	if len(ctx.Keys) > 0 {
+		ret.Keys = make(map[string]interface{}, len(ctx.Keys))
+		for k, v := range ctx.Keys {
+			ret.Keys[k] = v
+		}
+	}
+	return ret
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func request(engine *gin.Engine, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestCacheCoalesce(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/a", c.Cache(60, func(ctx *gin.Context) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		ctx.String(http.StatusOK, "a")
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := request(engine, "/a"); w.Body.String() != "a" {
+				t.Errorf("unexpected body: %v", w.Body.String())
+			}
+		}()
+	}
+	// 等待其余请求进入, 迟到的请求直接命中
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if st := c.Stats(); calls != 1 || st.Misses != 1 || st.Hits+st.Coalesced != 9 {
+		t.Fatalf("expect single call: %v %+v", calls, st)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	var status int32 = http.StatusOK
+	refreshed := make(chan struct{}, 1)
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	policy := &Policy{Seconds: 1, StaleWhileRevalidate: 10, StaleIfError: 60}
+	engine.GET("/a", c.CacheWith(policy, func(ctx *gin.Context) {
+		ctx.String(int(atomic.LoadInt32(&status)), "new")
+		select {
+		case refreshed <- struct{}{}:
+		default:
+		}
+	}))
+	old := &Response{Status: http.StatusOK, Rdata: []byte("old")}
+	// 按保存时间写入旧条目
+	saveAt := func(ago time.Duration) {
+		c.save("GET:/a:", &entry{fresh: time.Now().Add(time.Duration(policy.Seconds)*time.Second - ago).UnixNano(), Response: old}, policy)
+	}
+
+	// staleWhileRevalidate: 返回旧条目, 后台刷新
+	saveAt(2 * time.Second)
+	if w := request(engine, "/a"); w.Body.String() != "old" {
+		t.Fatalf("expect stale: %v", w.Body.String())
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("expect background refresh")
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if c.Stats().Stores == 2 {
+			break
+		}
+	}
+	if w := request(engine, "/a"); w.Body.String() != "new" || c.Stats().Hits != 1 {
+		t.Fatalf("expect refreshed: %v %+v", w.Body.String(), c.Stats())
+	}
+
+	// staleIfError: 处理失败时返回旧条目
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	saveAt(20 * time.Second)
+	if w := request(engine, "/a"); w.Code != http.StatusOK || w.Body.String() != "old" {
+		t.Fatalf("expect stale on error: %v %v", w.Code, w.Body.String())
+	}
+	// 超出staleIfError后返回失败结果
+	saveAt(100 * time.Second)
+	if w := request(engine, "/a"); w.Code != http.StatusInternalServerError || w.Body.String() != "new" {
+		t.Fatalf("expect error: %v %v", w.Code, w.Body.String())
+	}
+	if st := c.Stats(); st.Stales != 2 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestCacheTimeout(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	var calls int32
+	release := make(chan struct{})
+	deadline := make(chan error, 1)
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	policy := &Policy{Seconds: 1, StaleWhileRevalidate: 10, Timeout: 50 * time.Millisecond}
+	// 首个请求挂起且不理会context
+	engine.GET("/a", c.CacheWith(policy, func(ctx *gin.Context) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+		}
+		ctx.String(http.StatusOK, "a")
+	}))
+	engine.GET("/b", c.CacheWith(policy, func(ctx *gin.Context) {
+		<-ctx.Request.Context().Done()
+		deadline <- ctx.Request.Context().Err()
+	}))
+	defer close(release)
+
+	// 等待者超过期限后自行处理
+	go request(engine, "/a")
+	time.Sleep(10 * time.Millisecond)
+	start := time.Now()
+	if w := request(engine, "/a"); w.Body.String() != "a" || time.Since(start) > 500*time.Millisecond || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expect fallback: %v after %v, calls %v", w.Body.String(), time.Since(start), calls)
+	}
+
+	// 后台刷新带有期限
+	c.save("GET:/b:", &entry{fresh: time.Now().Add(-time.Second).UnixNano(), Response: &Response{Status: http.StatusOK, Rdata: []byte("old")}}, policy)
+	if w := request(engine, "/b"); w.Body.String() != "old" {
+		t.Fatalf("expect stale: %v", w.Body.String())
+	}
+	select {
+	case err := <-deadline:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expect deadline exceeded: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect refresh deadline")
+	}
+}
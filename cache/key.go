@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/kit"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+/*
+缓存key的组成, 默认为method, path, raw query及请求体(POST/PATCH/PUT)的MD5:
+1. headers/cookies: 附加的请求头与cookie
+2. query/ignoreQuery: 仅包含或忽略的query参数, sortQuery按参数排序
+3. bodyFields/ignoreBodyFields: 仅包含或忽略的json请求体字段(点号分隔路径), ignoreBody不包含请求体
+4. vary: 遵循处理器响应的Vary头, 按其中的请求头区分条目
+*/
+type KeySpec struct {
+	Headers          []string `json:"headers" bson:"headers" yaml:"headers"`
+	Cookies          []string `json:"cookies" bson:"cookies" yaml:"cookies"`
+	Query            []string `json:"query" bson:"query" yaml:"query"`
+	IgnoreQuery      []string `json:"ignoreQuery" bson:"ignoreQuery" yaml:"ignoreQuery"`
+	SortQuery        bool     `json:"sortQuery" bson:"sortQuery" yaml:"sortQuery"`
+	BodyFields       []string `json:"bodyFields" bson:"bodyFields" yaml:"bodyFields"`
+	IgnoreBodyFields []string `json:"ignoreBodyFields" bson:"ignoreBodyFields" yaml:"ignoreBodyFields"`
+	IgnoreBody       bool     `json:"ignoreBody" bson:"ignoreBody" yaml:"ignoreBody"`
+	Vary             bool     `json:"vary" bson:"vary" yaml:"vary"`
+}
+
+func ckey(ctx *gin.Context, rbuf *bytes.Buffer, spec *KeySpec) string {
+
+	r := ctx.Request
+	buffer := kit.GetBytesBuffer()
+	defer kit.PutBytesBuffer(buffer)
+
+	buffer.Reset()
+	buffer.WriteString(r.Method)
+	buffer.WriteString(":")
+	buffer.WriteString(r.URL.Path)
+	buffer.WriteString(":")
+	if spec == nil {
+		buffer.WriteString(r.URL.RawQuery)
+	} else {
+		buffer.WriteString(spec.query(r.URL.RawQuery))
+	}
+	if r.Method == http.MethodPost || r.Method == http.MethodPatch || r.Method == http.MethodPut {
+		if spec == nil {
+			m5 := md5.Sum(rbuf.Bytes())
+			buffer.Write(m5[:])
+		} else if !spec.IgnoreBody {
+			m5 := md5.Sum(spec.body(rbuf.Bytes()))
+			buffer.Write(m5[:])
+		}
+	}
+	if spec != nil {
+		for _, h := range spec.Headers {
+			buffer.WriteString(":")
+			buffer.WriteString(h)
+			buffer.WriteString("=")
+			buffer.WriteString(strings.Join(r.Header.Values(h), ","))
+		}
+		for _, n := range spec.Cookies {
+			buffer.WriteString(":cookie.")
+			buffer.WriteString(n)
+			buffer.WriteString("=")
+			if c, err := r.Cookie(n); err == nil {
+				buffer.WriteString(c.Value)
+			}
+		}
+	}
+	// 同一请求的不同表示(例如内容协商), 由前置中间件设置
+	if vary := ctx.GetString(VARY_KEY); vary != "" {
+		buffer.WriteString(":")
+		buffer.WriteString(vary)
+	}
+	return buffer.String()
+}
+
+// 保留参数的原始编码, 只做过滤与排序
+func (s *KeySpec) query(raw string) string {
+	if raw == "" || (len(s.Query) == 0 && len(s.IgnoreQuery) == 0 && !s.SortQuery) {
+		return raw
+	}
+	pairs := strings.Split(raw, "&")
+	ret := pairs[:0]
+	for _, p := range pairs {
+		if p == "" {
+			continue
+		}
+		name := p
+		if i := strings.IndexByte(p, '='); i >= 0 {
+			name = p[:i]
+		}
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if (len(s.Query) > 0 && !contains(s.Query, name)) || contains(s.IgnoreQuery, name) {
+			continue
+		}
+		ret = append(ret, p)
+	}
+	if s.SortQuery {
+		sort.Strings(ret)
+	}
+	return strings.Join(ret, "&")
+}
+
+// 非json请求体按原样参与计算
+func (s *KeySpec) body(data []byte) []byte {
+	if len(s.BodyFields) == 0 && len(s.IgnoreBodyFields) == 0 {
+		return data
+	}
+	var val interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&val); err != nil {
+		return data
+	}
+	if len(s.BodyFields) > 0 {
+		buffer := new(bytes.Buffer)
+		for _, f := range s.BodyFields {
+			buffer.WriteString(f)
+			buffer.WriteString("=")
+			if v, ok := field(val, f, false); ok {
+				bs, _ := json.Marshal(v)
+				buffer.Write(bs)
+			}
+			buffer.WriteString(";")
+		}
+		return buffer.Bytes()
+	}
+	for _, f := range s.IgnoreBodyFields {
+		field(val, f, true)
+	}
+	// map按key排序输出, 字段顺序不影响结果
+	bs, err := json.Marshal(val)
+	if err != nil {
+		return data
+	}
+	return bs
+}
+
+// 按点号路径查找字段, remove为true时删除
+func field(val interface{}, path string, remove bool) (interface{}, bool) {
+	names := strings.Split(path, ".")
+	for i, n := range names {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if i == len(names)-1 && remove {
+			delete(m, n)
+			return nil, true
+		}
+		if val, ok = m[n]; !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
+
+func contains(vs []string, v string) bool {
+	for _, s := range vs {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 响应Vary头中的请求头, 规范化并排序. 缓存保存未压缩的响应, 忽略Accept-Encoding. 包含*时返回nil, false
+func varyNames(header http.Header) ([]string, bool) {
+	var ret []string
+	for _, v := range header.Values("Vary") {
+		for _, n := range strings.Split(v, ",") {
+			n = strings.TrimSpace(n)
+			if n == "*" {
+				return nil, false
+			}
+			n = http.CanonicalHeaderKey(n)
+			if n == "" || n == "Accept-Encoding" || contains(ret, n) {
+				continue
+			}
+			ret = append(ret, n)
+		}
+	}
+	sort.Strings(ret)
+	return ret, true
+}
+
+// 附加Vary请求头取值的key
+func varyKey(ctx *gin.Context, key string, names []string) string {
+	buffer := bytes.NewBufferString(key)
+	buffer.WriteString(":vary")
+	for _, n := range names {
+		buffer.WriteString(":")
+		buffer.WriteString(n)
+		buffer.WriteString("=")
+		buffer.WriteString(strings.Join(ctx.Request.Header.Values(n), ","))
+	}
+	return buffer.String()
+}
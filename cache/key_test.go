@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testKey(spec *KeySpec, method string, target string, body string, header map[string]string) string {
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		ctx.Request.Header.Set(k, v)
+	}
+	return ckey(ctx, bytes.NewBufferString(body), spec)
+}
+
+func TestKeySpec(t *testing.T) {
+	cases := []struct {
+		spec  *KeySpec
+		a, b  []string // method, target, body, header(k=v)
+		equal bool
+	}{
+		{nil, []string{"GET", "/a?x=1&y=2", "", ""}, []string{"GET", "/a?y=2&x=1", "", ""}, false},
+		{&KeySpec{SortQuery: true}, []string{"GET", "/a?x=1&y=2", "", ""}, []string{"GET", "/a?y=2&x=1", "", ""}, true},
+		{&KeySpec{IgnoreQuery: []string{"_t"}}, []string{"GET", "/a?x=1&_t=1", "", ""}, []string{"GET", "/a?x=1&_t=2", "", ""}, true},
+		{&KeySpec{Query: []string{"x"}}, []string{"GET", "/a?x=1&y=1", "", ""}, []string{"GET", "/a?x=2&y=1", "", ""}, false},
+		{nil, []string{"GET", "/a", "", "Accept-Language=en"}, []string{"GET", "/a", "", "Accept-Language=zh"}, true},
+		{&KeySpec{Headers: []string{"accept-language"}}, []string{"GET", "/a", "", "Accept-Language=en"}, []string{"GET", "/a", "", "Accept-Language=zh"}, false},
+		{&KeySpec{Cookies: []string{"tenant"}}, []string{"GET", "/a", "", "Cookie=tenant=1"}, []string{"GET", "/a", "", "Cookie=tenant=2"}, false},
+		{&KeySpec{IgnoreBodyFields: []string{"meta.ts"}}, []string{"POST", "/a", `{"id":1,"meta":{"ts":1}}`, ""}, []string{"POST", "/a", `{"meta":{"ts":2},"id":1}`, ""}, true},
+		{&KeySpec{BodyFields: []string{"id"}}, []string{"POST", "/a", `{"id":1,"x":1}`, ""}, []string{"POST", "/a", `{"id":1,"x":2}`, ""}, true},
+		{&KeySpec{BodyFields: []string{"id"}}, []string{"POST", "/a", `{"id":1}`, ""}, []string{"POST", "/a", `{"id":2}`, ""}, false},
+		{&KeySpec{IgnoreBody: true}, []string{"POST", "/a", `a`, ""}, []string{"POST", "/a", `b`, ""}, true},
+	}
+	header := func(kv string) map[string]string {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			return map[string]string{kv[:i]: kv[i+1:]}
+		}
+		return nil
+	}
+	for i, c := range cases {
+		ka := testKey(c.spec, c.a[0], c.a[1], c.a[2], header(c.a[3]))
+		kb := testKey(c.spec, c.b[0], c.b[1], c.b[2], header(c.b[3]))
+		if (ka == kb) != c.equal {
+			t.Errorf("case %v: expect equal %v: %q %q", i, c.equal, ka, kb)
+		}
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	engine.GET("/a", c.CacheWith(&Policy{Seconds: 60, Key: &KeySpec{Vary: true}}, func(ctx *gin.Context) {
+		ctx.Header("Vary", "Accept-Language, Accept-Encoding")
+		ctx.String(http.StatusOK, ctx.GetHeader("Accept-Language"))
+	}))
+	get := func(lang string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/a", nil)
+		r.Header.Set("Accept-Language", lang)
+		engine.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+	for i := 0; i < 2; i++ {
+		for _, lang := range []string{"en", "zh"} {
+			if body := get(lang); body != lang {
+				t.Fatalf("expect %v, got %v", lang, body)
+			}
+		}
+	}
+	if st := c.Stats(); st.Misses != 2 || st.Hits != 2 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
@@ -1,81 +1,285 @@
 package cache
 
 import (
+	"container/list"
 	"github.com/gin-gonic/gin"
-	"github.com/obase/kit"
+	"hash/fnv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 条目存入后不再修改, 更新时整体替换
 type memoryEntry struct {
-	Time int64
+	key    string
+	fresh  int64 // 新鲜期截止, unix纳秒
+	expire int64 // 移除时间, unix纳秒
+	size   int64
+	tags   []string
 	*Response
 }
 
+func entrySize(key string, rsp *Response) int64 {
+	size := len(key) + len(rsp.Rdata)
+	for i, n := range rsp.Hname {
+		size += len(n)
+		for _, v := range rsp.Hvals[i] {
+			size += len(v)
+		}
+	}
+	return int64(size)
+}
+
+// 分片内按LRU淘汰, 条目数与字节数均不超出上限
+type memoryShard struct {
+	sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List // 头部为最近使用
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+}
+
+func (s *memoryShard) get(key string, now int64) (*memoryEntry, bool) {
+	s.Lock()
+	defer s.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if now >= entry.expire {
+		s.remove(el)
+		return nil, true
+	}
+	s.lru.MoveToFront(el)
+	return entry, false
+}
+
+// 返回淘汰的条目数, 超出单个分片容量的条目不保存
+func (s *memoryShard) set(entry *memoryEntry) (stored bool, evicted uint64) {
+	if entry.size > s.maxBytes {
+		return false, 0
+	}
+	s.Lock()
+	defer s.Unlock()
+	if el, ok := s.items[entry.key]; ok {
+		s.bytes += entry.size - el.Value.(*memoryEntry).size
+		el.Value = entry
+		s.lru.MoveToFront(el)
+	} else {
+		s.items[entry.key] = s.lru.PushFront(entry)
+		s.bytes += entry.size
+	}
+	for s.lru.Len() > s.maxEntries || s.bytes > s.maxBytes {
+		s.remove(s.lru.Back())
+		evicted++
+	}
+	return true, evicted
+}
+
+func (s *memoryShard) remove(el *list.Element) {
+	entry := s.lru.Remove(el).(*memoryEntry)
+	delete(s.items, entry.key)
+	s.bytes -= entry.size
+}
+
+func (s *memoryShard) sweep(now int64) (expired uint64) {
+	s.Lock()
+	defer s.Unlock()
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if now >= el.Value.(*memoryEntry).expire {
+			s.remove(el)
+			expired++
+		}
+		el = prev
+	}
+	return
+}
+
+func (s *memoryShard) purge(match func(entry *memoryEntry) bool) (purged uint64) {
+	s.Lock()
+	defer s.Unlock()
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if match(el.Value.(*memoryEntry)) {
+			s.remove(el)
+			purged++
+		}
+		el = prev
+	}
+	return
+}
+
+func (s *memoryShard) size() (entries int, bytes int64) {
+	s.Lock()
+	entries, bytes = s.lru.Len(), s.bytes
+	s.Unlock()
+	return
+}
+
 type memoryCache struct {
+	stats
 	*Config
-	sync.RWMutex
-	Data map[string]*memoryEntry
+	shards  []*memoryShard
+	wrapper *wrapper
+	done    chan struct{}
+	closed  int32
 }
 
 func newMemoryCache(config *Config) *memoryCache {
-	return &memoryCache{
+	n := config.MemoryShards
+	c := &memoryCache{
 		Config: config,
-		Data:   make(map[string]*memoryEntry),
+		shards: make([]*memoryShard, n),
+		done:   make(chan struct{}),
+	}
+	c.wrapper = &wrapper{stats: &c.stats, config: config, store: c, flight: newFlight()}
+	// 上限均分到各分片, 至少为1
+	maxEntries := (config.MaxMemorySize + n - 1) / n
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	maxBytes := (config.MaxMemoryBytes + int64(n) - 1) / int64(n)
+	for i := range c.shards {
+		c.shards[i] = &memoryShard{
+			items:      make(map[string]*list.Element),
+			lru:        list.New(),
+			maxEntries: maxEntries,
+			maxBytes:   maxBytes,
+		}
 	}
+	go c.expireLoop()
+	return c
 }
 
-func (c *memoryCache) Cache(seconds int64, f gin.HandlerFunc) gin.HandlerFunc {
+func (c *memoryCache) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
 
-	if seconds <= 0 {
-		return f
+func (c *memoryCache) get(key string, now int64) *memoryEntry {
+	entry, expired := c.shard(key).get(key, now)
+	if expired {
+		c.expire(1)
 	}
+	return entry
+}
 
-	return func(ctx *gin.Context) {
-		buf := kit.GetBytesBuffer()
-		defer kit.PutBytesBuffer(buf)
+func (c *memoryCache) set(entry *memoryEntry) {
+	stored, evicted := c.shard(entry.key).set(entry)
+	if stored {
+		c.store()
+	}
+	if evicted > 0 {
+		c.evict(evicted)
+	}
+}
 
-		buf.Reset()
-		ctx.Request.Body = DupCacheRequestBody(ctx.Request.Body, buf)
-		key := ckey(ctx.Request, buf)
+func (c *memoryCache) expireLoop() {
+	ticker := time.NewTicker(c.Config.ExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case t := <-ticker.C:
+			now := t.UnixNano()
+			for _, s := range c.shards {
+				if n := s.sweep(now); n > 0 {
+					c.expire(n)
+				}
+			}
+		}
+	}
+}
 
-		c.RWMutex.RLock()
-		entry, ok := c.Data[key]
-		c.RWMutex.RUnlock()
+func (c *memoryCache) load(key string, now int64) *entry {
+	if e := c.get(key, now); e != nil {
+		return &entry{fresh: e.fresh, Response: e.Response}
+	}
+	return nil
+}
 
-		now := time.Now().Unix()
-		if ok && now-entry.Time < seconds {
-			write(ctx.Writer, entry.Response)
-			return
+func (c *memoryCache) save(key string, e *entry, policy *Policy) {
+	c.set(&memoryEntry{
+		key:      key,
+		fresh:    e.fresh,
+		expire:   e.fresh + policy.grace()*int64(time.Second),
+		size:     entrySize(key, e.Response),
+		tags:     e.tags,
+		Response: e.Response,
+	})
+}
+
+func (c *memoryCache) Cache(seconds int64, f gin.HandlerFunc) gin.HandlerFunc {
+	return c.CacheWith(&Policy{Seconds: seconds}, f)
+}
+
+func (c *memoryCache) CacheWith(policy *Policy, f gin.HandlerFunc) gin.HandlerFunc {
+	if policy == nil || policy.Seconds <= 0 {
+		return f
+	}
+	return c.wrapper.wrap(policy, f)
+}
+
+// 按条件删除, 需要遍历全部分片
+func (c *memoryCache) purgeBy(match func(entry *memoryEntry) bool) {
+	for _, s := range c.shards {
+		if n := s.purge(match); n > 0 {
+			c.purge(n)
 		}
+	}
+}
 
-		buf.Reset()
-		ctx.Writer = NewCacheResponseWriter(ctx.Writer, buf)
-		f(ctx)
-		// 只会缓存state位于200~400之间的结果
-		if status := ctx.Writer.Status(); status >= c.Config.MinStatusCode && status <= c.Config.MaxStatusCode {
-			if entry == nil {
-				entry = new(memoryEntry)
-				// 理论上面entry也是需要同步控制,为了性能此处舍弃!
-				entry.Time = now
-				entry.Response = read(ctx.Writer.(*CacheResponseWriter))
-				c.RWMutex.Lock()
-				if len(c.Data) >= c.MaxMemorySize {
-					c.Data = make(map[string]*memoryEntry) // 重新释放
-				}
-				c.Data[key] = entry
-				c.RWMutex.Unlock()
-			} else {
-				// 理论上面entry也是需要同步控制,为了性能此处舍弃!
-				entry.Time = now
-				entry.Response = read(ctx.Writer.(*CacheResponseWriter))
+func (c *memoryCache) Purge(key string) error {
+	c.purgeBy(func(entry *memoryEntry) bool {
+		return isVariant(entry.key, key)
+	})
+	return nil
+}
+
+func (c *memoryCache) PurgePrefix(path string) error {
+	c.purgeBy(func(entry *memoryEntry) bool {
+		return strings.HasPrefix(pathOf(entry.key), path)
+	})
+	return nil
+}
+
+func (c *memoryCache) PurgeTags(tags ...string) error {
+	c.purgeBy(func(entry *memoryEntry) bool {
+		for _, t := range entry.tags {
+			if contains(tags, t) {
+				return true
 			}
 		}
+		return false
+	})
+	return nil
+}
+
+func (c *memoryCache) Stats() *Stats {
+	ret := c.stats.Stats()
+	for _, s := range c.shards {
+		entries, bytes := s.size()
+		ret.Entries += uint64(entries)
+		ret.Bytes += uint64(bytes)
 	}
+	return ret
 }
 
 func (c *memoryCache) Close() {
-	c.RWMutex.Lock()
-	c.Data = nil
-	c.RWMutex.Unlock()
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		close(c.done)
+		for _, s := range c.shards {
+			s.Lock()
+			s.items = make(map[string]*list.Element)
+			s.lru.Init()
+			s.bytes = 0
+			s.Unlock()
+		}
+	}
 }
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func serveCached(c Cache, paths ...string) func(path string) string {
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	handler := c.Cache(60, func(ctx *gin.Context) {
+		ctx.String(http.StatusOK, strings.Repeat("x", 100)+ctx.Request.URL.Path)
+	})
+	for _, p := range paths {
+		engine.GET(p, handler)
+	}
+	return func(path string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		engine.ServeHTTP(w, r)
+		return w.Body.String()
+	}
+}
+
+func TestMemoryCacheLRU(t *testing.T) {
+	c := newMemoryCache(mergeConfig(&Config{MemoryShards: 1, MaxMemorySize: 2}))
+	defer c.Close()
+	get := serveCached(c, "/a", "/b", "/c")
+
+	get("/a")
+	get("/b")
+	get("/a") // a最近使用
+	get("/c") // 淘汰b
+	if st := c.Stats(); st.Entries != 2 || st.Evictions != 1 || st.Hits != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+	get("/a")
+	get("/b")
+	if st := c.Stats(); st.Hits != 2 || st.Misses != 4 {
+		t.Fatalf("expect a hit and b miss: %+v", st)
+	}
+	if body := get("/a"); !strings.HasSuffix(body, "/a") {
+		t.Errorf("unexpected body: %v", body)
+	}
+}
+
+func TestMemoryCacheBytes(t *testing.T) {
+	c := newMemoryCache(mergeConfig(&Config{MemoryShards: 1, MaxMemoryBytes: 300}))
+	defer c.Close()
+	get := serveCached(c, "/a", "/b", "/c")
+	for _, p := range []string{"/a", "/b", "/c"} {
+		get(p)
+	}
+	st := c.Stats()
+	if st.Bytes > 300 || st.Entries != 2 || st.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", st)
+	}
+}
+
+func TestMemoryCacheExpire(t *testing.T) {
+	c := newMemoryCache(mergeConfig(&Config{MemoryShards: 2}))
+	defer c.Close()
+	now := time.Now().UnixNano()
+	for _, key := range []string{"a", "b", "c"} {
+		rsp := &Response{Status: 200, Rdata: []byte(key)}
+		c.set(&memoryEntry{key: key, expire: now + int64(time.Second), size: entrySize(key, rsp), Response: rsp})
+	}
+	if c.get("a", now) == nil {
+		t.Fatal("expect a")
+	}
+	// 读取时过期
+	if c.get("a", now+int64(2*time.Second)) != nil {
+		t.Fatal("expect a expired")
+	}
+	// 后台清理
+	var expired uint64
+	for _, s := range c.shards {
+		expired += s.sweep(now + int64(2*time.Second))
+	}
+	if expired != 2 || c.Stats().Entries != 0 || c.Stats().Bytes != 0 {
+		t.Fatalf("expect all expired: %v %+v", expired, c.Stats())
+	}
+}
+
+func TestMemoryCacheConcurrent(t *testing.T) {
+	c := newMemoryCache(mergeConfig(&Config{MaxMemorySize: 8}))
+	defer c.Close()
+	paths := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h", "/i", "/j"}
+	get := serveCached(c, paths...)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				p := paths[(i+j)%len(paths)]
+				if body := get(p); !strings.HasSuffix(body, p) {
+					t.Errorf("unexpected body for %v: %v", p, body)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if st := c.Stats(); st.Hits+st.Misses != 800 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
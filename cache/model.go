@@ -5,6 +5,9 @@ import (
 	"bytes"
 	"github.com/gin-gonic/gin"
 	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -13,21 +16,149 @@ const (
 	MEMORY string = "memory"
 
 	BufferBlockSize = 10240 // 10k
+
+	CONTEXT_KEY    = "pbapi.cache.status" // gin.Context中记录的缓存状态: hit | miss | stale | coalesced
+	VARY_KEY       = "pbapi.cache.vary"   // gin.Context中附加到缓存key的区分值
+	TAGS_KEY       = "pbapi.cache.tags"   // gin.Context中条目的标签, 见Tag()
+	TAGS_HEADER    = "X-Cache-Tags"       // 处理器设置的条目标签, 逗号分隔, 不会返回给客户端
+	CacheHit       = "hit"
+	CacheMiss      = "miss"
+	CacheStale     = "stale"     // 返回过期条目(staleWhileRevalidate或staleIfError)
+	CacheCoalesced = "coalesced" // 等待同key的并发请求填充后返回
 )
 
+// 缓存策略, 单位均为秒
+type Policy struct {
+	Seconds              int64         // 新鲜期
+	StaleWhileRevalidate int64         // 过期后仍返回旧条目并由单个后台请求刷新的时长
+	StaleIfError         int64         // 过期后处理失败(5xx或panic)时返回旧条目的时长
+	Key                  *KeySpec      // 缓存key的组成, 为空使用默认
+	Timeout              time.Duration // 领头请求的处理期限: 后台刷新的context期限及等待者的最长等待, 0取Seconds
+}
+
+func (p *Policy) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+	return time.Duration(p.Seconds) * time.Second
+}
+
+// 条目在新鲜期后仍需保留的时长
+func (p *Policy) grace() int64 {
+	if p.StaleWhileRevalidate > p.StaleIfError {
+		return p.StaleWhileRevalidate
+	}
+	return p.StaleIfError
+}
+
 type Cache interface {
 	Cache(seconds int64, h gin.HandlerFunc) gin.HandlerFunc
+	CacheWith(policy *Policy, h gin.HandlerFunc) gin.HandlerFunc
+	Purge(key string) error         // 删除缓存key(见ckey)的条目及其Vary条目
+	PurgePrefix(path string) error  // 删除请求路径以path开头的条目
+	PurgeTags(tags ...string) error // 删除带有任一标签的条目
+	Stats() *Stats
 	Close()
 }
 
+// 缓存统计快照
+type Stats struct {
+	Hits      uint64 `json:"hits"`      // 命中
+	Misses    uint64 `json:"misses"`    // 未命中
+	Stores    uint64 `json:"stores"`    // 写入
+	Evictions uint64 `json:"evictions"` // 因容量淘汰
+	Expires   uint64 `json:"expires"`   // 因过期清理
+	Stales    uint64 `json:"stales"`    // 返回过期条目
+	Coalesced uint64 `json:"coalesced"` // 合并到同key并发请求
+	Purges    uint64 `json:"purges"`    // 主动删除
+	Entries   uint64 `json:"entries"`   // 当前条目数, 仅memory
+	Bytes     uint64 `json:"bytes"`     // 当前字节数, 仅memory
+}
+
+// 各实现内嵌的计数器, 必须位于结构体首位保证64位原子操作对齐
+type stats struct {
+	hits      uint64
+	misses    uint64
+	stores    uint64
+	evictions uint64
+	expires   uint64
+	stales    uint64
+	coalesced uint64
+	purges    uint64
+}
+
+func (s *stats) hit() {
+	atomic.AddUint64(&s.hits, 1)
+}
+
+func (s *stats) miss() {
+	atomic.AddUint64(&s.misses, 1)
+}
+
+func (s *stats) store() {
+	atomic.AddUint64(&s.stores, 1)
+}
+
+func (s *stats) evict(n uint64) {
+	atomic.AddUint64(&s.evictions, n)
+}
+
+func (s *stats) expire(n uint64) {
+	atomic.AddUint64(&s.expires, n)
+}
+
+func (s *stats) stale() {
+	atomic.AddUint64(&s.stales, 1)
+}
+
+func (s *stats) coalesce() {
+	atomic.AddUint64(&s.coalesced, 1)
+}
+
+func (s *stats) purge(n uint64) {
+	atomic.AddUint64(&s.purges, n)
+}
+
+func (s *stats) Stats() *Stats {
+	return &Stats{
+		Hits:      atomic.LoadUint64(&s.hits),
+		Misses:    atomic.LoadUint64(&s.misses),
+		Stores:    atomic.LoadUint64(&s.stores),
+		Evictions: atomic.LoadUint64(&s.evictions),
+		Expires:   atomic.LoadUint64(&s.expires),
+		Stales:    atomic.LoadUint64(&s.stales),
+		Coalesced: atomic.LoadUint64(&s.coalesced),
+		Purges:    atomic.LoadUint64(&s.purges),
+	}
+}
+
 type CacheRequestBody bytes.Buffer
 
 func DupCacheRequestBody(body io.ReadCloser, buffer *bytes.Buffer) *CacheRequestBody {
+	ret, _ := CopyCacheRequestBody(body, buffer)
+	return ret
+}
+
+// 同DupCacheRequestBody, 但返回读取错误(例如请求体超出限制), 此时不应缓存
+func CopyCacheRequestBody(body io.ReadCloser, buffer *bytes.Buffer) (*CacheRequestBody, error) {
 	defer body.Close()
 
 	buffer.Reset()
-	io.Copy(buffer, bufio.NewReader(body))
-	return (*CacheRequestBody)(buffer)
+	_, err := io.Copy(buffer, bufio.NewReader(body))
+	return (*CacheRequestBody)(buffer), err
+}
+
+// 读取失败的请求体, 将错误原样交给处理器
+type errorRequestBody struct {
+	err error
+}
+
+func (b errorRequestBody) Read(p []byte) (int, error) {
+	return 0, b.err
+}
+
+func (b errorRequestBody) Close() error {
+	return nil
 }
 
 func (b *CacheRequestBody) Read(p []byte) (n int, err error) {
@@ -55,3 +186,68 @@ func (w *CacheResponseWriter) Write(data []byte) (int, error) {
 	return w.ResponseWriter.Write(data)
 }
 
+// 否则io.WriteString(例如gin的c.String)经内嵌的WriteString绕过缓冲
+func (w *CacheResponseWriter) WriteString(s string) (int, error) {
+	w.Buffer.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// 暂存状态与头部并丢弃响应体(由外层CacheResponseWriter记录), 由调用方决定写出或丢弃
+type bufferWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	size   int
+}
+
+func newBufferWriter(writer gin.ResponseWriter) *bufferWriter {
+	return &bufferWriter{
+		ResponseWriter: writer,
+		header:         make(http.Header),
+		status:         http.StatusOK,
+		size:           -1,
+	}
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	if code > 0 && w.size < 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferWriter) WriteHeaderNow() {
+	if w.size < 0 {
+		w.size = 0
+	}
+}
+
+func (w *bufferWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *bufferWriter) WriteString(s string) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(s)
+	return len(s), nil
+}
+
+func (w *bufferWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferWriter) Size() int {
+	return w.size
+}
+
+func (w *bufferWriter) Written() bool {
+	return w.size >= 0
+}
+
+func (w *bufferWriter) Flush() {
+}
@@ -8,6 +8,7 @@ import (
 占位测试的特殊类型
 */
 type noneCache struct {
+	stats
 }
 
 func newNoneCache(c *Config) *noneCache {
@@ -18,6 +19,22 @@ func (c *noneCache) Cache(seconds int64, f gin.HandlerFunc) gin.HandlerFunc {
 	return f
 }
 
+func (c *noneCache) CacheWith(policy *Policy, f gin.HandlerFunc) gin.HandlerFunc {
+	return f
+}
+
+func (c *noneCache) Purge(key string) error {
+	return nil
+}
+
+func (c *noneCache) PurgePrefix(path string) error {
+	return nil
+}
+
+func (c *noneCache) PurgeTags(tags ...string) error {
+	return nil
+}
+
 func (c *noneCache) Close() {
 
 }
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/*
+为当前请求的缓存条目附加标签, 之后可按标签PurgeTags. ctx为http适配器传给服务的gin.Context或其派生(例如带期限)的ctx,
+非缓存路由忽略. 处理器也可以设置响应头X-Cache-Tags
+*/
+func Tag(ctx context.Context, tags ...string) {
+	if h, ok := ctx.Value(TAGS_KEY).(*tagHolder); ok {
+		h.Lock()
+		h.tags = append(h.tags, tags...)
+		h.Unlock()
+	}
+}
+
+// 缓存在调用处理器前放入gin.Context, gin.Context.Value按字符串key取值, 故经派生的ctx仍可取得
+type tagHolder struct {
+	sync.Mutex
+	tags []string
+}
+
+func withTags(ctx *gin.Context) {
+	ctx.Set(TAGS_KEY, new(tagHolder))
+}
+
+// 合并Tag()与响应头的标签, 响应头由调用方删除
+func tagsOf(ctx *gin.Context, header http.Header) []string {
+	var ret []string
+	if v, ok := ctx.Get(TAGS_KEY); ok {
+		if h, ok := v.(*tagHolder); ok {
+			h.Lock()
+			ret = append(ret, h.tags...)
+			h.Unlock()
+		}
+	}
+	for _, v := range header.Values(TAGS_HEADER) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				ret = append(ret, t)
+			}
+		}
+	}
+	return ret
+}
+
+// 不缓存时直接写出的响应同样不返回X-Cache-Tags
+type untagWriter struct {
+	gin.ResponseWriter
+}
+
+func (w untagWriter) WriteHeaderNow() {
+	w.Header().Del(TAGS_HEADER)
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w untagWriter) Write(data []byte) (int, error) {
+	w.Header().Del(TAGS_HEADER)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w untagWriter) WriteString(s string) (int, error) {
+	w.Header().Del(TAGS_HEADER)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// 以untagWriter执行处理器, 结束后恢复原writer
+func passthrough(ctx *gin.Context, f gin.HandlerFunc) {
+	writer := ctx.Writer
+	ctx.Writer = untagWriter{writer}
+	defer func() {
+		ctx.Writer = writer
+	}()
+	f(ctx)
+}
+
+// 缓存key的请求路径部分, key为METHOD:path:...
+func pathOf(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[i+1:]
+	}
+	return key
+}
+
+// key本身或其Vary条目
+func isVariant(key string, base string) bool {
+	return key == base || strings.HasPrefix(key, base+":vary:")
+}
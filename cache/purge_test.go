@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryCachePurge(t *testing.T) {
+	c := newMemoryCache(mergeConfig(nil))
+	defer c.Close()
+
+	calls := make(map[string]int)
+	gin.SetMode(gin.ReleaseMode)
+	engine := gin.New()
+	handler := c.CacheWith(&Policy{Seconds: 60, Key: &KeySpec{Vary: true}}, func(ctx *gin.Context) {
+		calls[ctx.Request.URL.String()]++
+		switch ctx.Request.URL.Path {
+		case "/a":
+			Tag(ctx, "a")
+		case "/b":
+			ctx.Header("Vary", "Accept-Language")
+			ctx.Header(TAGS_HEADER, "b, shared")
+		}
+		ctx.String(http.StatusOK, ctx.Request.URL.Path)
+	})
+	engine.GET("/a", handler)
+	engine.GET("/b", handler)
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+	all := []string{"/a?x=1", "/a?x=2", "/b"}
+	fill := func() {
+		for _, p := range all {
+			if w := get(p); w.Header().Get(TAGS_HEADER) != "" {
+				t.Fatalf("expect tags header removed: %v", w.Header())
+			}
+		}
+	}
+	expect := func(step string, want map[string]int) {
+		for _, p := range all {
+			if calls[p] != want[p] {
+				t.Fatalf("%v: expect %v calls for %v, got %v", step, want[p], p, calls[p])
+			}
+		}
+	}
+
+	fill()
+	fill()
+	expect("fill", map[string]int{"/a?x=1": 1, "/a?x=2": 1, "/b": 1})
+
+	c.Purge("GET:/a:x=1")
+	c.Purge("GET:/b:") // 同时删除Vary条目
+	fill()
+	expect("purge", map[string]int{"/a?x=1": 2, "/a?x=2": 1, "/b": 2})
+
+	c.PurgePrefix("/a")
+	fill()
+	expect("prefix", map[string]int{"/a?x=1": 3, "/a?x=2": 2, "/b": 2})
+
+	c.PurgeTags("shared", "none")
+	fill()
+	expect("tags", map[string]int{"/a?x=1": 3, "/a?x=2": 2, "/b": 3})
+
+	if st := c.Stats(); st.Purges != 6 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+	// 经派生的ctx(例如方法期限)设置标签
+	engine.GET("/d", c.CacheWith(&Policy{Seconds: 60}, func(ctx *gin.Context) {
+		calls["/d"]++
+		tctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		Tag(tctx, "d")
+		ctx.String(http.StatusOK, "d")
+	}))
+	get("/d")
+	c.PurgeTags("d")
+	if get("/d"); calls["/d"] != 2 {
+		t.Fatalf("expect purged by derived ctx tag, got %v calls", calls["/d"])
+	}
+
+	// 不缓存的响应同样删除标签头
+	engine.GET("/err", c.CacheWith(&Policy{Seconds: 60}, func(ctx *gin.Context) {
+		ctx.Header(TAGS_HEADER, "internal-tenant-42")
+		ctx.String(http.StatusServiceUnavailable, "down")
+	}))
+	if w := get("/err"); w.Code != http.StatusServiceUnavailable || w.Header().Get(TAGS_HEADER) != "" {
+		t.Fatalf("expect tags header removed from uncached response: %v %v", w.Code, w.Header())
+	}
+}
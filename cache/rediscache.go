@@ -1,22 +1,44 @@
 package cache
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/obase/kit"
+	"github.com/obase/log"
 	"github.com/obase/redis.v2"
+	"strings"
 	"sync"
 )
 
+const (
+	REDIS_PATHS_KEY   = "pbapi.cache.paths" // 已缓存的请求路径
+	REDIS_PATH_PREFIX = "pbapi.cache.path:" // 请求路径的缓存key
+	REDIS_VARY_PREFIX = "pbapi.cache.vary:" // 缓存key的Vary条目
+	REDIS_TAG_PREFIX  = "pbapi.cache.tag:"  // 标签的缓存key
+)
+
+// 集合添加成员, 过期时间只延长不缩短(集合可能被不同缓存时间的条目共用)
+const redisIndexScript = `redis.call('SADD', KEYS[1], ARGV[1])
+if redis.call('TTL', KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return 1`
+
 type redisCache struct {
+	stats
 	*Config
 	redis.Redis
 	sync.Once
+	wrapper *wrapper
 }
 
 func newRedisCache(config *Config) *redisCache {
-	return &redisCache{
+	c := &redisCache{
 		Config: config,
 	}
+	c.wrapper = &wrapper{stats: &c.stats, config: config, store: c, flight: newFlight()}
+	return c
 }
 
 func (c *redisCache) lazyinit() {
@@ -24,44 +46,145 @@ func (c *redisCache) lazyinit() {
 	c.Redis = redis.Get(c.Config.Config.Key)
 }
 
-func (c *redisCache) Cache(seconds int64, f gin.HandlerFunc) gin.HandlerFunc {
+// 值的前8字节为新鲜期截止(unix纳秒, 大端), 之后为Response. 旧格式的值解析失败(生成代码不检查越界), 视为未命中
+func (c *redisCache) load(key string, now int64) (ret *entry) {
+	defer func() {
+		if recover() != nil {
+			ret = nil
+		}
+	}()
+	bs, _, _ := redis.Bytes(c.Redis.Do("GET", key))
+	if len(bs) <= 8 {
+		return nil
+	}
+	rsp := new(Response)
+	if _, err := rsp.Unmarshal(bs[8:]); err != nil {
+		return nil
+	}
+	return &entry{fresh: int64(binary.BigEndian.Uint64(bs)), Response: rsp}
+}
 
-	if seconds <= 0 {
-		return f
+func (c *redisCache) save(key string, e *entry, policy *Policy) {
+	bs := make([]byte, 8+e.Size())
+	binary.BigEndian.PutUint64(bs, uint64(e.fresh))
+	if _, err := e.Marshal(bs[8:]); err != nil {
+		return
+	}
+	ttl := policy.Seconds + policy.grace()
+	if _, err := c.Redis.Do("SETEX", key, ttl, bs); err != nil {
+		return
+	}
+	c.store()
+	// 索引只用于主动删除, 失败不影响缓存
+	c.index(REDIS_PATHS_KEY, e.path, ttl)
+	c.index(REDIS_PATH_PREFIX+e.path, key, ttl)
+	if e.base != "" {
+		c.index(REDIS_VARY_PREFIX+e.base, key, ttl)
+	}
+	for _, t := range e.tags {
+		c.index(REDIS_TAG_PREFIX+t, key, ttl)
+	}
+}
+
+func (c *redisCache) index(set string, member string, ttl int64) {
+	if _, err := c.Redis.Eval(redisIndexScript, 1, set, member, ttl); err != nil {
+		log.Errorf("index cache %v: %v", member, err)
 	}
+}
 
-	// 延迟初始化Redis
+func (c *redisCache) del(keys ...string) error {
+	for _, k := range keys {
+		n, _, err := redis.Int(c.Redis.Do("DEL", k))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			c.purge(uint64(n))
+		}
+	}
+	return nil
+}
+
+// 删除集合中的条目及集合本身
+func (c *redisCache) purgeSet(set string) error {
+	keys, _, err := redis.StringSlice(c.Redis.Do("SMEMBERS", set))
+	if err != nil {
+		return err
+	}
+	if err = c.del(keys...); err != nil {
+		return err
+	}
+	_, err = c.Redis.Do("DEL", set)
+	return err
+}
+
+// 主动删除可能先于任何缓存路由初始化
+func (c *redisCache) ready() error {
 	c.Once.Do(c.lazyinit)
+	if c.Redis == nil {
+		return errors.New(fmt.Sprintf("redis not found: %v", c.Config.Config.Key))
+	}
+	return nil
+}
 
-	// 返回包装句柄
-	rdb := c.Redis
-	return func(ctx *gin.Context) {
-		buf := kit.GetBytesBuffer()
-		defer kit.PutBytesBuffer(buf)
-
-		buf.Reset()
-		ctx.Request.Body = DupCacheRequestBody(ctx.Request.Body, buf)
-		key := ckey(ctx.Request, buf)
-		bs, _, _ := redis.Bytes(rdb.Do("GET", key))
-		if len(bs) > 0 {
-			var rsp Response
-			if _, err := rsp.Unmarshal(bs); err == nil {
-				write(ctx.Writer, &rsp)
-				return
-			}
+func (c *redisCache) Purge(key string) error {
+	if err := c.ready(); err != nil {
+		return err
+	}
+	if err := c.del(key); err != nil {
+		return err
+	}
+	return c.purgeSet(REDIS_VARY_PREFIX + key)
+}
+
+func (c *redisCache) PurgePrefix(path string) error {
+	if err := c.ready(); err != nil {
+		return err
+	}
+	paths, _, err := redis.StringSlice(c.Redis.Do("SMEMBERS", REDIS_PATHS_KEY))
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if !strings.HasPrefix(p, path) {
+			continue
+		}
+		if err = c.purgeSet(REDIS_PATH_PREFIX + p); err != nil {
+			return err
+		}
+		if _, err = c.Redis.Do("SREM", REDIS_PATHS_KEY, p); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		// 如果没有缓存,则包装writer调用handler
-		buf.Reset()
-		ctx.Writer = NewCacheResponseWriter(ctx.Writer, buf)
-		f(ctx)
-		// 只会缓存state位于200~400之间的结果
-		if status := ctx.Writer.Status(); status >= c.Config.MinStatusCode && status <= c.Config.MaxStatusCode {
-			if bs, err := read(ctx.Writer.(*CacheResponseWriter)).Marshal(nil); err == nil {
-				rdb.Do("SETEX", key, seconds, bs)
-			}
+func (c *redisCache) PurgeTags(tags ...string) error {
+	if err := c.ready(); err != nil {
+		return err
+	}
+	for _, t := range tags {
+		if err := c.purgeSet(REDIS_TAG_PREFIX + t); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+func (c *redisCache) Cache(seconds int64, f gin.HandlerFunc) gin.HandlerFunc {
+	return c.CacheWith(&Policy{Seconds: seconds}, f)
+}
+
+func (c *redisCache) CacheWith(policy *Policy, f gin.HandlerFunc) gin.HandlerFunc {
+
+	if policy == nil || policy.Seconds <= 0 {
+		return f
+	}
+
+	// 延迟初始化Redis
+	c.Once.Do(c.lazyinit)
+
+	return c.wrapper.wrap(policy, f)
 }
 
 func (c *redisCache) Close() {
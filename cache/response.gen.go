@@ -17,6 +17,8 @@ type Response struct {
 	Hname  []string
 	Hvals  [][]string
 	Rdata  []byte
+	Etag   string
+	Mtime  int64
 }
 
 func (d *Response) Size() (s uint64) {
@@ -126,7 +128,22 @@ func (d *Response) Size() (s uint64) {
 		}
 		s += l
 	}
-	s += 2
+	{
+		l := uint64(len(d.Etag))
+
+		{
+
+			t := l
+			for t >= 0x80 {
+				t >>= 7
+				s++
+			}
+			s++
+
+		}
+		s += l
+	}
+	s += 10
 	return
 }
 func (d *Response) Marshal(buf []byte) ([]byte, error) {
@@ -267,7 +284,45 @@ func (d *Response) Marshal(buf []byte) ([]byte, error) {
 		copy(buf[i+2:], d.Rdata)
 		i += l
 	}
-	return buf[:i+2], nil
+	{
+		l := uint64(len(d.Etag))
+
+		{
+
+			t := uint64(l)
+
+			for t >= 0x80 {
+				buf[i+2] = byte(t) | 0x80
+				t >>= 7
+				i++
+			}
+			buf[i+2] = byte(t)
+			i++
+
+		}
+		copy(buf[i+2:], d.Etag)
+		i += l
+	}
+	{
+
+		buf[i+0+2] = byte(d.Mtime >> 0)
+
+		buf[i+1+2] = byte(d.Mtime >> 8)
+
+		buf[i+2+2] = byte(d.Mtime >> 16)
+
+		buf[i+3+2] = byte(d.Mtime >> 24)
+
+		buf[i+4+2] = byte(d.Mtime >> 32)
+
+		buf[i+5+2] = byte(d.Mtime >> 40)
+
+		buf[i+6+2] = byte(d.Mtime >> 48)
+
+		buf[i+7+2] = byte(d.Mtime >> 56)
+
+	}
+	return buf[:i+10], nil
 }
 
 func (d *Response) Unmarshal(buf []byte) (uint64, error) {
@@ -424,5 +479,30 @@ func (d *Response) Unmarshal(buf []byte) (uint64, error) {
 		copy(d.Rdata, buf[i+2:])
 		i += l
 	}
-	return i + 2, nil
+	{
+		l := uint64(0)
+
+		{
+
+			bs := uint8(7)
+			t := uint64(buf[i+2] & 0x7F)
+			for buf[i+2]&0x80 == 0x80 {
+				i++
+				t |= uint64(buf[i+2]&0x7F) << bs
+				bs += 7
+			}
+			i++
+
+			l = t
+
+		}
+		d.Etag = string(buf[i+2 : i+2+l])
+		i += l
+	}
+	{
+
+		d.Mtime = 0 | (int64(buf[i+0+2]) << 0) | (int64(buf[i+1+2]) << 8) | (int64(buf[i+2+2]) << 16) | (int64(buf[i+3+2]) << 24) | (int64(buf[i+4+2]) << 32) | (int64(buf[i+5+2]) << 40) | (int64(buf[i+6+2]) << 48) | (int64(buf[i+7+2]) << 56)
+
+	}
+	return i + 10, nil
 }
@@ -0,0 +1,55 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/conf"
+	"github.com/obase/pbapi/codec"
+	"google.golang.org/grpc"
+)
+
+// 将方法的codec注入gin.Context, 供适配器解码(codec.Unmarshal)及结果编码
+func newCodecHandlerFunc(cd codec.Codec) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(codec.CONTEXT_KEY, cd)
+	}
+}
+
+/*
+由ServiceDesc的方法处理器创建适配器: 请求对象由生成代码按类型创建, 框架按协商的codec解码.
+生成的适配器固定用json.Unmarshal解码, 替换后才能接收protobuf/msgpack/protojson请求
+*/
+func typedAdapters(sdesc *grpc.ServiceDesc, service interface{}) map[string]func(context.Context, []byte) (interface{}, error) {
+	if sdesc == nil || len(sdesc.Methods) == 0 {
+		return nil
+	}
+	ret := make(map[string]func(context.Context, []byte) (interface{}, error))
+	for _, md := range sdesc.Methods {
+		handler := md.Handler // 闭包必须绑定当次迭代的handler
+		ret[md.MethodName] = func(ctx context.Context, data []byte) (interface{}, error) {
+			return handler(service, ctx, func(v interface{}) error {
+				if len(data) == 0 {
+					return nil
+				}
+				if err := codec.FromContext(ctx).Unmarshal(data, v); err != nil {
+					return &Response{
+						Code: PARSING_REQUEST_ERROR,
+						Msg:  err.Error(),
+					}
+				}
+				return nil
+			}, nil)
+		}
+	}
+	return ret
+}
+
+func loadCodecConfig(val interface{}) *codec.Config {
+	ret := new(codec.Config)
+	ret.Name, _ = conf.ElemString(val, "name")
+	ret.EmitUnpopulated, _ = conf.ElemBool(val, "emitUnpopulated")
+	ret.UseProtoNames, _ = conf.ElemBool(val, "useProtoNames")
+	ret.UseEnumNumbers, _ = conf.ElemBool(val, "useEnumNumbers")
+	ret.DiscardUnknown, _ = conf.ElemBool(val, "discardUnknown")
+	return ret
+}
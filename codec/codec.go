@@ -0,0 +1,216 @@
+package codec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/runtime/protoimpl"
+	"mime"
+	"reflect"
+)
+
+const (
+	JSON      = "json"      // 默认, 标准encoding/json
+	PROTOJSON = "protojson" // 支持oneof, Any, 知名类型, int64字符串等proto3 json规范
+	PROTOBUF  = "protobuf"  // protobuf二进制, 仅用于内容协商
+	MSGPACK   = "msgpack"   // msgpack, 仅用于内容协商
+
+	CONTEXT_KEY  = "pbapi.codec"          // 请求解码的codec, gin.Context.Value仅支持string键
+	RESPONSE_KEY = "pbapi.codec.response" // 结果编码的codec, 未设置时同请求
+)
+
+type Config struct {
+	Name            string `json:"name" bson:"name" yaml:"name"`                                  // json | protojson, 默认json
+	EmitUnpopulated bool   `json:"emitUnpopulated" bson:"emitUnpopulated" yaml:"emitUnpopulated"` // 输出零值字段
+	UseProtoNames   bool   `json:"useProtoNames" bson:"useProtoNames" yaml:"useProtoNames"`       // 使用proto字段名而非lowerCamelCase
+	UseEnumNumbers  bool   `json:"useEnumNumbers" bson:"useEnumNumbers" yaml:"useEnumNumbers"`    // 枚举输出数值
+	DiscardUnknown  bool   `json:"discardUnknown" bson:"discardUnknown" yaml:"discardUnknown"`    // 忽略未知字段, 否则报错
+}
+
+// 请求解码与结果编码, json类codec的非proto消息一律使用encoding/json
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return JSON
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	Default  Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+	Msgpack  Codec = newMsgpackCodec()
+)
+
+type protojsonCodec struct {
+	marshal   protojson.MarshalOptions
+	unmarshal protojson.UnmarshalOptions
+}
+
+func (c *protojsonCodec) Name() string {
+	return PROTOJSON
+}
+
+func (c *protojsonCodec) ContentType() string {
+	return "application/json; charset=utf-8"
+}
+
+func (c *protojsonCodec) Marshal(v interface{}) ([]byte, error) {
+	if m := message(v); m != nil {
+		return c.marshal.Marshal(m)
+	}
+	return json.Marshal(v)
+}
+
+// 兼容生成代码的**T形式: 目标为nil时自动创建
+func (c *protojsonCodec) Unmarshal(data []byte, v interface{}) error {
+	if m := message(v); m != nil {
+		return c.unmarshal.Unmarshal(data, m)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		elem := rv.Elem()
+		if isMessageType(elem.Type()) {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			return c.unmarshal.Unmarshal(data, message(elem.Interface()))
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// 创建json类codec, protobuf/msgpack由内容协商按媒体类型选择
+func New(c *Config) (Codec, error) {
+	if c == nil || c.Name == "" || c.Name == JSON {
+		return Default, nil
+	}
+	if c.Name != PROTOJSON {
+		return nil, errors.New("invalid codec: " + c.Name)
+	}
+	return &protojsonCodec{
+		marshal: protojson.MarshalOptions{
+			EmitUnpopulated: c.EmitUnpopulated,
+			UseProtoNames:   c.UseProtoNames,
+			UseEnumNumbers:  c.UseEnumNumbers,
+		},
+		unmarshal: protojson.UnmarshalOptions{
+			DiscardUnknown: c.DiscardUnknown,
+		},
+	}, nil
+}
+
+// golang/protobuf v1的消息接口
+type messageV1 interface {
+	Reset()
+	String() string
+	ProtoMessage()
+}
+
+var (
+	messageV1Type = reflect.TypeOf((*messageV1)(nil)).Elem()
+	messageV2Type = reflect.TypeOf((*protoreflect.ProtoMessage)(nil)).Elem()
+)
+
+func isMessageType(t reflect.Type) bool {
+	return t.Implements(messageV2Type) || t.Implements(messageV1Type)
+}
+
+// 转换为protoreflect消息, 非消息或nil指针返回nil
+func message(v interface{}) protoreflect.ProtoMessage {
+	if v == nil {
+		return nil
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil
+	}
+	switch m := v.(type) {
+	case protoreflect.ProtoMessage:
+		return m
+	case messageV1:
+		return protoimpl.X.ProtoMessageV2Of(m)
+	}
+	return nil
+}
+
+func FromContext(ctx context.Context) Codec {
+	if ctx != nil {
+		if c, ok := ctx.Value(CONTEXT_KEY).(Codec); ok {
+			return c
+		}
+	}
+	return Default
+}
+
+func NewContext(ctx context.Context, c Codec) context.Context {
+	return context.WithValue(ctx, CONTEXT_KEY, c)
+}
+
+func ResponseFromContext(ctx context.Context) Codec {
+	if ctx != nil {
+		if c, ok := ctx.Value(RESPONSE_KEY).(Codec); ok {
+			return c
+		}
+	}
+	return FromContext(ctx)
+}
+
+// 二进制codec不能嵌入json响应, 由调用方直接写出
+func IsBinary(c Codec) bool {
+	return c == Protobuf || c == Msgpack
+}
+
+/*
+按媒体类型(可带参数)选择codec, 不支持返回nil:
+1. application/json: def(json或protojson)
+2. application/x-protobuf, application/protobuf: Protobuf
+3. application/x-msgpack, application/msgpack: Msgpack
+*/
+func ForMediaType(mediaType string, def Codec) Codec {
+	if mt, _, err := mime.ParseMediaType(mediaType); err == nil {
+		switch mt {
+		case "application/json":
+			return def
+		case "application/x-protobuf", "application/protobuf":
+			return Protobuf
+		case "application/x-msgpack", "application/msgpack":
+			return Msgpack
+		}
+	}
+	return nil
+}
+
+// 手工适配器的请求解码钩子, 按方法配置或协商的codec解码. 使用者须在ServiceHandler.Codecs中声明才开启协商
+func Unmarshal(ctx context.Context, data []byte, v interface{}) error {
+	return FromContext(ctx).Unmarshal(data, v)
+}
+
+// 适配器结果先按codec编码为json.RawMessage, 以便嵌入Response等任意响应格式. 默认codec原样返回
+func Encode(c Codec, v interface{}) (interface{}, error) {
+	if c == Default || IsBinary(c) || message(v) == nil {
+		return v, nil
+	}
+	bs, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(bs), nil
+}
@@ -0,0 +1,79 @@
+package codec_test
+
+import (
+	"context"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/grpc_health_v1"
+	"testing"
+)
+
+func TestProtojson(t *testing.T) {
+	cd, err := codec.New(&codec.Config{Name: codec.PROTOJSON, EmitUnpopulated: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 枚举输出名称, 零值字段保留
+	bs, err := cd.Marshal(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING})
+	if err != nil || string(bs) != `{"status":"SERVING"}` {
+		t.Errorf("marshal: %s %v", bs, err)
+	}
+	if bs, _ = cd.Marshal(&grpc_health_v1.HealthCheckRequest{}); string(bs) != `{"service":""}` {
+		t.Errorf("emitUnpopulated: %s", bs)
+	}
+	// 生成代码的**T形式
+	var req *grpc_health_v1.HealthCheckRequest
+	ctx := codec.NewContext(context.Background(), cd)
+	if err = codec.Unmarshal(ctx, []byte(`{"service":"demo"}`), &req); err != nil || req == nil || req.Service != "demo" {
+		t.Errorf("unmarshal: %v %v", req, err)
+	}
+	if err = codec.Unmarshal(ctx, []byte(`{"unknown":1}`), &req); err == nil {
+		t.Error("expect unknown field error")
+	}
+	var rsp grpc_health_v1.HealthCheckResponse
+	if err = cd.Unmarshal([]byte(`{"status":"NOT_SERVING"}`), &rsp); err != nil || rsp.Status != grpc_health_v1.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("unmarshal enum: %v %v", rsp.Status, err)
+	}
+	// 非消息仍使用encoding/json
+	if bs, _ = cd.Marshal(map[string]int{"a": 1}); string(bs) != `{"a":1}` {
+		t.Errorf("marshal map: %s", bs)
+	}
+	// 默认codec不改变结果
+	if v, _ := codec.Encode(codec.Default, &rsp); v != &rsp {
+		t.Errorf("default encode: %v", v)
+	}
+	if _, err = codec.New(&codec.Config{Name: "xml"}); err == nil {
+		t.Error("expect invalid codec")
+	}
+}
+
+func TestBinary(t *testing.T) {
+	for _, cd := range []codec.Codec{codec.Protobuf, codec.Msgpack} {
+		bs, err := cd.Marshal(&grpc_health_v1.HealthCheckRequest{Service: "demo"})
+		if err != nil {
+			t.Fatal(cd.Name(), err)
+		}
+		var req *grpc_health_v1.HealthCheckRequest
+		if err = cd.Unmarshal(bs, &req); err != nil || req == nil || req.Service != "demo" {
+			t.Errorf("%v unmarshal: %v %v", cd.Name(), req, err)
+		}
+	}
+	if _, err := codec.Protobuf.Marshal(map[string]int{"a": 1}); err == nil {
+		t.Error("expect not a proto message")
+	}
+	var m map[string]interface{}
+	bs, _ := codec.Msgpack.Marshal(map[string]int{"a": 1})
+	if err := codec.Msgpack.Unmarshal(bs, &m); err != nil || m["a"] == nil {
+		t.Errorf("msgpack map: %v %v", m, err)
+	}
+	cases := map[string]codec.Codec{
+		"application/json; charset=utf-8": codec.Default,
+		"application/x-protobuf":          codec.Protobuf,
+		"application/msgpack":             codec.Msgpack,
+		"text/xml":                        nil,
+	}
+	for mt, expect := range cases {
+		if cd := codec.ForMediaType(mt, codec.Default); cd != expect {
+			t.Errorf("%v: expect %v, got %v", mt, expect, cd)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	ugorji "github.com/ugorji/go/codec"
+	"reflect"
+)
+
+// msgpack, 结构体字段沿用json标签
+type msgpackCodec struct {
+	handle *ugorji.MsgpackHandle
+}
+
+func newMsgpackCodec() *msgpackCodec {
+	h := new(ugorji.MsgpackHandle)
+	h.RawToString = true
+	h.WriteExt = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return &msgpackCodec{handle: h}
+}
+
+func (c *msgpackCodec) Name() string {
+	return MSGPACK
+}
+
+func (c *msgpackCodec) ContentType() string {
+	return "application/x-msgpack"
+}
+
+func (c *msgpackCodec) Marshal(v interface{}) (ret []byte, err error) {
+	err = ugorji.NewEncoderBytes(&ret, c.handle).Encode(v)
+	return
+}
+
+func (c *msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return ugorji.NewDecoderBytes(data, c.handle).Decode(v)
+}
@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+var errNotMessage = errors.New("not a proto message")
+
+// protobuf二进制, 仅支持proto消息
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return PROTOBUF
+}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	if m := message(v); m != nil {
+		return proto.Marshal(m)
+	}
+	// nil消息即空消息
+	if v != nil && isMessageType(reflect.TypeOf(v)) {
+		return nil, nil
+	}
+	return nil, errNotMessage
+}
+
+// 兼容生成代码的**T形式: 目标为nil时自动创建
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if m := message(v); m != nil {
+		return proto.Unmarshal(data, m)
+	}
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Kind() == reflect.Ptr {
+		elem := rv.Elem()
+		if isMessageType(elem.Type()) {
+			if elem.IsNil() {
+				elem.Set(reflect.New(elem.Type().Elem()))
+			}
+			return proto.Unmarshal(data, message(elem.Interface()))
+		}
+	}
+	return errNotMessage
+}
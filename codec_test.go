@@ -0,0 +1,43 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/grpc_health_v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	s := newDemoServer("HealthService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Check": func(ctx context.Context, data []byte) (interface{}, error) {
+			var req *grpc_health_v1.HealthCheckRequest
+			if err := codec.Unmarshal(ctx, data, &req); err != nil {
+				return nil, err
+			}
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	})
+	cases := []struct {
+		config *Config
+		body   string
+	}{
+		{&Config{}, `{"code":0,"data":{"status":1}`},
+		{&Config{Codec: &codec.Config{Name: codec.PROTOJSON}}, `{"code":0,"data":{"status":"SERVING"}`},
+		// 方法设置覆盖全局
+		{&Config{Codec: &codec.Config{Name: codec.PROTOJSON}, ServerConfig: []*ServerConfig{{Method: "Check", Codec: &codec.Config{Name: codec.JSON}}}}, `{"code":0,"data":{"status":1}`},
+	}
+	for i, c := range cases {
+		engine := compileDemoEngine(t, s, c.config, &httpRuntime{})
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/demo/health/check", strings.NewReader(`{"service":"demo"}`)))
+		if !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("case %v: expect %s, got %s", i, c.body, w.Body.String())
+		}
+	}
+	if _, err := s.compileHttpEngine(mergeConfig(&Config{Codec: &codec.Config{Name: "xml"}}), &httpRuntime{}); err == nil {
+		t.Error("expect invalid codec")
+	}
+}
@@ -0,0 +1,227 @@
+package compress
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	GZIP     = "gzip"
+	BROTLI   = "br"
+	IDENTITY = "identity"
+
+	HEADER_ACCEPT_ENCODING  = "Accept-Encoding"
+	HEADER_CONTENT_ENCODING = "Content-Encoding"
+)
+
+// 请求体Content-Encoding不支持时的回调, 由调用方决定响应格式
+type RejectFunc func(c *gin.Context, status int, err error)
+
+/*
+响应压缩与请求解压:
+1. 响应按Accept-Encoding协商, 不小于minSize且为可压缩类型才压缩, 已有Content-Encoding的(例如代理上游已压缩)原样输出
+2. 请求体Content-Encoding为gzip或br时透明解压, 其余返回415
+3. websocket升级请求不处理
+*/
+type Compressor struct {
+	minSize      int
+	encodings    []string
+	contentTypes map[string]bool
+	gzipPool     sync.Pool
+	brotliPool   sync.Pool
+}
+
+func New(config *Config) (*Compressor, error) {
+	config = mergeConfig(config)
+	if config.Off {
+		return nil, nil
+	}
+	if config.GzipLevel < gzip.HuffmanOnly || config.GzipLevel > gzip.BestCompression {
+		return nil, errors.New(fmt.Sprintf("invalid gzip level: %v", config.GzipLevel))
+	}
+	if config.BrotliLevel < brotli.BestSpeed || config.BrotliLevel > brotli.BestCompression {
+		return nil, errors.New(fmt.Sprintf("invalid brotli level: %v", config.BrotliLevel))
+	}
+	c := &Compressor{
+		minSize:      config.MinSize,
+		contentTypes: make(map[string]bool),
+	}
+	for _, e := range config.Encodings {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e != GZIP && e != BROTLI {
+			return nil, errors.New("invalid compress encoding: " + e)
+		}
+		c.encodings = append(c.encodings, e)
+	}
+	for _, t := range config.ContentTypes {
+		c.contentTypes[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+	gzipLevel, brotliLevel := config.GzipLevel, config.BrotliLevel
+	c.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzipLevel)
+		return w
+	}
+	c.brotliPool.New = func() interface{} {
+		return brotli.NewWriterLevel(nil, brotliLevel)
+	}
+	return c, nil
+}
+
+func (c *Compressor) HandlerFunc(reject RejectFunc) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := decodeRequest(ctx.Request); err != nil {
+			reject(ctx, http.StatusUnsupportedMediaType, err)
+			return
+		}
+		if ctx.Request.Method == http.MethodHead || isUpgrade(ctx.Request) {
+			return
+		}
+		encoding := c.Negotiate(ctx.GetHeader(HEADER_ACCEPT_ENCODING))
+		if encoding == "" {
+			return
+		}
+		w := newCompressWriter(c, ctx.Writer, encoding)
+		ctx.Writer = w
+		defer func() {
+			ctx.Writer = w.ResponseWriter
+			if perr := recover(); perr != nil {
+				// 未提交的内容丢弃, 交由recovery输出
+				w.discard()
+				panic(perr)
+			}
+			w.close()
+		}()
+		ctx.Next()
+	}
+}
+
+/*
+按Accept-Encoding选择编码, 不压缩返回空:
+q值高者优先, 同q值按服务端次序; *匹配未显式列出的编码, q=0表示不接受
+*/
+func (c *Compressor) Negotiate(accept string) string {
+	if accept == "" {
+		return ""
+	}
+	qs := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		qs[mt] = q
+	}
+	var (
+		ret   string
+		bestq float64
+	)
+	for _, e := range c.encodings {
+		q, ok := qs[e]
+		if !ok {
+			q = qs["*"]
+		}
+		if q > bestq {
+			ret, bestq = e, q
+		}
+	}
+	return ret
+}
+
+func (c *Compressor) compressible(header http.Header, status int, head []byte) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if ce := header.Get(HEADER_CONTENT_ENCODING); ce != "" && ce != IDENTITY {
+		return false
+	}
+	ct := header.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(head)
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && c.contentTypes[mt]
+}
+
+func (c *Compressor) getEncoder(encoding string, w io.Writer) encoder {
+	switch encoding {
+	case GZIP:
+		gw := c.gzipPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return gw
+	case BROTLI:
+		bw := c.brotliPool.Get().(*brotli.Writer)
+		bw.Reset(w)
+		return bw
+	}
+	return nil
+}
+
+func (c *Compressor) putEncoder(enc encoder) {
+	switch v := enc.(type) {
+	case *gzip.Writer:
+		c.gzipPool.Put(v)
+	case *brotli.Writer:
+		c.brotliPool.Put(v)
+	}
+}
+
+func isUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+// 按Content-Encoding替换请求体, 解压后长度未知
+func decodeRequest(r *http.Request) error {
+	ce := strings.ToLower(strings.TrimSpace(r.Header.Get(HEADER_CONTENT_ENCODING)))
+	switch ce {
+	case "", IDENTITY:
+		return nil
+	case GZIP, BROTLI:
+		r.Body = &decodedBody{encoding: ce, body: r.Body}
+		r.Header.Del(HEADER_CONTENT_ENCODING)
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		return nil
+	}
+	return errors.New("unsupported content encoding: " + ce)
+}
+
+// 延迟到首次读取才创建解压器, 格式错误作为读取错误返回
+type decodedBody struct {
+	encoding string
+	body     io.ReadCloser
+	reader   io.Reader
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		if b.encoding == GZIP {
+			b.reader, b.err = gzip.NewReader(b.body)
+		} else {
+			b.reader = brotli.NewReader(b.body)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
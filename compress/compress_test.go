@@ -0,0 +1,31 @@
+package compress
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	c, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"":                          "",
+		"gzip":                      GZIP,
+		"gzip, deflate, br":         BROTLI,
+		"br;q=0.5, gzip":            GZIP,
+		"*":                         BROTLI,
+		"*, br;q=0":                 GZIP,
+		"identity":                  "",
+		"deflate, gzip;q=0, br;q=0": "",
+	}
+	for accept, expect := range cases {
+		if ret := c.Negotiate(accept); ret != expect {
+			t.Errorf("%q: expect %q, got %q", accept, expect, ret)
+		}
+	}
+	if c, _ := New(&Config{Off: true}); c != nil {
+		t.Error("expect off")
+	}
+	if _, err := New(&Config{Encodings: []string{"deflate"}}); err == nil {
+		t.Error("expect invalid encoding")
+	}
+}
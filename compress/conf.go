@@ -0,0 +1,53 @@
+package compress
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+)
+
+type Config struct {
+	Off          bool     `json:"off" bson:"off" yaml:"off"`                            // 默认开启
+	MinSize      int      `json:"minSize" bson:"minSize" yaml:"minSize"`                // 响应不小于该字节数才压缩, 默认1024
+	GzipLevel    int      `json:"gzipLevel" bson:"gzipLevel" yaml:"gzipLevel"`          // 默认gzip.DefaultCompression
+	BrotliLevel  int      `json:"brotliLevel" bson:"brotliLevel" yaml:"brotliLevel"`    // 默认brotli.DefaultCompression
+	Encodings    []string `json:"encodings" bson:"encodings" yaml:"encodings"`          // 服务端优先次序, 默认[br, gzip]
+	ContentTypes []string `json:"contentTypes" bson:"contentTypes" yaml:"contentTypes"` // 可压缩的媒体类型, 默认DefaultContentTypes
+}
+
+var DefaultContentTypes = []string{
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/x-protobuf",
+	"application/x-msgpack",
+	"image/svg+xml",
+	"text/plain",
+	"text/html",
+	"text/css",
+	"text/xml",
+	"text/javascript",
+	"text/csv",
+}
+
+func mergeConfig(config *Config) *Config {
+	if config == nil {
+		config = new(Config)
+	}
+	if config.MinSize == 0 {
+		config.MinSize = 1024
+	}
+	if config.GzipLevel == 0 {
+		config.GzipLevel = gzip.DefaultCompression
+	}
+	if config.BrotliLevel == 0 {
+		config.BrotliLevel = brotli.DefaultCompression
+	}
+	if len(config.Encodings) == 0 {
+		config.Encodings = []string{BROTLI, GZIP}
+	}
+	if len(config.ContentTypes) == 0 {
+		config.ContentTypes = DefaultContentTypes
+	}
+	return config
+}
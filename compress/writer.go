@@ -0,0 +1,154 @@
+package compress
+
+import (
+	"bufio"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+/*
+压缩的ResponseWriter: 先缓冲不足minSize的内容, 提交时再决定是否压缩.
+Header()返回独立的头部, 提交时才复制到底层并追加Content-Encoding,
+因此外层cache.CacheResponseWriter记录的始终是未压缩的内容与头部, 命中时按各请求的协商结果重新压缩
+*/
+type compressWriter struct {
+	gin.ResponseWriter
+	c         *Compressor
+	encoding  string
+	header    http.Header
+	buf       []byte
+	committed bool
+	enc       encoder
+}
+
+func newCompressWriter(c *Compressor, w gin.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		c:              c,
+		encoding:       encoding,
+		header:         w.Header().Clone(),
+	}
+}
+
+func (w *compressWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.committed {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) >= w.c.minSize {
+			if err := w.commit(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(data), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// 未提交时为缓冲字节数, 否则为底层实际输出字节数
+func (w *compressWriter) Size() int {
+	if !w.committed && len(w.buf) > 0 {
+		return len(w.buf)
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.committed {
+		w.commit(false)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// 流式输出(例如代理或SSE)立即提交, 不受minSize限制
+func (w *compressWriter) Flush() {
+	if !w.committed {
+		w.commit(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.committed = true
+	return w.ResponseWriter.Hijack()
+}
+
+// 同步头部到底层并输出缓冲内容, allow为false时(内容不足minSize)不压缩
+func (w *compressWriter) commit(allow bool) error {
+	w.committed = true
+	header := w.ResponseWriter.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range w.header {
+		header[k] = v
+	}
+	if allow && w.c.compressible(header, w.ResponseWriter.Status(), w.buf) {
+		header.Set(HEADER_CONTENT_ENCODING, w.encoding)
+		header.Add("Vary", HEADER_ACCEPT_ENCODING)
+		header.Del("Content-Length")
+		// 压缩后内容不同, 强ETag改为弱ETag
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.c.getEncoder(w.encoding, w.ResponseWriter)
+	}
+	data := w.buf
+	w.buf = nil
+	if len(data) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(data)
+	} else {
+		_, err = w.ResponseWriter.Write(data)
+	}
+	return err
+}
+
+func (w *compressWriter) close() {
+	if !w.committed {
+		w.commit(len(w.buf) >= w.c.minSize)
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.c.putEncoder(w.enc)
+		w.enc = nil
+	}
+}
+
+// panic时丢弃未提交的内容, 底层头部保持原样交由recovery输出
+func (w *compressWriter) discard() {
+	w.buf = nil
+	if w.enc != nil {
+		w.enc.Close()
+		w.c.putEncoder(w.enc)
+		w.enc = nil
+	}
+}
@@ -0,0 +1,90 @@
+package pbapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/andybalholm/brotli"
+	"github.com/obase/pbapi/cache"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	s := newDemoServer("EchoService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Echo": func(ctx context.Context, data []byte) (interface{}, error) {
+			return strings.Repeat(string(data), 1000), nil
+		},
+	})
+	c := cache.New(&cache.Config{Type: cache.MEMORY})
+	defer c.Close()
+	engine := compileDemoEngine(t, s, &Config{
+		RouterConfig: []*RouterConfig{{Path: "/demo/echo/echo", Cache: 60}},
+	}, &httpRuntime{cache: c})
+	var gzbody bytes.Buffer
+	gw := gzip.NewWriter(&gzbody)
+	gw.Write([]byte("hello"))
+	gw.Close()
+
+	cases := []struct {
+		body            string
+		contentEncoding string
+		accept          string
+		status          int
+		encoding        string
+	}{
+		{gzbody.String(), "gzip", "gzip", http.StatusOK, "gzip"},
+		// 以下命中缓存, 按各自的Accept-Encoding输出
+		{"hello", "", "", http.StatusOK, ""},
+		{"hello", "", "br, gzip;q=0.8", http.StatusOK, "br"},
+		{"hello", "deflate", "", http.StatusUnsupportedMediaType, ""},
+	}
+	for i, v := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/demo/echo/echo", strings.NewReader(v.body))
+		if v.contentEncoding != "" {
+			r.Header.Set("Content-Encoding", v.contentEncoding)
+		}
+		if v.accept != "" {
+			r.Header.Set("Accept-Encoding", v.accept)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		if w.Code != v.status || w.Header().Get("Content-Encoding") != v.encoding {
+			t.Fatalf("case %v: expect %v %q, got %v %q %s", i, v.status, v.encoding, w.Code, w.Header().Get("Content-Encoding"), w.Body.String())
+		}
+		if v.status != http.StatusOK {
+			continue
+		}
+		var rd io.Reader = w.Body
+		switch v.encoding {
+		case "gzip":
+			gr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rd = gr
+		case "br":
+			rd = brotli.NewReader(w.Body)
+		}
+		bs, err := ioutil.ReadAll(rd)
+		if err != nil || !strings.Contains(string(bs), `"data":"hellohello`) {
+			t.Fatalf("case %v: unexpected body %s %v", i, bs, err)
+		}
+	}
+	if st := c.Stats(); st.Hits != 2 || st.Stores != 1 {
+		t.Errorf("expect 2 hits 1 store, got %+v", st)
+	}
+
+	// 不足minSize不压缩
+	r := httptest.NewRequest(http.MethodPost, "/demo/echo/echo", strings.NewReader(""))
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("small response: %v %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+}
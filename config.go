@@ -6,75 +6,130 @@ import (
 	"github.com/obase/conf"
 	"github.com/obase/pbapi/access"
 	"github.com/obase/pbapi/cache"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/compress"
+	"github.com/obase/pbapi/metrics"
+	"github.com/obase/pbapi/proxy"
+	"github.com/obase/pbapi/trace"
 	"google.golang.org/grpc"
 	"time"
 )
 
 type RouterConfig struct {
-	Package       string     `json:"package" bson:"package" yaml:"package"`
-	Service       string     `json:"service" bson:"service" yaml:"service"`
-	Method        string     `json:"method" bson:"method" yaml:"method"`                      // service method
-	Path          string     `json:"path" bson:"path" yaml:"path"`                            //路径模式
-	Methods       []string   `json:"methods" bson:"methods" yaml:"methods"`                   // 请求方法http method
-	ProxyPath     string     `json:"proxyPath" bson:"proxyPath" yaml:"proxyPath"`             //代理路径
-	ProxyService  string     `json:"proxyService" bson:"proxyService" yaml:"proxyService"`    // 代理外部服务
-	ProxyHttps    bool       `json:"proxyHttps" bson:"proxyHttps" yaml:"proxyHttps"`          // 代理使用https
-	Plugins       [][]string `json:"plugins" bson:"plugins" yaml:"plugins"`                   // expression: name(param1,param2,...)
-	Cache         int64      `json:"cache" bson:"cache" yaml:"cache"`                         // 缓存秒数
-	Off           bool       `json:"off" bson:"off" yaml:"off"`                               // 临时禁用
-	Access        bool       `json:"access" bson:"access" yaml:"access"`                      // 是否打印access log
-	SetProxyHttps bool       `json:"setProxyHttps" bson:"setProxyHttps" yaml:"setProxyHttps"` // 是否设置过ProxyHttps
-	SetOff        bool       `json:"setOff" bson:"setOff" yaml:"setOff"`                      // 是否设置过Off, 否则只有true才设置
-	SetAccess     bool       `json:"setAccess" bson:"setAccess" yaml:"setAccess"`             // 是否设置了Access,否则只有true才设置
+	Package              string               `json:"package" bson:"package" yaml:"package"`
+	Service              string               `json:"service" bson:"service" yaml:"service"`
+	Method               string               `json:"method" bson:"method" yaml:"method"`                                           // service method
+	Path                 string               `json:"path" bson:"path" yaml:"path"`                                                 //路径模式
+	Methods              []string             `json:"methods" bson:"methods" yaml:"methods"`                                        // 请求方法http method
+	ProxyPath            string               `json:"proxyPath" bson:"proxyPath" yaml:"proxyPath"`                                  //代理路径
+	ProxyService         string               `json:"proxyService" bson:"proxyService" yaml:"proxyService"`                         // 代理外部服务
+	ProxyHttps           bool                 `json:"proxyHttps" bson:"proxyHttps" yaml:"proxyHttps"`                               // 代理使用https
+	ProxyTargets         []string             `json:"proxyTargets" bson:"proxyTargets" yaml:"proxyTargets"`                         // 代理静态地址host:port, 没有注册中心时代替proxyService
+	ProxyPolicy          string               `json:"proxyPolicy" bson:"proxyPolicy" yaml:"proxyPolicy"`                            // 负载策略: robin | random | leastconn, 默认robin
+	ProxyCaFile          string               `json:"proxyCaFile" bson:"proxyCaFile" yaml:"proxyCaFile"`                            // https代理自定义CA
+	ProxyCertFile        string               `json:"proxyCertFile" bson:"proxyCertFile" yaml:"proxyCertFile"`                      // https代理客户端证书
+	ProxyKeyFile         string               `json:"proxyKeyFile" bson:"proxyKeyFile" yaml:"proxyKeyFile"`                         // https代理客户端私钥
+	ProxyRetries         int                  `json:"proxyRetries" bson:"proxyRetries" yaml:"proxyRetries"`                         // 代理失败重试次数, 仅幂等方法
+	ProxyRetryBackoff    time.Duration        `json:"proxyRetryBackoff" bson:"proxyRetryBackoff" yaml:"proxyRetryBackoff"`          // 重试退避基数, 按次翻倍, 默认100ms
+	ProxyTryTimeout      time.Duration        `json:"proxyTryTimeout" bson:"proxyTryTimeout" yaml:"proxyTryTimeout"`                // 单次尝试超时
+	ProxyBreaker         *proxy.BreakerConfig `json:"proxyBreaker" bson:"proxyBreaker" yaml:"proxyBreaker"`                         // 熔断设置, 为空不熔断
+	ProxyFallback        *Response            `json:"proxyFallback" bson:"proxyFallback" yaml:"proxyFallback"`                      // 熔断打开时的响应, 默认{code:605}
+	Plugins              [][]string           `json:"plugins" bson:"plugins" yaml:"plugins"`                                        // expression: name(param1,param2,...)
+	Cache                int64                `json:"cache" bson:"cache" yaml:"cache"`                                              // 缓存秒数
+	StaleWhileRevalidate int64                `json:"staleWhileRevalidate" bson:"staleWhileRevalidate" yaml:"staleWhileRevalidate"` // 过期后仍返回旧条目并后台刷新的秒数
+	StaleIfError         int64                `json:"staleIfError" bson:"staleIfError" yaml:"staleIfError"`                         // 过期后处理失败时返回旧条目的秒数
+	CacheKey             *cache.KeySpec       `json:"cacheKey" bson:"cacheKey" yaml:"cacheKey"`                                     // 缓存key的组成, 为空使用默认
+	MaxRequestBytes      int64                `json:"maxRequestBytes" bson:"maxRequestBytes" yaml:"maxRequestBytes"`                // 请求体最大字节数, 0沿用全局(服务接口沿用serverConfig), 负数不限制
+	Off                  bool                 `json:"off" bson:"off" yaml:"off"`                                                    // 临时禁用
+	Access               bool                 `json:"access" bson:"access" yaml:"access"`                                           // 是否打印access log
+	SetProxyHttps        bool                 `json:"setProxyHttps" bson:"setProxyHttps" yaml:"setProxyHttps"`                      // 是否设置过ProxyHttps
+	SetOff               bool                 `json:"setOff" bson:"setOff" yaml:"setOff"`                                           // 是否设置过Off, 否则只有true才设置
+	SetAccess            bool                 `json:"setAccess" bson:"setAccess" yaml:"setAccess"`                                  // 是否设置了Access,否则只有true才设置
 }
 
 type ServerConfig struct {
-	Package     string     `json:"package" bson:"package" yaml:"package"`
-	Service     string     `json:"service" bson:"service" yaml:"service"`
-	Method      string     `json:"method" bson:"method" yaml:"method"`
-	GrpcOff     bool       `json:"grpcOff" bson:"grpcOff" yaml:"grpcOff"`
-	HttpOff     bool       `json:"httpOff" bson:"httpOff" yaml:"httpOff"`
-	HttpPath    string     `json:"httpPath" bson:"httpPath" yaml:"httpPath"` // ServerPathDefault(packageName, serviceName, methodName) string
-	HttpPlugins [][]string `json:"httpPlugins" bson:"httpPlugins" yaml:"httpPlugins"`
-	WbskOff     bool       `json:"wbskOff" bson:"wbskOff" yaml:"wbskOff"`
-	WbskPath    string     `json:"wbskPath" bson:"wbskPath" yaml:"wbskPath"` // ServerPathDefault(packageName, serviceName, methodName)
-	WbskPlugins [][]string `json:"wbskPlugins" bson:"wbskPlugins" yaml:"wbskPlugins"`
-	SetGrpcOff  bool       `json:"setGrpcOff" bson:"setGrpcOff" yaml:"setGrpcOff"` // 是否设置了GrpcOff, 否则只有true才设置
-	SetHttpOff  bool       `json:"setHttpOff" bson:"setHttpOff" yaml:"setHttpOff"` // 是否设置了HttpOff, 否则只有true才设置
-	SetWbskOff  bool       `json:"setWbskOff" bson:"setWbskOff" yaml:"setWbskOff"` // 是否设置了WbskOff, 否则只有true才设置
+	Package         string        `json:"package" bson:"package" yaml:"package"`
+	Service         string        `json:"service" bson:"service" yaml:"service"`
+	Method          string        `json:"method" bson:"method" yaml:"method"`
+	GrpcOff         bool          `json:"grpcOff" bson:"grpcOff" yaml:"grpcOff"`
+	HttpOff         bool          `json:"httpOff" bson:"httpOff" yaml:"httpOff"`
+	HttpPath        string        `json:"httpPath" bson:"httpPath" yaml:"httpPath"` // ServerPathDefault(packageName, serviceName, methodName) string
+	HttpPlugins     [][]string    `json:"httpPlugins" bson:"httpPlugins" yaml:"httpPlugins"`
+	WbskOff         bool          `json:"wbskOff" bson:"wbskOff" yaml:"wbskOff"`
+	WbskPath        string        `json:"wbskPath" bson:"wbskPath" yaml:"wbskPath"` // ServerPathDefault(packageName, serviceName, methodName)
+	WbskPlugins     [][]string    `json:"wbskPlugins" bson:"wbskPlugins" yaml:"wbskPlugins"`
+	SseOff          bool          `json:"sseOff" bson:"sseOff" yaml:"sseOff"`                            // 关闭server-streaming方法的SSE
+	SsePath         string        `json:"ssePath" bson:"ssePath" yaml:"ssePath"`                         // SSE路径, 默认同httpPath
+	GrpcAccess      bool          `json:"grpcAccess" bson:"grpcAccess" yaml:"grpcAccess"`                // 是否打印grpc调用的access log
+	WbskAccess      bool          `json:"wbskAccess" bson:"wbskAccess" yaml:"wbskAccess"`                // 是否打印websocket每条消息的access log
+	SetGrpcOff      bool          `json:"setGrpcOff" bson:"setGrpcOff" yaml:"setGrpcOff"`                // 是否设置了GrpcOff, 否则只有true才设置
+	SetHttpOff      bool          `json:"setHttpOff" bson:"setHttpOff" yaml:"setHttpOff"`                // 是否设置了HttpOff, 否则只有true才设置
+	SetWbskOff      bool          `json:"setWbskOff" bson:"setWbskOff" yaml:"setWbskOff"`                // 是否设置了WbskOff, 否则只有true才设置
+	SetSseOff       bool          `json:"setSseOff" bson:"setSseOff" yaml:"setSseOff"`                   // 是否设置了SseOff, 否则只有true才设置
+	SetGrpcAccess   bool          `json:"setGrpcAccess" bson:"setGrpcAccess" yaml:"setGrpcAccess"`       // 是否设置了GrpcAccess, 否则只有true才设置
+	SetWbskAccess   bool          `json:"setWbskAccess" bson:"setWbskAccess" yaml:"setWbskAccess"`       // 是否设置了WbskAccess, 否则只有true才设置
+	MaxRequestBytes int64         `json:"maxRequestBytes" bson:"maxRequestBytes" yaml:"maxRequestBytes"` // 请求体(websocket为单条消息)最大字节数, 0沿用全局, 负数不限制
+	Timeout         time.Duration `json:"timeout" bson:"timeout" yaml:"timeout"`                         // 方法执行期限, 0沿用全局, 负数不限制
+	ResponseEncoder string        `json:"responseEncoder" bson:"responseEncoder" yaml:"responseEncoder"` // http响应编码, 为空沿用全局
+	Codec           *codec.Config `json:"codec" bson:"codec" yaml:"codec"`                               // http/websocket的json编解码, 为空沿用全局
 }
 
 /*服务配置,注意兼容性.Grpc服务添加前缀"grpc."*/
 type Config struct {
-	Name                string            `json:"name" bson:"name" yaml:"name"`                                              // 注册服务名,如果没有则不注册
-	HttpHost            string            `json:"httpHost" bson:"httpHost" yaml:"httpHost"`                                  // Http暴露主机,默认首个私有IP
-	HttpPort            int               `json:"httpPort" bson:"httpPort" yaml:"httpPort"`                                  // Http暴露端口, 默认80
-	HttpKeepAlive       time.Duration     `json:"httpKeepAlive" bson:"httpKeepAlive" yaml:"httpKeepAlive"`                   // Keepalive
-	HttpCheckTimeout    string            `json:"httpCheckTimeout" bson:"httpCheckTimeout" yaml:"httpCheckTimeout"`          // 注册服务心跳检测超时
-	HttpCheckInterval   string            `json:"httpCheckInterval" bson:"httpCheckInterval" yaml:"httpCheckInterval"`       // 注册服务心跳检测间隔
-	HttpCertFile        string            `json:"httpCertFile" bson:"httpCertFile" yaml:"httpCertFile"`                      // 启用TLS
-	HttpKeyFile         string            `json:"httpKeyFile" bson:"httpKeyFile" yaml:"httpKeyFile"`                         // 启用TLS
-	WbskReadBufferSize  int               `json:"wbskReadBufferSize" bson:"wbskReadBufferSize" yaml:"wbskReadBufferSize"`    // 默认4092
-	WbskWriteBufferSize int               `json:"wbskWriteBufferSize" bson:"wbskWriteBufferSize" yaml:"wbskWriteBufferSize"` // 默认4092
-	WbskNotCheckOrigin  bool              `json:"wbskNotCheckOrigin" bson:"wbskNotCheckOrigin" yaml:"wbskNotCheckOrigin"`    // 默认false
-	GrpcHost            string            `json:"grpcHost" bson:"grpcHost" yaml:"grpcHost"`                                  // 默认本机扫描到的第一个私用IP
-	GrpcPort            int               `json:"grpcPort" bson:"grpcPort" yaml:"grpcPort"`                                  // 若为空表示不启用grpc server
-	GrpcKeepAlive       time.Duration     `json:"grpcKeepAlive" bson:"grpcKeepAlive" yaml:"grpcKeepAlive"`                   // 默认不启用
-	GrpcCheckTimeout    string            `json:"grpcCheckTimeout" bson:"grpcCheckTimeout" yaml:"grpcCheckTimeout"`
-	GrpcCheckInterval   string            `json:"grpcCheckInterval" bson:"grpcCheckInterval" yaml:"grpcCheckInterval"`
-	Cache               *cache.Config     `json:"cache" bson:"cache" yaml:"cache"` // RouterConfig所用的cache
-	Accesslog           *access.Config    `json:"accesslog" bson:"accesslog" yaml:"accesslog"`
-	Arguments           map[string]string `json:"arguments" bson:"arguments" yaml:"arguments"`             // 默认参数
-	RouterConfig        []*RouterConfig   `json:"routerConfig" bson:"routerConfig" yaml:"routerConfig"`    // 从Http的path生成相应的访问规则: proxy/plugin/cache/off
-	ServerConfig        []*ServerConfig   `json:"serverConfig" bson:"serverConfig" yaml:"serverConfig"`    // 从Grpc的Service/Method生成相应http访问点的规则配置
-	ServerPlugins       [][]string        `json:"serverPlugins" bson:"serverPlugins" yaml:"serverPlugins"` // 配置ServerOption
-	RouterPlugins       [][]string        `json:"routerPlugins" bson:"routerPlugins" yaml:"routerPlugins"` // 配置全局的filterPlugin
+	Name                  string            `json:"name" bson:"name" yaml:"name"`                                                    // 注册服务名,如果没有则不注册
+	HttpHost              string            `json:"httpHost" bson:"httpHost" yaml:"httpHost"`                                        // Http暴露主机,默认首个私有IP
+	HttpPort              int               `json:"httpPort" bson:"httpPort" yaml:"httpPort"`                                        // Http暴露端口, 默认80
+	HttpKeepAlive         time.Duration     `json:"httpKeepAlive" bson:"httpKeepAlive" yaml:"httpKeepAlive"`                         // Keepalive
+	HttpCheckTimeout      string            `json:"httpCheckTimeout" bson:"httpCheckTimeout" yaml:"httpCheckTimeout"`                // 注册服务心跳检测超时
+	HttpCheckInterval     string            `json:"httpCheckInterval" bson:"httpCheckInterval" yaml:"httpCheckInterval"`             // 注册服务心跳检测间隔
+	HttpCertFile          string            `json:"httpCertFile" bson:"httpCertFile" yaml:"httpCertFile"`                            // 启用TLS
+	HttpKeyFile           string            `json:"httpKeyFile" bson:"httpKeyFile" yaml:"httpKeyFile"`                               // 启用TLS
+	HttpReadTimeout       time.Duration     `json:"httpReadTimeout" bson:"httpReadTimeout" yaml:"httpReadTimeout"`                   // http.Server读取整个请求的超时
+	HttpReadHeaderTimeout time.Duration     `json:"httpReadHeaderTimeout" bson:"httpReadHeaderTimeout" yaml:"httpReadHeaderTimeout"` // http.Server读取请求头的超时
+	HttpWriteTimeout      time.Duration     `json:"httpWriteTimeout" bson:"httpWriteTimeout" yaml:"httpWriteTimeout"`                // http.Server写响应的超时, websocket升级后解除
+	HttpIdleTimeout       time.Duration     `json:"httpIdleTimeout" bson:"httpIdleTimeout" yaml:"httpIdleTimeout"`                   // http.Server keep-alive空闲超时
+	WbskReadBufferSize    int               `json:"wbskReadBufferSize" bson:"wbskReadBufferSize" yaml:"wbskReadBufferSize"`          // 默认4092
+	WbskWriteBufferSize   int               `json:"wbskWriteBufferSize" bson:"wbskWriteBufferSize" yaml:"wbskWriteBufferSize"`       // 默认4092
+	WbskNotCheckOrigin    bool              `json:"wbskNotCheckOrigin" bson:"wbskNotCheckOrigin" yaml:"wbskNotCheckOrigin"`          // 默认false
+	WbskReadTimeout       time.Duration     `json:"wbskReadTimeout" bson:"wbskReadTimeout" yaml:"wbskReadTimeout"`                   // 等待下一条消息的超时, 默认不限制
+	MaxRequestBytes       int64             `json:"maxRequestBytes" bson:"maxRequestBytes" yaml:"maxRequestBytes"`                   // 服务接口请求体(websocket为单条消息)最大字节数, 默认0不限制
+	Timeout               time.Duration     `json:"timeout" bson:"timeout" yaml:"timeout"`                                           // 服务方法默认执行期限, 默认0不限制
+	ResponseEncoder       string            `json:"responseEncoder" bson:"responseEncoder" yaml:"responseEncoder"`                   // 服务接口http响应编码: envelope(默认) | status | raw | problem | server.ResponseEncoder注册的名称
+	Codec                 *codec.Config     `json:"codec" bson:"codec" yaml:"codec"`                                                 // 服务接口http/websocket的json编解码, 默认encoding/json
+	Negotiation           bool              `json:"negotiation" bson:"negotiation" yaml:"negotiation"`                               // 服务接口http按Content-Type/Accept协商json, protobuf, msgpack
+	GrpcHost              string            `json:"grpcHost" bson:"grpcHost" yaml:"grpcHost"`                                        // 默认本机扫描到的第一个私用IP
+	GrpcPort              int               `json:"grpcPort" bson:"grpcPort" yaml:"grpcPort"`                                        // 若为空表示不启用grpc server
+	GrpcKeepAlive         time.Duration     `json:"grpcKeepAlive" bson:"grpcKeepAlive" yaml:"grpcKeepAlive"`                         // 默认不启用
+	GrpcCheckTimeout      string            `json:"grpcCheckTimeout" bson:"grpcCheckTimeout" yaml:"grpcCheckTimeout"`
+	GrpcCheckInterval     string            `json:"grpcCheckInterval" bson:"grpcCheckInterval" yaml:"grpcCheckInterval"`
+	Cache                 *cache.Config     `json:"cache" bson:"cache" yaml:"cache"` // RouterConfig所用的cache
+	Httpx                 *proxy.Config     `json:"httpx" bson:"httpx" yaml:"httpx"` // RouterConfig代理所用的http设置, 取自conf.yml顶层httpx
+	Accesslog             *access.Config    `json:"accesslog" bson:"accesslog" yaml:"accesslog"`
+	Metrics               *metrics.Config   `json:"metrics" bson:"metrics" yaml:"metrics"`                   // prometheus指标, path为空不启用
+	Compress              *compress.Config  `json:"compress" bson:"compress" yaml:"compress"`                // http响应压缩与请求解压, 默认开启
+	Trace                 *trace.Config     `json:"trace" bson:"trace" yaml:"trace"`                         // 链路追踪, exporter为空不启用
+	Arguments             map[string]string `json:"arguments" bson:"arguments" yaml:"arguments"`             // 默认参数
+	RouterConfig          []*RouterConfig   `json:"routerConfig" bson:"routerConfig" yaml:"routerConfig"`    // 从Http的path生成相应的访问规则: proxy/plugin/cache/off
+	ServerConfig          []*ServerConfig   `json:"serverConfig" bson:"serverConfig" yaml:"serverConfig"`    // 从Grpc的Service/Method生成相应http访问点的规则配置
+	ServerPlugins         [][]string        `json:"serverPlugins" bson:"serverPlugins" yaml:"serverPlugins"` // 配置ServerOption
+	RouterPlugins         [][]string        `json:"routerPlugins" bson:"routerPlugins" yaml:"routerPlugins"` // 配置全局的filterPlugin
+	ReloadPeriod          time.Duration     `json:"reloadPeriod" bson:"reloadPeriod" yaml:"reloadPeriod"`    // 轮询conf.yml变化并热加载的间隔, 默认0不轮询. SIGHUP总会触发热加载
+	AdminPath             string            `json:"adminPath" bson:"adminPath" yaml:"adminPath"`             // 管理接口前缀, 为空不启用
+	AdminPlugins          [][]string        `json:"adminPlugins" bson:"adminPlugins" yaml:"adminPlugins"`    // 管理接口的filterPlugin, 例如hostsallow
 }
 
-const CKEY = "service"
+const (
+	CKEY       = "service"
+	HTTPX_CKEY = "httpx"
+)
 
 func LoadConfig() (ret *Config) {
-	config, ok := conf.Get(CKEY)
+	return loadConfig(conf.Get)
+}
+
+// get按顶层key取值, 启动时为conf.Get, 热加载时取自新读取的conf.yml, 校验通过前不改动全局conf
+func loadConfig(get func(key string) (interface{}, bool)) (ret *Config) {
+	config, ok := get(CKEY)
 	if !ok {
 		return
 	}
@@ -91,14 +146,35 @@ func LoadConfig() (ret *Config) {
 	ret.WbskReadBufferSize, ok = conf.ElemInt(config, "wbskReadBufferSize")
 	ret.WbskWriteBufferSize, ok = conf.ElemInt(config, "wbskWriteBufferSize")
 	ret.WbskNotCheckOrigin, ok = conf.ElemBool(config, "wbskNotCheckOrigin")
+	ret.WbskReadTimeout, ok = conf.ElemDuration(config, "wbskReadTimeout")
+	ret.MaxRequestBytes, ok = conf.ElemInt64(config, "maxRequestBytes")
+	ret.Timeout, ok = conf.ElemDuration(config, "timeout")
+	ret.ResponseEncoder, ok = conf.ElemString(config, "responseEncoder")
+	if cc, ok := conf.Elem(config, "codec"); ok {
+		ret.Codec = loadCodecConfig(cc)
+	}
+	ret.Negotiation, ok = conf.ElemBool(config, "negotiation")
+	ret.HttpReadTimeout, ok = conf.ElemDuration(config, "httpReadTimeout")
+	ret.HttpReadHeaderTimeout, ok = conf.ElemDuration(config, "httpReadHeaderTimeout")
+	ret.HttpWriteTimeout, ok = conf.ElemDuration(config, "httpWriteTimeout")
+	ret.HttpIdleTimeout, ok = conf.ElemDuration(config, "httpIdleTimeout")
 	ret.GrpcHost, ok = conf.ElemString(config, "grpcHost")
 	ret.GrpcPort, ok = conf.ElemInt(config, "grpcPort")
 	ret.GrpcKeepAlive, ok = conf.ElemDuration(config, "grpcKeepAlive")
 	ret.GrpcCheckTimeout, ok = conf.ElemString(config, "grpcCheckTimeout")
 	ret.GrpcCheckInterval, ok = conf.ElemString(config, "grpcCheckInterval")
+	ret.ReloadPeriod, ok = conf.ElemDuration(config, "reloadPeriod")
+	ret.AdminPath, ok = conf.ElemString(config, "adminPath")
 	ck, ok := conf.Elem(config, "cache")
 	if ok {
 		ret.Cache = new(cache.Config)
+		ret.Cache.Type, ok = conf.ElemString(ck, "type")
+		ret.Cache.MaxMemorySize, ok = conf.ElemInt(ck, "maxMemorySize")
+		ret.Cache.MaxMemoryBytes, ok = conf.ElemInt64(ck, "maxMemoryBytes")
+		ret.Cache.MemoryShards, ok = conf.ElemInt(ck, "memoryShards")
+		ret.Cache.ExpireInterval, ok = conf.ElemDuration(ck, "expireInterval")
+		ret.Cache.MinStatusCode, ok = conf.ElemInt(ck, "minStatusCode")
+		ret.Cache.MaxStatusCode, ok = conf.ElemInt(ck, "maxStatusCode")
 		ret.Cache.Key, ok = conf.ElemString(ck, "key")
 		ret.Cache.Network, ok = conf.ElemString(ck, "network")
 		ret.Cache.Address, ok = conf.ElemStringSlice(ck, "address")
@@ -124,8 +200,58 @@ func LoadConfig() (ret *Config) {
 		ret.Accesslog.RotateBytes, ok = conf.ElemInt64(ac, "rotateBytes")
 		ret.Accesslog.RotateCycle, ok = conf.ElemString(ac, "rotateCycle")
 		ret.Accesslog.BufioWriterSize, ok = conf.ElemInt(ac, "bufioWriterSize")
+		ret.Accesslog.Format, ok = conf.ElemString(ac, "format")
+		ret.Accesslog.Fields, ok = conf.ElemStringSlice(ac, "fields")
+	}
+	hx, ok := get(HTTPX_CKEY)
+	if ok {
+		ret.Httpx = new(proxy.Config)
+		ret.Httpx.ConnectTimeout, ok = conf.ElemDuration(hx, "connectTimeout")
+		ret.Httpx.KeepAlive, ok = conf.ElemDuration(hx, "keepAlive")
+		ret.Httpx.MaxIdleConns, ok = conf.ElemInt(hx, "maxIdleConns")
+		ret.Httpx.MaxIdleConnsPerHost, ok = conf.ElemInt(hx, "maxIdleConnsPerHost")
+		ret.Httpx.MaxConnsPerHost, ok = conf.ElemInt(hx, "maxConnsPerHost")
+		ret.Httpx.IdleConnTimeout, ok = conf.ElemDuration(hx, "idleConnTimeout")
+		ret.Httpx.TLSHandshakeTimeout, ok = conf.ElemDuration(hx, "tlsHandshakeTimeout")
+		ret.Httpx.DisableCompression, ok = conf.ElemBool(hx, "disableCompression")
+		ret.Httpx.ResponseHeaderTimeout, ok = conf.ElemDuration(hx, "responseHeaderTimeout")
+		ret.Httpx.ExpectContinueTimeout, ok = conf.ElemDuration(hx, "expectContinueTimeout")
+		ret.Httpx.MaxResponseHeaderBytes, ok = conf.ElemInt64(hx, "maxResponseHeaderBytes")
+		ret.Httpx.RequestTimeout, ok = conf.ElemDuration(hx, "requestTimeout")
+		ret.Httpx.ProxyFlushInterval, ok = conf.ElemDuration(hx, "proxyFlushInterval")
+		ret.Httpx.ProxyBufferPool, ok = conf.ElemString(hx, "proxyBufferPool")
+		ret.Httpx.ProxyErrorHandler, ok = conf.ElemString(hx, "proxyErrorHandler")
 	}
 	ret.Arguments, ok = conf.ElemStringMap(config, "arguments")
+	mc, ok := conf.Elem(config, "metrics")
+	if ok {
+		ret.Metrics = new(metrics.Config)
+		ret.Metrics.Path, ok = conf.ElemString(mc, "path")
+		ret.Metrics.Namespace, ok = conf.ElemString(mc, "namespace")
+		if bs, ok := conf.ElemSlice(mc, "buckets"); ok {
+			ret.Metrics.Buckets = make([]float64, len(bs))
+			for i, b := range bs {
+				ret.Metrics.Buckets[i] = conf.ToFloat64(b)
+			}
+		}
+	}
+	if cc, ok := conf.Elem(config, "compress"); ok {
+		ret.Compress = new(compress.Config)
+		ret.Compress.Off, _ = conf.ElemBool(cc, "off")
+		ret.Compress.MinSize, _ = conf.ElemInt(cc, "minSize")
+		ret.Compress.GzipLevel, _ = conf.ElemInt(cc, "gzipLevel")
+		ret.Compress.BrotliLevel, _ = conf.ElemInt(cc, "brotliLevel")
+		ret.Compress.Encodings, _ = conf.ElemStringSlice(cc, "encodings")
+		ret.Compress.ContentTypes, _ = conf.ElemStringSlice(cc, "contentTypes")
+	}
+	tc, ok := conf.Elem(config, "trace")
+	if ok {
+		ret.Trace = new(trace.Config)
+		ret.Trace.Exporter, ok = conf.ElemString(tc, "exporter")
+		ret.Trace.Path, ok = conf.ElemString(tc, "path")
+		ret.Trace.Service, ok = conf.ElemString(tc, "service")
+		ret.Trace.SampleRatio, ok = conf.ElemFloat64(tc, "sampleRatio")
+	}
 	rc, ok := conf.ElemSlice(config, "routerConfig")
 	if ok {
 		ret.RouterConfig = make([]*RouterConfig, len(rc))
@@ -139,15 +265,56 @@ func LoadConfig() (ret *Config) {
 			ir.ProxyPath, ok = conf.ElemString(r, "proxyPath")
 			ir.ProxyService, ok = conf.ElemString(r, "proxyService")
 			ir.ProxyHttps, ir.SetProxyHttps = conf.ElemBool(r, "proxyHttps")
+			ir.ProxyTargets, ok = conf.ElemStringSlice(r, "proxyTargets")
+			ir.ProxyPolicy, ok = conf.ElemString(r, "proxyPolicy")
+			ir.ProxyCaFile, ok = conf.ElemString(r, "proxyCaFile")
+			ir.ProxyCertFile, ok = conf.ElemString(r, "proxyCertFile")
+			ir.ProxyKeyFile, ok = conf.ElemString(r, "proxyKeyFile")
+			ir.ProxyRetries, ok = conf.ElemInt(r, "proxyRetries")
+			ir.ProxyRetryBackoff, ok = conf.ElemDuration(r, "proxyRetryBackoff")
+			ir.ProxyTryTimeout, ok = conf.ElemDuration(r, "proxyTryTimeout")
+			if bk, ok := conf.Elem(r, "proxyBreaker"); ok {
+				ir.ProxyBreaker = new(proxy.BreakerConfig)
+				ir.ProxyBreaker.ErrorRatio, ok = conf.ElemFloat64(bk, "errorRatio")
+				ir.ProxyBreaker.MinRequests, ok = conf.ElemInt(bk, "minRequests")
+				ir.ProxyBreaker.Window, ok = conf.ElemDuration(bk, "window")
+				ir.ProxyBreaker.ConsecutiveFailures, ok = conf.ElemInt(bk, "consecutiveFailures")
+				ir.ProxyBreaker.OpenTimeout, ok = conf.ElemDuration(bk, "openTimeout")
+				ir.ProxyBreaker.HalfOpenRequests, ok = conf.ElemInt(bk, "halfOpenRequests")
+			}
+			if fb, ok := conf.Elem(r, "proxyFallback"); ok {
+				ir.ProxyFallback = new(Response)
+				ir.ProxyFallback.Code, ok = conf.ElemInt(fb, "code")
+				ir.ProxyFallback.Msg, ok = conf.ElemString(fb, "msg")
+				ir.ProxyFallback.Tag, ok = conf.ElemString(fb, "tag")
+				if data, ok := conf.Elem(fb, "data"); ok {
+					ir.ProxyFallback.Data = toJsonValue(data)
+				}
+			}
 			ps, ok := conf.ElemSlice(r, "plugins")
 			if ok {
 				ir.Plugins = make([][]string, len(ps))
 				for i, p := range ps {
-					ir.Plugins[i] = conf.ToStringSlice(p)
+					ir.Plugins[i] = toPluginExpr(p)
 				}
 			}
 			ir.Cache, ok = conf.ElemInt64(r, "cache")
-			ir.Off, ir.SetOff = conf.ElemBool(r, "off")
+			ir.StaleWhileRevalidate, ok = conf.ElemInt64(r, "staleWhileRevalidate")
+			ir.StaleIfError, ok = conf.ElemInt64(r, "staleIfError")
+			ir.MaxRequestBytes, ok = conf.ElemInt64(r, "maxRequestBytes")
+			if ck, ok := conf.Elem(r, "cacheKey"); ok {
+				ir.CacheKey = new(cache.KeySpec)
+				ir.CacheKey.Headers, ok = conf.ElemStringSlice(ck, "headers")
+				ir.CacheKey.Cookies, ok = conf.ElemStringSlice(ck, "cookies")
+				ir.CacheKey.Query, ok = conf.ElemStringSlice(ck, "query")
+				ir.CacheKey.IgnoreQuery, ok = conf.ElemStringSlice(ck, "ignoreQuery")
+				ir.CacheKey.SortQuery, ok = conf.ElemBool(ck, "sortQuery")
+				ir.CacheKey.BodyFields, ok = conf.ElemStringSlice(ck, "bodyFields")
+				ir.CacheKey.IgnoreBodyFields, ok = conf.ElemStringSlice(ck, "ignoreBodyFields")
+				ir.CacheKey.IgnoreBody, ok = conf.ElemBool(ck, "ignoreBody")
+				ir.CacheKey.Vary, ok = conf.ElemBool(ck, "vary")
+			}
+			ir.Off, ir.SetOff = elemOff(r)
 			ir.Access, ir.SetAccess = conf.ElemBool(r, "access")
 			ret.RouterConfig[i] = ir
 		}
@@ -167,16 +334,26 @@ func LoadConfig() (ret *Config) {
 			if ok {
 				sr.HttpPlugins = make([][]string, len(hps))
 				for i, p := range hps {
-					sr.HttpPlugins[i] = conf.ToStringSlice(p)
+					sr.HttpPlugins[i] = toPluginExpr(p)
 				}
 			}
 			sr.WbskOff, sr.SetWbskOff = conf.ElemBool(s, "wbskOff")
+			sr.GrpcAccess, sr.SetGrpcAccess = conf.ElemBool(s, "grpcAccess")
+			sr.WbskAccess, sr.SetWbskAccess = conf.ElemBool(s, "wbskAccess")
 			sr.WbskPath, ok = conf.ElemString(s, "wbskPath")
+			sr.SseOff, sr.SetSseOff = conf.ElemBool(s, "sseOff")
+			sr.SsePath, ok = conf.ElemString(s, "ssePath")
+			sr.MaxRequestBytes, ok = conf.ElemInt64(s, "maxRequestBytes")
+			sr.Timeout, ok = conf.ElemDuration(s, "timeout")
+			sr.ResponseEncoder, ok = conf.ElemString(s, "responseEncoder")
+			if cc, ok := conf.Elem(s, "codec"); ok {
+				sr.Codec = loadCodecConfig(cc)
+			}
 			wps, ok := conf.ElemSlice(s, "wbskPlugins")
 			if ok {
 				sr.WbskPlugins = make([][]string, len(wps))
 				for i, p := range wps {
-					sr.WbskPlugins[i] = conf.ToStringSlice(p)
+					sr.WbskPlugins[i] = toPluginExpr(p)
 				}
 			}
 			ret.ServerConfig[i] = sr
@@ -186,19 +363,72 @@ func LoadConfig() (ret *Config) {
 	if ok {
 		ret.ServerPlugins = make([][]string, len(sps))
 		for i, p := range sps {
-			ret.ServerPlugins[i] = conf.ToStringSlice(p)
+			ret.ServerPlugins[i] = toPluginExpr(p)
 		}
 	}
 	rps, ok := conf.ElemSlice(config, "routerPlugins")
 	if ok {
 		ret.RouterPlugins = make([][]string, len(rps))
 		for i, p := range rps {
-			ret.RouterPlugins[i] = conf.ToStringSlice(p)
+			ret.RouterPlugins[i] = toPluginExpr(p)
+		}
+	}
+	aps, ok := conf.ElemSlice(config, "adminPlugins")
+	if ok {
+		ret.AdminPlugins = make([][]string, len(aps))
+		for i, p := range aps {
+			ret.AdminPlugins[i] = toPluginExpr(p)
 		}
 	}
 	return
 }
 
+// yaml.v2解析的map键为interface{}, json无法序列化, 需递归转换
+func toJsonValue(val interface{}) interface{} {
+	switch val := val.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			ret[conf.ToString(k)] = toJsonValue(v)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			ret[k] = toJsonValue(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, v := range val {
+			ret[i] = toJsonValue(v)
+		}
+		return ret
+	}
+	return val
+}
+
+// yaml.v2遵循YAML 1.1, 未加引号的off键会被解析为布尔false, 故需兼容两种键
+func elemOff(val interface{}) (ret bool, ok bool) {
+	if ret, ok = conf.ElemBool(val, "off"); ok {
+		return
+	}
+	if m, is := val.(map[interface{}]interface{}); is {
+		if kv, has := m[false]; has {
+			return conf.ToBool(kv), true
+		}
+	}
+	return
+}
+
+// 插件表达式原样保留, 字符串不能按逗号切分(例如"name($a,$b)"), 统一由ParsePluginExpr解析
+func toPluginExpr(p interface{}) []string {
+	if s, ok := p.(string); ok {
+		return []string{s}
+	}
+	return conf.ToStringSlice(p)
+}
+
 // 合并默认值
 func mergeConfig(conf *Config) *Config {
 
@@ -219,42 +449,55 @@ func mergeConfig(conf *Config) *Config {
 	if conf.GrpcCheckInterval == "" {
 		conf.GrpcCheckInterval = "6s"
 	}
+	if conf.Trace != nil && conf.Trace.Service == "" {
+		conf.Trace.Service = conf.Name
+	}
 	return conf
 }
 
 type RouterPlugin func(args []string) gin.HandlersChain
 type ServerPlugin func(args []string) grpc.ServerOption
 
+// 同时提供unary与stream拦截器的插件. grpc的UnaryInterceptor/StreamInterceptor只能设置一次, 故由server统一串联
+type InterceptorPlugin func(args []string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor)
+
 type ServiceHandler struct {
 	ServiceDesc *grpc.ServiceDesc
 	ServiceImpl interface{}
 	PackageName string
 	ServiceName string
 	Adapters    map[string]func(context.Context, []byte) (interface{}, error)
+	Streams     map[string]*StreamAdapter // 流式方法, 取自ServiceDesc.Streams
+	Codecs      map[string]bool           // 按codec解码请求的方法(框架解码或适配器调用codec.Unmarshal), 仅这些方法开启内容协商
 	Options     []ServiceOption
 	setting     *ServiceSetting // 经过merge计算后得到的设置x
 }
 type RegisterServiceHandler func(service interface{}) (*grpc.ServiceDesc, string, string, map[string]func(context.Context, []byte) (interface{}, error))
 
 type RouterSetting struct {
-	PackageName  string     // package name, 支持通配符?与*
-	ServiceName  string     // service name, 支持通配符?与*
-	MethodName   string     // method name, 支持通配符?与*
-	Path         string     // 请求路径, 支持通配符?与*
-	Method       string     // 请求方法(可选,默认),多值可用逗号分隔
-	ProxyPath    string     // 目标URI(必需)
-	ProxyService string     // 目标服务(必需)
-	ProxyHttps   bool       // 是否使用tls
-	Plugins      [][]string // 基于plugin生成的过滤器
-	Cache        int64      // 缓存时间(秒)
-	Off          bool       // 是否关闭
-	Access       bool       // 是否开启Access log, 0-关闭, 1-打印基本
+	PackageName          string         // package name, 支持通配符?与*
+	ServiceName          string         // service name, 支持通配符?与*
+	MethodName           string         // method name, 支持通配符?与*
+	Path                 string         // 请求路径, 支持通配符?与*
+	Method               string         // 请求方法(可选,默认),多值可用逗号分隔
+	ProxyPath            string         // 目标URI(必需)
+	ProxyService         string         // 目标服务(必需)
+	ProxyHttps           bool           // 是否使用tls
+	Plugins              [][]string     // 基于plugin生成的过滤器
+	Cache                int64          // 缓存时间(秒)
+	StaleWhileRevalidate int64          // 过期后仍返回旧条目并后台刷新的时间(秒)
+	StaleIfError         int64          // 过期后处理失败时返回旧条目的时间(秒)
+	CacheKey             *cache.KeySpec // 缓存key的组成
+	MaxRequestBytes      int64          // 请求体最大字节数
+	Off                  bool           // 是否关闭
+	Access               bool           // 是否开启Access log, 0-关闭, 1-打印基本
 }
 
 type RouterOption func(rule *RouterSetting)
 
 func MergeRouterConfig(configs []*RouterConfig) (ret []RouterOption) {
 	for _, config := range configs {
+		config := config // 闭包必须绑定当次迭代的config
 		/* 如果是代理配置会在server.compileRounterEngine()特殊处理, 可能被替换, 也可能附加! */
 		ret = append(ret, func(s *RouterSetting) {
 			if (config.Package == "" || PatternMatchs(s.PackageName, config.Package)) &&
@@ -278,6 +521,18 @@ func MergeRouterConfig(configs []*RouterConfig) (ret []RouterOption) {
 				if config.Cache > 0 {
 					s.Cache = config.Cache
 				}
+				if config.StaleWhileRevalidate > 0 {
+					s.StaleWhileRevalidate = config.StaleWhileRevalidate
+				}
+				if config.StaleIfError > 0 {
+					s.StaleIfError = config.StaleIfError
+				}
+				if config.CacheKey != nil {
+					s.CacheKey = config.CacheKey
+				}
+				if config.MaxRequestBytes != 0 {
+					s.MaxRequestBytes = config.MaxRequestBytes
+				}
 				if config.Off || config.SetOff {
 					s.Off = config.Off
 				}
@@ -292,14 +547,22 @@ func MergeRouterConfig(configs []*RouterConfig) (ret []RouterOption) {
 }
 
 type MethodSetting struct {
-	HttpOff     bool
-	HttpPath    string // ServerPathDefault(packageName, serviceName, methodName) string
-	HttpFilter  gin.HandlersChain
-	HttpPlugins [][]string // plugins的执行次序先于filter
-	WbskOff     bool
-	WbskPath    string "" // ServerPathDefault(packageName, serviceName, methodName)
-	WbskFilter  gin.HandlersChain
-	WbskPlugins [][]string // plugins的执行次序先于filter
+	HttpOff         bool
+	HttpPath        string // ServerPathDefault(packageName, serviceName, methodName) string
+	HttpFilter      gin.HandlersChain
+	HttpPlugins     [][]string // plugins的执行次序先于filter
+	WbskOff         bool
+	WbskPath        string "" // ServerPathDefault(packageName, serviceName, methodName)
+	WbskFilter      gin.HandlersChain
+	WbskPlugins     [][]string    // plugins的执行次序先于filter
+	SseOff          bool          // 关闭server-streaming方法的SSE, plugins与filter沿用http
+	SsePath         string        // SSE路径
+	WbskAccess      bool          // 是否打印websocket每条消息的access log
+	GrpcAccess      bool          // 是否打印grpc调用的access log
+	MaxRequestBytes int64         // 请求体(websocket为单条消息)最大字节数, 0沿用全局, 负数不限制
+	Timeout         time.Duration // 执行期限, 0沿用全局, 负数不限制
+	ResponseEncoder string        // http响应编码, 为空沿用全局
+	Codec           *codec.Config // http/websocket的json编解码, 为空沿用全局
 }
 
 type ServiceSetting struct {
@@ -313,6 +576,7 @@ type ServiceOption func(setting *ServiceSetting) // 会由其他方便闭包生
 
 func MergeServerConfig(configs []*ServerConfig) (ret []ServiceOption) {
 	for _, config := range configs {
+		config := config // 闭包必须绑定当次迭代的config
 		ret = append(ret, func(s *ServiceSetting) {
 			if (config.Package == "" || PatternMatchs(s.PackageName, config.Package)) &&
 				(config.Service == "" || PatternMatchs(s.ServiceName, config.Service)) {
@@ -339,6 +603,30 @@ func MergeServerConfig(configs []*ServerConfig) (ret []ServiceOption) {
 						if len(config.WbskPlugins) > 0 {
 							ms.WbskPlugins = config.WbskPlugins
 						}
+						if config.SseOff || config.SetSseOff {
+							ms.SseOff = config.SseOff
+						}
+						if config.SsePath != "" {
+							ms.SsePath = config.SsePath
+						}
+						if config.WbskAccess || config.SetWbskAccess {
+							ms.WbskAccess = config.WbskAccess
+						}
+						if config.GrpcAccess || config.SetGrpcAccess {
+							ms.GrpcAccess = config.GrpcAccess
+						}
+						if config.MaxRequestBytes != 0 {
+							ms.MaxRequestBytes = config.MaxRequestBytes
+						}
+						if config.Timeout != 0 {
+							ms.Timeout = config.Timeout
+						}
+						if config.ResponseEncoder != "" {
+							ms.ResponseEncoder = config.ResponseEncoder
+						}
+						if config.Codec != nil {
+							ms.Codec = config.Codec
+						}
 					}
 				}
 			}
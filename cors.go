@@ -0,0 +1,181 @@
+package pbapi
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_ORIGIN                           = "Origin"
+	HEADER_VARY                             = "Vary"
+	HEADER_ACCESS_CONTROL_REQUEST_METHOD    = "Access-Control-Request-Method"
+	HEADER_ACCESS_CONTROL_REQUEST_HEADERS   = "Access-Control-Request-Headers"
+	HEADER_ACCESS_CONTROL_ALLOW_ORIGIN      = "Access-Control-Allow-Origin"
+	HEADER_ACCESS_CONTROL_ALLOW_METHODS     = "Access-Control-Allow-Methods"
+	HEADER_ACCESS_CONTROL_ALLOW_HEADERS     = "Access-Control-Allow-Headers"
+	HEADER_ACCESS_CONTROL_ALLOW_CREDENTIALS = "Access-Control-Allow-Credentials"
+	HEADER_ACCESS_CONTROL_EXPOSE_HEADERS    = "Access-Control-Expose-Headers"
+	HEADER_ACCESS_CONTROL_MAX_AGE           = "Access-Control-Max-Age"
+
+	CORS_PLUGIN      = "cors"
+	CORS_ORIGIN      = "origin:" // 允许的源, 支持*通配(不跨越/), 例如"origin:https://*.example.com", 可多个
+	CORS_METHOD      = "method:" // 允许的方法, 可多个
+	CORS_HEADER      = "header:" // 允许的请求头, 可多个. 默认回显Access-Control-Request-Headers
+	CORS_EXPOSE      = "expose:" // 暴露的响应头, 可多个
+	CORS_MAX_AGE     = "maxAge:" // 预检缓存时间, 例如"maxAge:10m"
+	CORS_CREDENTIALS = "credentials"
+)
+
+var defaultCorsMethods = []string{MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch, MethodDelete}
+
+/*
+cors的参数规则: cors([origin:<源>...], [method:<方法>...], [header:<请求头>...], [expose:<响应头>...], [maxAge:<时长>], [credentials])
+1. 没有origin表示允许任意源, 带credentials时回显Origin而非*
+2. 预检请求(OPTIONS且带Access-Control-Request-Method)直接返回204
+3. 配置了cors的路径自动注册OPTIONS路由, 预检仅经过access log与cors, 不受jwt等插件拦截
+4. routerConfig中的cors覆盖routerPlugins中的全局cors
+*/
+type Cors struct {
+	Origins     []string
+	patterns    []*regexp.Regexp
+	Methods     string
+	Headers     string
+	Expose      string
+	MaxAge      string
+	Credentials bool
+}
+
+func NewCors(args []string) (*Cors, error) {
+	c := new(Cors)
+	var methods, headers, expose []string
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		switch {
+		case arg == "":
+		case arg == CORS_CREDENTIALS:
+			c.Credentials = true
+		case strings.HasPrefix(arg, CORS_ORIGIN):
+			if v := strings.TrimSpace(arg[len(CORS_ORIGIN):]); v != "*" {
+				c.Origins = append(c.Origins, v)
+				c.patterns = append(c.patterns, compileOriginPattern(v))
+			}
+		case strings.HasPrefix(arg, CORS_METHOD):
+			methods = append(methods, strings.ToUpper(strings.TrimSpace(arg[len(CORS_METHOD):])))
+		case strings.HasPrefix(arg, CORS_HEADER):
+			headers = append(headers, strings.TrimSpace(arg[len(CORS_HEADER):]))
+		case strings.HasPrefix(arg, CORS_EXPOSE):
+			expose = append(expose, strings.TrimSpace(arg[len(CORS_EXPOSE):]))
+		case strings.HasPrefix(arg, CORS_MAX_AGE):
+			d, err := time.ParseDuration(strings.TrimSpace(arg[len(CORS_MAX_AGE):]))
+			if err != nil {
+				return nil, err
+			}
+			c.MaxAge = strconv.FormatInt(int64(d/time.Second), 10)
+		default:
+			return nil, errors.New("cors invalid argument: " + arg)
+		}
+	}
+	if len(methods) == 0 {
+		methods = defaultCorsMethods
+	}
+	c.Methods = strings.Join(methods, ", ")
+	c.Headers = strings.Join(headers, ", ")
+	c.Expose = strings.Join(expose, ", ")
+	return c, nil
+}
+
+func (c *Cors) AllowOrigin(origin string) bool {
+	if len(c.Origins) == 0 {
+		return true
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// 源只把*视为通配, 其余字符(包括.)按字面匹配, 避免https://*.example.com放行https://evil-example.com
+func compileOriginPattern(p string) *regexp.Regexp {
+	buf := new(strings.Builder)
+	buf.WriteByte('^')
+	for i, v := range strings.Split(p, "*") {
+		if i > 0 {
+			buf.WriteString("[^/]*")
+		}
+		buf.WriteString(regexp.QuoteMeta(v))
+	}
+	buf.WriteByte('$')
+	return regexp.MustCompile(buf.String())
+}
+
+func (c *Cors) HandlerFunc() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		origin := ctx.Request.Header.Get(HEADER_ORIGIN)
+		if origin == "" {
+			return // 非跨域请求
+		}
+		preflight := ctx.Request.Method == http.MethodOptions && ctx.Request.Header.Get(HEADER_ACCESS_CONTROL_REQUEST_METHOD) != ""
+		header := ctx.Writer.Header()
+		header.Add(HEADER_VARY, HEADER_ORIGIN)
+		if !c.AllowOrigin(origin) {
+			if preflight {
+				ctx.AbortWithStatus(http.StatusForbidden)
+			}
+			return // 不附加CORS头, 由浏览器拦截
+		}
+		if len(c.Origins) == 0 && !c.Credentials {
+			header.Set(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN, "*")
+		} else {
+			header.Set(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN, origin)
+		}
+		if c.Credentials {
+			header.Set(HEADER_ACCESS_CONTROL_ALLOW_CREDENTIALS, "true")
+		}
+		if !preflight {
+			if c.Expose != "" {
+				header.Set(HEADER_ACCESS_CONTROL_EXPOSE_HEADERS, c.Expose)
+			}
+			return
+		}
+		header.Set(HEADER_ACCESS_CONTROL_ALLOW_METHODS, c.Methods)
+		if c.Headers != "" {
+			header.Set(HEADER_ACCESS_CONTROL_ALLOW_HEADERS, c.Headers)
+		} else if reqHeaders := ctx.Request.Header.Get(HEADER_ACCESS_CONTROL_REQUEST_HEADERS); reqHeaders != "" {
+			header.Add(HEADER_VARY, HEADER_ACCESS_CONTROL_REQUEST_HEADERS)
+			header.Set(HEADER_ACCESS_CONTROL_ALLOW_HEADERS, reqHeaders)
+		}
+		if c.MaxAge != "" {
+			header.Set(HEADER_ACCESS_CONTROL_MAX_AGE, c.MaxAge)
+		}
+		ctx.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+func CorsRouterPlugin(args []string) gin.HandlersChain {
+	c, err := NewCors(args)
+	if err != nil {
+		panic(err) // 编译阶段捕获, 启动失败或拒绝热加载
+	}
+	return gin.HandlersChain{c.HandlerFunc()}
+}
+
+// 自动注册的OPTIONS路由的末端处理器, 非预检的OPTIONS请求返回204
+func corsPreflightHandler(ctx *gin.Context) {
+	ctx.Status(http.StatusNoContent)
+}
+
+func hasPlugin(plugins [][]string, name string) bool {
+	for _, v := range plugins {
+		if len(v) > 0 && v[0] == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCors(t *testing.T) {
+	s := NewServer()
+	s.POST("/a", func(ctx *gin.Context) { ctx.String(http.StatusOK, "a") })
+	s.GET("/b", func(ctx *gin.Context) { ctx.String(http.StatusOK, "b") })
+
+	config := mergeConfig(&Config{
+		RouterPlugins: [][]string{{"cors", "origin:https://*.example.com"}, {"jwt", "secret:s3cret"}},
+		RouterConfig:  []*RouterConfig{{Path: "/b", Plugins: [][]string{{"cors", "credentials", "maxAge:10m"}}}},
+	})
+	engine, err := s.compileHttpEngine(config, &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set(HEADER_ORIGIN, origin)
+		if preflight {
+			r.Header.Set(HEADER_ACCESS_CONTROL_REQUEST_METHOD, http.MethodPost)
+			r.Header.Set(HEADER_ACCESS_CONTROL_REQUEST_HEADERS, "Authorization")
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	// 预检不经过jwt
+	w := do(http.MethodOptions, "/a", "https://app.example.com", true)
+	if w.Code != http.StatusNoContent || w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN) != "https://app.example.com" ||
+		w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_HEADERS) != "Authorization" {
+		t.Fatalf("unexpected preflight: %v %v", w.Code, w.Header())
+	}
+	if w = do(http.MethodOptions, "/a", "https://evil.com", true); w.Code != http.StatusForbidden {
+		t.Fatalf("expect forbidden, got %v", w.Code)
+	}
+	// .按字面匹配, 形似的源不放行
+	for _, origin := range []string{"https://evil-example.com", "https://appXexample.com", "http://app.example.com", "https://app.example.com.evil.com"} {
+		if w = do(http.MethodOptions, "/a", origin, true); w.Code != http.StatusForbidden || w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN) != "" {
+			t.Fatalf("expect forbidden for %v, got %v %v", origin, w.Code, w.Header())
+		}
+	}
+	// 实际请求附加CORS头后仍由jwt拦截
+	w = do(http.MethodPost, "/a", "https://app.example.com", false)
+	if w.Code != http.StatusUnauthorized || w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN) == "" {
+		t.Fatalf("unexpected response: %v %v", w.Code, w.Header())
+	}
+	// 路由级cors覆盖全局
+	w = do(http.MethodOptions, "/b", "https://other.org", true)
+	if w.Code != http.StatusNoContent || w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_ORIGIN) != "https://other.org" ||
+		w.Header().Get(HEADER_ACCESS_CONTROL_ALLOW_CREDENTIALS) != "true" || w.Header().Get(HEADER_ACCESS_CONTROL_MAX_AGE) != "600" {
+		t.Fatalf("unexpected preflight: %v %v", w.Code, w.Header())
+	}
+}
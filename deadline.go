@@ -0,0 +1,101 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"time"
+)
+
+const HEADER_GRPC_TIMEOUT = "Grpc-Timeout" // 客户端期望的超时, 格式同grpc-timeout: 正整数加单位H/M/S/m/u/n, 例如"500m"
+
+// 解析grpc-timeout格式, 失败返回false
+func ParseGrpcTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 || len(v) > 9 { // 规范要求最多8位数字
+		return 0, false
+	}
+	var unit time.Duration
+	switch v[len(v)-1] {
+	case 'H':
+		unit = time.Hour
+	case 'M':
+		unit = time.Minute
+	case 'S':
+		unit = time.Second
+	case 'm':
+		unit = time.Millisecond
+	case 'u':
+		unit = time.Microsecond
+	case 'n':
+		unit = time.Nanosecond
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
+
+/*
+为服务适配器附加执行期限:
+1. timeout为方法设置(<=0表示不限制), 客户端Grpc-Timeout头只能缩短期限
+2. 期限内未完成且返回错误时, 替换为DEADLINE_EXCEEDED_ERROR响应
+注意: 适配器须自行响应ctx.Done(), 此处不另起goroutine以免gin.Context被复用
+*/
+func WithTimeout(tag string, timeout time.Duration, fn func(context.Context, []byte) (interface{}, error)) func(context.Context, []byte) (interface{}, error) {
+	return func(ctx context.Context, data []byte) (interface{}, error) {
+		d := timeout
+		if c, ok := ctx.(*gin.Context); ok {
+			if v := c.Request.Header.Get(HEADER_GRPC_TIMEOUT); v != "" {
+				if cd, ok := ParseGrpcTimeout(v); ok && (d <= 0 || cd < d) {
+					d = cd
+				}
+			}
+		}
+		if d <= 0 {
+			return fn(ctx, data)
+		}
+		// gin.Context.Value按string键查找, 派生后仍可读取claims/span等
+		tctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		rsp, err := fn(tctx, data)
+		if err != nil && tctx.Err() == context.DeadlineExceeded {
+			return nil, &Response{
+				Code: DEADLINE_EXCEEDED_ERROR,
+				Msg:  "deadline exceeded: " + d.String(),
+				Tag:  tag,
+			}
+		}
+		return rsp, err
+	}
+}
+
+// grpc unary方法的执行期限, 以FullMethod索引. 客户端grpc-timeout由grpc自身处理, 取两者较短者
+func timeoutUnaryServerInterceptor(timeouts map[string]time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		d := timeouts[info.FullMethod]
+		if d <= 0 {
+			return handler(ctx, req)
+		}
+		tctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		rsp, err := handler(tctx, req)
+		if err != nil && tctx.Err() == context.DeadlineExceeded && status.Code(err) != codes.DeadlineExceeded {
+			err = status.Error(codes.DeadlineExceeded, err.Error())
+		}
+		return rsp, err
+	}
+}
+
+// 方法设置优先于全局
+func methodTimeout(config *Config, ms *MethodSetting) time.Duration {
+	if ms != nil && ms.Timeout != 0 {
+		return ms.Timeout
+	}
+	return config.Timeout
+}
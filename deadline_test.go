@@ -0,0 +1,69 @@
+package pbapi
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGrpcTimeout(t *testing.T) {
+	for v, expect := range map[string]time.Duration{
+		"500m": 500 * time.Millisecond,
+		"2S":   2 * time.Second,
+		"1H":   time.Hour,
+		"10":   0,
+		"m":    0,
+		"-1S":  0,
+	} {
+		if d, _ := ParseGrpcTimeout(v); d != expect {
+			t.Errorf("%v: expect %v, got %v", v, expect, d)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	wait := func(ctx context.Context, data []byte) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return "done", nil
+		}
+	}
+	s := newDemoServer("WaitService", map[string]func(context.Context, []byte) (interface{}, error){"Wait": wait, "Free": wait})
+	engine := compileDemoEngine(t, s, &Config{
+		Timeout:      20 * time.Millisecond,
+		ServerConfig: []*ServerConfig{{Method: "Free", Timeout: -1}},
+	}, &httpRuntime{})
+	do := func(path string, timeout string) (string, time.Duration) {
+		r := httptest.NewRequest(http.MethodPost, path, nil)
+		if timeout != "" {
+			r.Header.Set(HEADER_GRPC_TIMEOUT, timeout)
+		}
+		w := httptest.NewRecorder()
+		start := time.Now()
+		engine.ServeHTTP(w, r)
+		return w.Body.String(), time.Since(start)
+	}
+	if body, cost := do("/demo/wait/wait", ""); !strings.Contains(body, `"code":608`) || cost > 500*time.Millisecond {
+		t.Fatalf("expect deadline exceeded, got %s in %v", body, cost)
+	}
+	// 客户端头只能缩短期限
+	if body, cost := do("/demo/wait/free", "10m"); !strings.Contains(body, `"code":608`) || cost > 500*time.Millisecond {
+		t.Fatalf("expect deadline exceeded, got %s in %v", body, cost)
+	}
+
+	interceptor := timeoutUnaryServerInterceptor(map[string]time.Duration{"/demo.WaitService/Wait": 10 * time.Millisecond})
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/demo.WaitService/Wait"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return wait(ctx, nil)
+	})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expect DeadlineExceeded, got %v", err)
+	}
+}
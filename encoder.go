@@ -0,0 +1,180 @@
+package pbapi
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"sync"
+)
+
+const (
+	ENCODER_ENVELOPE = "envelope" // 默认: 总是200及Response包
+	ENCODER_STATUS   = "status"   // Response包, 但失败时按错误映射http状态码
+	ENCODER_RAW      = "raw"      // 成功直接返回data, 失败按错误映射状态码并返回Response包
+	ENCODER_PROBLEM  = "problem"  // 成功直接返回data, 失败返回RFC 7807 problem+json
+)
+
+var ProblemContentType = []string{"application/problem+json; charset=utf-8"}
+
+/*
+服务适配器结果的编码策略, 仅作用于http, websocket仍使用Response包.
+err为适配器返回的原始错误, 可用ErrorResponse与ErrorStatus归一化
+*/
+type ResponseEncoder func(c *gin.Context, tag string, data interface{}, err error)
+
+// 错误统一为Response, 非Response错误视为EXECUTE_SERVICE_ERROR
+func ErrorResponse(tag string, err error) *Response {
+	if ersp, ok := err.(*Response); ok {
+		return ersp
+	}
+	return &Response{
+		Code: EXECUTE_SERVICE_ERROR,
+		Msg:  err.Error(),
+		Tag:  tag,
+	}
+}
+
+var (
+	codeStatusLock sync.RWMutex
+	codeStatus     = map[int]int{
+		UNKNOWN:                 http.StatusInternalServerError,
+		READING_REQUEST_ERROR:   http.StatusBadRequest,
+		PARSING_REQUEST_ERROR:   http.StatusBadRequest,
+		EXECUTE_SERVICE_ERROR:   http.StatusInternalServerError,
+		ACCESS_DENIED_ERROR:     http.StatusForbidden,
+		CIRCUIT_OPEN_ERROR:      http.StatusServiceUnavailable,
+		RATE_LIMITED_ERROR:      http.StatusTooManyRequests,
+		UNAUTHORIZED_ERROR:      http.StatusUnauthorized,
+		DEADLINE_EXCEEDED_ERROR: http.StatusGatewayTimeout,
+		INTERNAL_ERROR:          http.StatusInternalServerError,
+	}
+	// 同grpc-gateway的映射
+	grpcStatus = map[codes.Code]int{
+		codes.OK:                 http.StatusOK,
+		codes.Canceled:           499,
+		codes.Unknown:            http.StatusInternalServerError,
+		codes.InvalidArgument:    http.StatusBadRequest,
+		codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+		codes.NotFound:           http.StatusNotFound,
+		codes.AlreadyExists:      http.StatusConflict,
+		codes.PermissionDenied:   http.StatusForbidden,
+		codes.Unauthenticated:    http.StatusUnauthorized,
+		codes.ResourceExhausted:  http.StatusTooManyRequests,
+		codes.FailedPrecondition: http.StatusBadRequest,
+		codes.Aborted:            http.StatusConflict,
+		codes.OutOfRange:         http.StatusBadRequest,
+		codes.Unimplemented:      http.StatusNotImplemented,
+		codes.Internal:           http.StatusInternalServerError,
+		codes.Unavailable:        http.StatusServiceUnavailable,
+		codes.DataLoss:           http.StatusInternalServerError,
+	}
+)
+
+// 注册业务错误码对应的http状态码
+func MapErrorCode(code int, httpStatus int) {
+	codeStatusLock.Lock()
+	codeStatus[code] = httpStatus
+	codeStatusLock.Unlock()
+}
+
+/*
+错误对应的http状态码:
+1. Response: 已注册的映射, 否则code在400~599之间取code, 其余为500
+2. grpc status错误: 按grpc code映射
+3. 其他错误: 500
+*/
+func ErrorStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	if ersp, ok := err.(*Response); ok {
+		codeStatusLock.RLock()
+		ret, ok := codeStatus[ersp.Code]
+		codeStatusLock.RUnlock()
+		if ok {
+			return ret
+		}
+		if ersp.Code >= 400 && ersp.Code <= 599 {
+			return ersp.Code
+		}
+		return http.StatusInternalServerError
+	}
+	if st, ok := status.FromError(err); ok {
+		if ret, ok := grpcStatus[st.Code()]; ok {
+			return ret
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJson(c *gin.Context, contentType []string, code int, v interface{}) {
+	wdata, _ := json.Marshal(v)
+	c.Writer.Header()["Content-Type"] = contentType
+	c.Writer.WriteHeader(code)
+	c.Writer.Write(wdata)
+}
+
+func EnvelopeEncoder(c *gin.Context, tag string, data interface{}, err error) {
+	if err != nil {
+		writeJson(c, JsonContentType, http.StatusOK, ErrorResponse(tag, err))
+		return
+	}
+	writeJson(c, JsonContentType, http.StatusOK, &Response{
+		Code: SUCCESS,
+		Data: data,
+		Tag:  tag,
+	})
+}
+
+func StatusEncoder(c *gin.Context, tag string, data interface{}, err error) {
+	if err != nil {
+		writeJson(c, JsonContentType, ErrorStatus(err), ErrorResponse(tag, err))
+		return
+	}
+	EnvelopeEncoder(c, tag, data, nil)
+}
+
+func RawEncoder(c *gin.Context, tag string, data interface{}, err error) {
+	if err != nil {
+		writeJson(c, JsonContentType, ErrorStatus(err), ErrorResponse(tag, err))
+		return
+	}
+	writeJson(c, JsonContentType, http.StatusOK, data)
+}
+
+// RFC 7807, 扩展成员code/tag保留Response的信息
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     int    `json:"code"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+func ProblemEncoder(c *gin.Context, tag string, data interface{}, err error) {
+	if err == nil {
+		writeJson(c, JsonContentType, http.StatusOK, data)
+		return
+	}
+	code := ErrorStatus(err)
+	ersp := ErrorResponse(tag, err)
+	detail := ersp.Msg
+	if _, ok := err.(*Response); !ok {
+		if st, ok := status.FromError(err); ok {
+			detail = st.Message()
+		}
+	}
+	writeJson(c, ProblemContentType, code, &Problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(code),
+		Status:   code,
+		Detail:   detail,
+		Instance: c.Request.URL.Path,
+		Code:     ersp.Code,
+		Tag:      ersp.Tag,
+	})
+}
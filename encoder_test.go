@@ -0,0 +1,55 @@
+package pbapi
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseEncoder(t *testing.T) {
+	var fail error
+	s := newDemoServer("EchoService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Echo": func(ctx context.Context, data []byte) (interface{}, error) {
+			if fail != nil {
+				return nil, fail
+			}
+			return map[string]string{"echo": string(data)}, nil
+		},
+	})
+	cases := []struct {
+		encoder string
+		err     error
+		status  int
+		body    string
+	}{
+		{ENCODER_ENVELOPE, nil, http.StatusOK, `{"code":0,"data":{"echo":"hi"},"tag":"EchoService.Echo"}`},
+		{ENCODER_ENVELOPE, FailureResponse(RATE_LIMITED_ERROR, "slow down"), http.StatusOK, `{"code":606,"msg":"slow down"}`},
+		{ENCODER_STATUS, FailureResponse(RATE_LIMITED_ERROR, "slow down"), http.StatusTooManyRequests, `{"code":606,"msg":"slow down"}`},
+		{ENCODER_RAW, nil, http.StatusOK, `{"echo":"hi"}`},
+		{ENCODER_RAW, status.Error(codes.NotFound, "no such user"), http.StatusNotFound, `"code":603`},
+		{ENCODER_RAW, errors.New("oops"), http.StatusInternalServerError, `{"code":603,"msg":"oops","tag":"EchoService.Echo"}`},
+		{ENCODER_PROBLEM, status.Error(codes.NotFound, "no such user"), http.StatusNotFound,
+			`{"type":"about:blank","title":"Not Found","status":404,"detail":"no such user","instance":"/demo/echo/echo","code":603,"tag":"EchoService.Echo"}`},
+	}
+	for _, c := range cases {
+		fail = c.err
+		// 全局设置, 由方法设置覆盖
+		engine := compileDemoEngine(t, s, &Config{
+			ResponseEncoder: ENCODER_PROBLEM,
+			ServerConfig:    []*ServerConfig{{Method: "Echo", ResponseEncoder: c.encoder}},
+		}, &httpRuntime{})
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/demo/echo/echo", strings.NewReader("hi")))
+		if w.Code != c.status || !strings.Contains(w.Body.String(), c.body) {
+			t.Errorf("%v %v: expect %v %s, got %v %s", c.encoder, c.err, c.status, c.body, w.Code, w.Body.String())
+		}
+	}
+	if _, err := s.compileHttpEngine(mergeConfig(&Config{ResponseEncoder: "none"}), &httpRuntime{}); err == nil {
+		t.Error("expect invalid response encoder")
+	}
+}
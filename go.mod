@@ -3,6 +3,7 @@ module github.com/obase/pbapi
 go 1.14
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gin-gonic/gin v1.6.3
 	github.com/gorilla/websocket v1.4.2
 	github.com/obase/center v1.10.7
@@ -10,6 +11,10 @@ require (
 	github.com/obase/kit v1.0.1
 	github.com/obase/log v1.10.7
 	github.com/obase/redis.v2 v1.0.1
+	github.com/prometheus/client_golang v1.7.1
+	github.com/ugorji/go/codec v1.1.7
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013
 	google.golang.org/grpc v1.29.1
 	google.golang.org/protobuf v1.24.0
+	gopkg.in/yaml.v2 v2.3.0
 )
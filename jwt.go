@@ -0,0 +1,174 @@
+package pbapi
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/jwt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_AUTHORIZATION    = "Authorization"
+	HEADER_WWW_AUTHENTICATE = "WWW-Authenticate"
+	MD_AUTHORIZATION        = "authorization"
+
+	JWT_SECRET   = "secret:" // HS256密钥, 可多个
+	JWT_PEM      = "pem:"    // RS256/ES256公钥或证书文件, 可多个
+	JWT_JWKS     = "jwks:"   // 本地JWKS文件
+	JWT_ISSUER   = "iss:"
+	JWT_AUDIENCE = "aud:"
+	JWT_LEEWAY   = "leeway:" // 时钟偏差, 例如"leeway:30s"
+	JWT_QUERY    = "query:"  // 无Authorization时从该查询参数取token, 用于浏览器websocket
+	JWT_OPTIONAL = "optional"
+)
+
+/*
+jwt的参数规则: jwt(secret:<密钥>|pem:<文件>|jwks:<文件>..., [iss:<签发者>], [aud:<受众>], [leeway:<偏差>], [query:<参数名>], [optional])
+1. 支持HS256/RS256/ES256, token带kid时按kid选择JWKS密钥
+2. 校验exp/nbf, 配置了iss/aud时同时校验
+3. optional表示没有token时放行(无claims), 但token无效仍拒绝
+校验通过的claims可由jwt.FromContext(ctx)在服务实现中读取
+*/
+type Jwt struct {
+	Verifier *jwt.Verifier
+	Query    string
+	Optional bool
+}
+
+func NewJwt(args []string) (*Jwt, error) {
+	ret := new(Jwt)
+	conf := new(jwt.Config)
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		switch {
+		case arg == "":
+		case arg == JWT_OPTIONAL:
+			ret.Optional = true
+		case strings.HasPrefix(arg, JWT_SECRET):
+			conf.Secrets = append(conf.Secrets, arg[len(JWT_SECRET):])
+		case strings.HasPrefix(arg, JWT_PEM):
+			conf.PemFiles = append(conf.PemFiles, strings.TrimSpace(arg[len(JWT_PEM):]))
+		case strings.HasPrefix(arg, JWT_JWKS):
+			conf.JwksFile = strings.TrimSpace(arg[len(JWT_JWKS):])
+		case strings.HasPrefix(arg, JWT_ISSUER):
+			conf.Issuer = strings.TrimSpace(arg[len(JWT_ISSUER):])
+		case strings.HasPrefix(arg, JWT_AUDIENCE):
+			conf.Audience = strings.TrimSpace(arg[len(JWT_AUDIENCE):])
+		case strings.HasPrefix(arg, JWT_LEEWAY):
+			d, err := time.ParseDuration(strings.TrimSpace(arg[len(JWT_LEEWAY):]))
+			if err != nil {
+				return nil, err
+			}
+			conf.Leeway = d
+		case strings.HasPrefix(arg, JWT_QUERY):
+			ret.Query = strings.TrimSpace(arg[len(JWT_QUERY):])
+		default:
+			return nil, errors.New("jwt invalid argument: " + arg)
+		}
+	}
+	v, err := jwt.NewVerifier(conf)
+	if err != nil {
+		return nil, err
+	}
+	ret.Verifier = v
+	return ret, nil
+}
+
+var errMissingToken = errors.New("jwt missing bearer token")
+
+// 取Bearer token, 没有返回空
+func bearerToken(auth string) string {
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// 没有token且optional时返回nil, nil
+func (j *Jwt) verify(token string) (jwt.Claims, error) {
+	if token == "" {
+		if j.Optional {
+			return nil, nil
+		}
+		return nil, errMissingToken
+	}
+	return j.Verifier.Verify(token)
+}
+
+func JwtRouterPlugin(args []string) gin.HandlersChain {
+	j, err := NewJwt(args)
+	if err != nil {
+		panic(err) // 编译阶段捕获, 启动失败或拒绝热加载
+	}
+	return gin.HandlersChain{func(ctx *gin.Context) {
+		token := bearerToken(ctx.Request.Header.Get(HEADER_AUTHORIZATION))
+		if token == "" && j.Query != "" {
+			token = ctx.Query(j.Query)
+		}
+		claims, err := j.verify(token)
+		if err != nil {
+			ctx.Header(HEADER_WWW_AUTHENTICATE, `Bearer error="invalid_token"`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, &Response{
+				Code: UNAUTHORIZED_ERROR,
+				Msg:  err.Error(),
+			})
+			return
+		}
+		if claims != nil {
+			// CreateHandlerFunc4Http/Wbsk以gin.Context作为context.Context传给服务
+			ctx.Set(jwt.CONTEXT_KEY, claims)
+		}
+	}}
+}
+
+type jwtServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *jwtServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func JwtServerPlugin(args []string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	j, err := NewJwt(args)
+	if err != nil {
+		panic(err)
+	}
+	auth := func(ctx context.Context) (context.Context, error) {
+		var token string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if vs := md.Get(MD_AUTHORIZATION); len(vs) > 0 {
+				token = bearerToken(vs[0])
+			}
+		}
+		claims, err := j.verify(token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if claims != nil {
+			ctx = jwt.NewContext(ctx, claims)
+		}
+		return ctx, nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := auth(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := auth(ss.Context())
+			if err != nil {
+				return err
+			}
+			return handler(srv, &jwtServerStream{ServerStream: ss, ctx: ctx})
+		}
+}
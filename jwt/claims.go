@@ -0,0 +1,120 @@
+package jwt
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 已校验的声明, 数值为json.Number
+type Claims map[string]interface{}
+
+func (c Claims) String(name string) string {
+	switch v := c[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func (c Claims) Int64(name string) (int64, bool) {
+	switch v := c[name].(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		if f, err := v.Float64(); err == nil {
+			return int64(f), true
+		}
+	case float64:
+		return int64(v), true
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func (c Claims) Float64(name string) (float64, bool) {
+	switch v := c[name].(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f, true
+		}
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func (c Claims) Bool(name string) bool {
+	v, _ := c[name].(bool)
+	return v
+}
+
+// 单值字符串视为只有一个元素
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		ret := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	f, ok := c.Float64(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), true
+}
+
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) Issuer() string {
+	return c.String("iss")
+}
+
+func (c Claims) Audience() []string {
+	return c.Strings("aud")
+}
+
+func (c Claims) ID() string {
+	return c.String("jti")
+}
+
+func (c Claims) ExpiresAt() time.Time {
+	t, _ := c.time("exp")
+	return t
+}
+
+func (c Claims) IssuedAt() time.Time {
+	t, _ := c.time("iat")
+	return t
+}
+
+// 常用的scope声明, 空格分隔
+func (c Claims) Scopes() []string {
+	if s, ok := c["scope"].(string); ok {
+		return strings.Fields(s)
+	}
+	return c.Strings("scp")
+}
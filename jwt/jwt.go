@@ -0,0 +1,253 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	CONTEXT_KEY = "pbapi.jwt.claims" // gin.Context.Value仅支持string键
+
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+)
+
+var (
+	ErrMalformed = errors.New("jwt malformed token")
+	ErrAlgorithm = errors.New("jwt unsupported algorithm")
+	ErrNoKey     = errors.New("jwt no matching key")
+	ErrSignature = errors.New("jwt invalid signature")
+	ErrExpired   = errors.New("jwt token expired")
+	ErrNotBefore = errors.New("jwt token not valid yet")
+	ErrIssuer    = errors.New("jwt invalid issuer")
+	ErrAudience  = errors.New("jwt invalid audience")
+)
+
+type Config struct {
+	Secrets  []string      // HS256密钥
+	PemFiles []string      // RS256/ES256公钥或证书(PEM)
+	JwksFile string        // 本地JWKS文件
+	Issuer   string        // 非空则校验iss
+	Audience string        // 非空则校验aud
+	Leeway   time.Duration // exp/nbf容许的时钟偏差
+}
+
+type Verifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+func NewVerifier(c *Config) (*Verifier, error) {
+	keys := new(KeySet)
+	for _, s := range c.Secrets {
+		keys.Add("", HS256, []byte(s))
+	}
+	for _, f := range c.PemFiles {
+		if err := keys.LoadPem(f); err != nil {
+			return nil, err
+		}
+	}
+	if c.JwksFile != "" {
+		if err := keys.LoadJwks(c.JwksFile); err != nil {
+			return nil, err
+		}
+	}
+	if keys.Len() == 0 {
+		return nil, errors.New("jwt requires secret, pem or jwks")
+	}
+	return &Verifier{
+		keys:     keys,
+		issuer:   c.Issuer,
+		audience: c.Audience,
+		leeway:   c.Leeway,
+		now:      time.Now,
+	}, nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+func decodeSegment(seg string) ([]byte, error) {
+	// 兼容带padding的实现
+	return encoding.DecodeString(strings.TrimRight(seg, "="))
+}
+
+// 校验签名及exp/nbf/iss/aud, 返回声明
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	hbs, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var h header
+	if err = json.Unmarshal(hbs, &h); err != nil {
+		return nil, ErrMalformed
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if h.Alg != HS256 && h.Alg != RS256 && h.Alg != ES256 {
+		return nil, ErrAlgorithm
+	}
+	keys := v.keys.Find(h.Kid, h.Alg)
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+	verified := false
+	for _, k := range keys {
+		if verify(h.Alg, k, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrSignature
+	}
+
+	pbs, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	dec := json.NewDecoder(bytes.NewReader(pbs))
+	dec.UseNumber()
+	if err = dec.Decode(&claims); err != nil || claims == nil {
+		return nil, ErrMalformed
+	}
+	if err = v.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) validate(claims Claims) error {
+	now := v.now()
+	if exp, ok := claims.time("exp"); ok && !now.Before(exp.Add(v.leeway)) {
+		return ErrExpired
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.leeway).Before(nbf) {
+		return ErrNotBefore
+	}
+	if v.issuer != "" && claims.Issuer() != v.issuer {
+		return ErrIssuer
+	}
+	if v.audience != "" {
+		for _, aud := range claims.Audience() {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return ErrAudience
+	}
+	return nil
+}
+
+func verify(alg string, key interface{}, signed []byte, sig []byte) bool {
+	sum := sha256.Sum256(signed)
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	}
+	return false
+}
+
+// 签发token, key分别为[]byte, *rsa.PrivateKey, *ecdsa.PrivateKey. 主要用于测试及内部服务
+func Sign(alg string, kid string, key interface{}, claims Claims) (string, error) {
+	hbs, _ := json.Marshal(&header{Alg: alg, Kid: kid, Typ: "JWT"})
+	pbs, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := encoding.EncodeToString(hbs) + "." + encoding.EncodeToString(pbs)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch alg {
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return "", ErrNoKey
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case RS256:
+		pri, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return "", ErrNoKey
+		}
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, pri, crypto.SHA256, sum[:]); err != nil {
+			return "", err
+		}
+	case ES256:
+		pri, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return "", ErrNoKey
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, pri, sum[:])
+		if err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	default:
+		return "", ErrAlgorithm
+	}
+	return signed + "." + encoding.EncodeToString(sig), nil
+}
+
+func FromContext(ctx context.Context) Claims {
+	if ctx == nil {
+		return nil
+	}
+	claims, _ := ctx.Value(CONTEXT_KEY).(Claims)
+	return claims
+}
+
+func NewContext(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, CONTEXT_KEY, claims)
+}
@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	b64 := func(bs []byte) string { return base64.RawURLEncoding.EncodeToString(bs) }
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "r1", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "e1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+	}})
+	f, err := ioutil.TempFile("", "pbapi-jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(jwks)
+	f.Close()
+
+	v, err := NewVerifier(&Config{Secrets: []string{"s3cret"}, JwksFile: f.Name(), Issuer: "pbapi", Audience: "demo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	valid := Claims{"sub": "u1", "iss": "pbapi", "aud": []string{"demo"}, "exp": now + 60, "uid": 42}
+	cases := []struct {
+		alg    string
+		kid    string
+		key    interface{}
+		claims Claims
+		err    error
+	}{
+		{HS256, "", []byte("s3cret"), valid, nil},
+		{RS256, "r1", rsaKey, valid, nil},
+		{ES256, "e1", ecKey, valid, nil},
+		{HS256, "", []byte("wrong"), valid, ErrSignature},
+		{HS256, "", []byte("s3cret"), Claims{"iss": "pbapi", "aud": "demo", "exp": now - 1}, ErrExpired},
+		{HS256, "", []byte("s3cret"), Claims{"iss": "pbapi", "aud": "demo", "nbf": now + 60}, ErrNotBefore},
+		{HS256, "", []byte("s3cret"), Claims{"iss": "other", "aud": "demo"}, ErrIssuer},
+		{HS256, "", []byte("s3cret"), Claims{"iss": "pbapi", "aud": "other"}, ErrAudience},
+	}
+	for i, c := range cases {
+		token, err := Sign(c.alg, c.kid, c.key, c.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := v.Verify(token)
+		if err != c.err {
+			t.Errorf("case %v: expect %v, got %v", i, c.err, err)
+			continue
+		}
+		if err == nil {
+			if uid, _ := claims.Int64("uid"); claims.Subject() != "u1" || uid != 42 {
+				t.Errorf("case %v: unexpected claims %v", i, claims)
+			}
+		}
+	}
+	if _, err := v.Verify("a.b"); err != ErrMalformed {
+		t.Errorf("expect malformed, got %v", err)
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + ".e30."
+	if _, err := v.Verify(none); err != ErrAlgorithm {
+		t.Errorf("expect algorithm error, got %v", err)
+	}
+}
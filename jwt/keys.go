@@ -0,0 +1,189 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+)
+
+type key struct {
+	kid string
+	alg string
+	val interface{}
+}
+
+// 按kid与alg匹配的密钥集合
+type KeySet struct {
+	keys []*key
+}
+
+func (ks *KeySet) Len() int {
+	return len(ks.keys)
+}
+
+func (ks *KeySet) Add(kid string, alg string, val interface{}) {
+	ks.keys = append(ks.keys, &key{kid: kid, alg: alg, val: val})
+}
+
+// token带kid时优先精确匹配, 否则尝试同算法的全部密钥
+func (ks *KeySet) Find(kid string, alg string) (ret []interface{}) {
+	if kid != "" {
+		for _, k := range ks.keys {
+			if k.kid == kid && k.alg == alg {
+				return []interface{}{k.val}
+			}
+		}
+	}
+	for _, k := range ks.keys {
+		if k.alg == alg && (kid == "" || k.kid == "") {
+			ret = append(ret, k.val)
+		}
+	}
+	return
+}
+
+func algOf(pub interface{}) (string, error) {
+	switch v := pub.(type) {
+	case *rsa.PublicKey:
+		return RS256, nil
+	case *ecdsa.PublicKey:
+		if v.Curve != elliptic.P256() {
+			return "", errors.New("jwt unsupported curve: " + v.Curve.Params().Name)
+		}
+		return ES256, nil
+	}
+	return "", fmt.Errorf("jwt unsupported public key: %T", pub)
+}
+
+// 支持PUBLIC KEY, RSA PUBLIC KEY及CERTIFICATE
+func (ks *KeySet) LoadPem(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	n := ks.Len()
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		var pub interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				pub = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("jwt parse pem %v: %v", file, err)
+		}
+		alg, err := algOf(pub)
+		if err != nil {
+			return err
+		}
+		ks.Add("", alg, pub)
+	}
+	if ks.Len() == n {
+		return errors.New("jwt no public key in pem: " + file)
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBig(v string) (*big.Int, error) {
+	bs, err := decodeSegment(v)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(bs), nil
+}
+
+func (k *jwk) parse() (string, interface{}, error) {
+	switch k.Kty {
+	case "oct":
+		bs, err := decodeSegment(k.K)
+		return HS256, bs, err
+	case "RSA":
+		n, err := decodeBig(k.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := decodeBig(k.E)
+		if err != nil {
+			return "", nil, err
+		}
+		return RS256, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return "", nil, errors.New("unsupported curve " + k.Crv)
+		}
+		x, err := decodeBig(k.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := decodeBig(k.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return ES256, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return "", nil, errors.New("unsupported kty " + k.Kty)
+}
+
+// 加载本地JWKS文件, 忽略use非sig及不支持的密钥
+func (ks *KeySet) LoadJwks(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("jwt parse jwks %v: %v", file, err)
+	}
+	n := ks.Len()
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		alg, val, err := k.parse()
+		if err != nil {
+			return fmt.Errorf("jwt parse jwks %v, kid %v: %v", file, k.Kid, err)
+		}
+		if k.Alg != "" && k.Alg != alg {
+			continue
+		}
+		ks.Add(k.Kid, alg, val)
+	}
+	if ks.Len() == n {
+		return errors.New("jwt no usable key in jwks: " + file)
+	}
+	return nil
+}
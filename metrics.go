@@ -0,0 +1,29 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/obase/pbapi/metrics"
+	"time"
+)
+
+// 结点的指标统计句柄, websocket连接额外统计每条消息
+func newMetricsHandlerFunc(m *metrics.Metrics, node *FlatNode) gin.HandlerFunc {
+	labels := &metrics.Labels{
+		Package: node.PackageName,
+		Service: node.ServiceName,
+		Method:  node.MethodName,
+		Path:    node.Path,
+	}
+	stat := m.HandlerFunc(labels)
+	messages := m.WbskMessage(labels)
+	observer := func(c *gin.Context, rdata []byte, wdata []byte, err error, start time.Time) {
+		messages.Inc()
+	}
+	return func(ctx *gin.Context) {
+		if websocket.IsWebSocketUpgrade(ctx.Request) {
+			AddWbskObserver(ctx, observer)
+		}
+		stat(ctx)
+	}
+}
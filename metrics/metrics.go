@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/obase/pbapi/cache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Path      string    `json:"path" bson:"path" yaml:"path"`                // 暴露路径, 为空不启用, 一般为/metrics
+	Namespace string    `json:"namespace" bson:"namespace" yaml:"namespace"` // 指标前缀, 默认pbapi
+	Buckets   []float64 `json:"buckets" bson:"buckets" yaml:"buckets"`       // 延迟直方图分桶(秒), 默认prometheus.DefBuckets
+}
+
+func mergeConfig(c *Config) *Config {
+	ret := *c
+	if ret.Namespace == "" {
+		ret.Namespace = "pbapi"
+	}
+	if len(ret.Buckets) == 0 {
+		ret.Buckets = prometheus.DefBuckets
+	}
+	return &ret
+}
+
+// 结点元数据, 取自FlatNode
+type Labels struct {
+	Package string
+	Service string
+	Method  string
+	Path    string
+}
+
+/*
+指标汇总, 每个Server独立Registry, 避免重复注册冲突:
+- http_requests_total/http_request_duration_seconds: package, service, method, path, status
+- websocket_connections/websocket_messages_total: package, service, method, path
+- grpc_requests_total/grpc_request_duration_seconds: package, service, method, code
+- cache_hits_total/cache_misses_total/cache_stores_total/cache_stales_total/cache_coalesced_total/cache_purges_total
+- panics_total: kind(http|wbsk|grpc), method
+*/
+type Metrics struct {
+	Config       *Config
+	Registry     *prometheus.Registry
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	wbskConns    *prometheus.GaugeVec
+	wbskMessages *prometheus.CounterVec
+	grpcRequests *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+	panics       *prometheus.CounterVec
+}
+
+var (
+	nodeLabels  = []string{"package", "service", "method", "path"}
+	httpLabels  = []string{"package", "service", "method", "path", "status"}
+	grpcLabels  = []string{"package", "service", "method", "code"}
+	panicLabels = []string{"kind", "method"}
+)
+
+func New(c *Config) *Metrics {
+	if c == nil || c.Path == "" {
+		return nil
+	}
+	c = mergeConfig(c)
+	m := &Metrics{
+		Config:   c,
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "http_requests_total",
+			Help:      "Total number of http requests.",
+		}, httpLabels),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Http request latency in seconds.",
+			Buckets:   c.Buckets,
+		}, httpLabels),
+		wbskConns: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: c.Namespace,
+			Name:      "websocket_connections",
+			Help:      "Number of active websocket connections.",
+		}, nodeLabels),
+		wbskMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "websocket_messages_total",
+			Help:      "Total number of websocket messages received.",
+		}, nodeLabels),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "grpc_requests_total",
+			Help:      "Total number of grpc requests.",
+		}, grpcLabels),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: c.Namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Grpc request latency in seconds.",
+			Buckets:   c.Buckets,
+		}, grpcLabels),
+		panics: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "panics_total",
+			Help:      "Total number of recovered panics.",
+		}, panicLabels),
+	}
+	m.Registry.MustRegister(
+		m.httpRequests,
+		m.httpDuration,
+		m.wbskConns,
+		m.wbskMessages,
+		m.grpcRequests,
+		m.grpcDuration,
+		m.panics,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// 暴露指标的gin句柄
+func (m *Metrics) Handler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+	return gin.WrapH(h)
+}
+
+// 采集cache统计, 在抓取时读取
+func (m *Metrics) RegisterCache(c cache.Cache) {
+	if c == nil {
+		return
+	}
+	counter := func(name string, help string, fn func(*cache.Stats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: m.Config.Namespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(fn(c.Stats()))
+		})
+	}
+	gauge := func(name string, help string, fn func(*cache.Stats) uint64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: m.Config.Namespace,
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(fn(c.Stats()))
+		})
+	}
+	m.Registry.MustRegister(
+		counter("cache_hits_total", "Total number of cache hits.", func(s *cache.Stats) uint64 { return s.Hits }),
+		counter("cache_misses_total", "Total number of cache misses.", func(s *cache.Stats) uint64 { return s.Misses }),
+		counter("cache_stores_total", "Total number of cache stores.", func(s *cache.Stats) uint64 { return s.Stores }),
+		counter("cache_evictions_total", "Total number of cache entries evicted by capacity.", func(s *cache.Stats) uint64 { return s.Evictions }),
+		counter("cache_expires_total", "Total number of expired cache entries removed.", func(s *cache.Stats) uint64 { return s.Expires }),
+		counter("cache_stales_total", "Total number of stale cache entries served.", func(s *cache.Stats) uint64 { return s.Stales }),
+		counter("cache_coalesced_total", "Total number of requests coalesced into a concurrent miss.", func(s *cache.Stats) uint64 { return s.Coalesced }),
+		counter("cache_purges_total", "Total number of cache entries purged.", func(s *cache.Stats) uint64 { return s.Purges }),
+		gauge("cache_entries", "Current number of memory cache entries.", func(s *cache.Stats) uint64 { return s.Entries }),
+		gauge("cache_bytes", "Current bytes of memory cache entries.", func(s *cache.Stats) uint64 { return s.Bytes }),
+	)
+}
+
+/*
+http结点的统计句柄. websocket升级请求只统计连接数, 消息数通过WbskMessage统计
+*/
+func (m *Metrics) HandlerFunc(l *Labels) gin.HandlerFunc {
+	lvs := []string{l.Package, l.Service, l.Method, l.Path}
+	conns := m.wbskConns.WithLabelValues(lvs...)
+	return func(ctx *gin.Context) {
+		if websocket.IsWebSocketUpgrade(ctx.Request) {
+			conns.Inc()
+			defer conns.Dec()
+			ctx.Next()
+			return
+		}
+		start := time.Now()
+		ctx.Next()
+		hlvs := append(lvs[:len(lvs):len(lvs)], strconv.Itoa(ctx.Writer.Status()))
+		m.httpRequests.WithLabelValues(hlvs...).Inc()
+		m.httpDuration.WithLabelValues(hlvs...).Observe(time.Since(start).Seconds())
+	}
+}
+
+func (m *Metrics) WbskMessage(l *Labels) prometheus.Counter {
+	return m.wbskMessages.WithLabelValues(l.Package, l.Service, l.Method, l.Path)
+}
+
+// 统计已恢复的panic, method为http路由, websocket标签或grpc FullMethod
+func (m *Metrics) Panic(kind string, method string) {
+	m.panics.WithLabelValues(kind, method).Inc()
+}
+
+func (m *Metrics) observeGrpc(fullMethod string, start time.Time, err error) {
+	pkg, svc, mth := SplitFullMethod(fullMethod)
+	lvs := []string{pkg, svc, mth, status.Code(err).String()}
+	m.grpcRequests.WithLabelValues(lvs...).Inc()
+	m.grpcDuration.WithLabelValues(lvs...).Observe(time.Since(start).Seconds())
+}
+
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		rsp, err := handler(ctx, req)
+		m.observeGrpc(info.FullMethod, start, err)
+		return rsp, err
+	}
+}
+
+func (m *Metrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		m.observeGrpc(info.FullMethod, start, err)
+		return err
+	}
+}
+
+// 拆分grpc的FullMethod: "/package.Service/Method"
+func SplitFullMethod(fullMethod string) (pkg string, svc string, mth string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if p := strings.LastIndexByte(fullMethod, '/'); p != -1 {
+		svc, mth = fullMethod[:p], fullMethod[p+1:]
+	} else {
+		mth = fullMethod
+	}
+	if p := strings.LastIndexByte(svc, '.'); p != -1 {
+		pkg, svc = svc[:p], svc[p+1:]
+	}
+	return
+}
@@ -0,0 +1,55 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/cache"
+	"github.com/obase/pbapi/metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	s := NewServer()
+	s.GET("/a", func(ctx *gin.Context) { ctx.String(http.StatusOK, "a") })
+
+	config := mergeConfig(&Config{
+		Metrics:      &metrics.Config{Path: "/metrics"},
+		AdminPlugins: [][]string{{"hostsallow", "10.0.0.1"}},
+		RouterConfig: []*RouterConfig{{Path: "/a", Cache: 60}},
+	})
+	m := metrics.New(config.Metrics)
+	c := cache.New(&cache.Config{Type: cache.MEMORY})
+	defer c.Close()
+	m.RegisterCache(c)
+	engine, err := s.compileHttpEngine(config, &httpRuntime{cache: c, metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a", nil))
+	}
+
+	// adminPlugins限制访问来源
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expect forbidden, got %v", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, r)
+	body := w.Body.String()
+	for _, expect := range []string{
+		`pbapi_http_requests_total{method="",package="",path="/a",service="",status="200"} 2`,
+		`pbapi_cache_hits_total 1`,
+		`pbapi_cache_misses_total 1`,
+		`pbapi_cache_stores_total 1`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("missing %v", expect)
+		}
+	}
+}
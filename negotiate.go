@@ -0,0 +1,119 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/obase/log"
+	"github.com/obase/pbapi/cache"
+	"github.com/obase/pbapi/codec"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 服务接口http的内容协商(negotiation开启时), def为方法配置的json类codec, decodes表示适配器按codec解码:
+// 1. Content-Type选择请求解码: 为空或application/json使用def, 不支持返回415. 适配器自行解码json时只接受def
+// 2. Accept选择结果编码: 为空同请求, 按q值优先, */*及application/*同请求, 均不支持返回406
+func newNegotiateHandlerFunc(tag string, def codec.Codec, decodes bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req := def
+		if ct := c.GetHeader("Content-Type"); ct != "" {
+			if req = codec.ForMediaType(ct, def); req == nil || (req != def && !decodes) {
+				writeNegotiateError(c, http.StatusUnsupportedMediaType, tag, "unsupported content type: "+ct)
+				return
+			}
+		}
+		rsp := negotiateAccept(c.GetHeader("Accept"), req, def)
+		if rsp == nil {
+			writeNegotiateError(c, http.StatusNotAcceptable, tag, "not acceptable: "+c.GetHeader("Accept"))
+			return
+		}
+		c.Set(codec.CONTEXT_KEY, req)
+		c.Set(codec.RESPONSE_KEY, rsp)
+		c.Writer.Header().Add("Vary", "Accept")
+		// 缓存按结果编码区分
+		if rsp != def {
+			c.Set(cache.VARY_KEY, rsp.Name())
+		}
+	}
+}
+
+func negotiateAccept(accept string, req codec.Codec, def codec.Codec) codec.Codec {
+	if strings.TrimSpace(accept) == "" {
+		return req
+	}
+	var (
+		ret   codec.Codec
+		bestq float64
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		// 同q值取先出现者, q=0表示不接受
+		if q <= bestq {
+			continue
+		}
+		var cd codec.Codec
+		if mt == "*/*" || mt == "application/*" {
+			cd = req
+		} else {
+			cd = codec.ForMediaType(mt, def)
+		}
+		if cd != nil {
+			ret, bestq = cd, q
+		}
+	}
+	return ret
+}
+
+func writeNegotiateError(c *gin.Context, code int, tag string, msg string) {
+	c.AbortWithStatusJSON(code, &Response{
+		Code: PARSING_REQUEST_ERROR,
+		Msg:  msg,
+		Tag:  tag,
+	})
+}
+
+/*
+二进制结果不经ResponseEncoder: 成功直接返回data, 失败按ErrorStatus映射状态码.
+失败结果protobuf为google.rpc.Status(code/message同Response), msgpack为Response
+*/
+func writeBinary(c *gin.Context, rc codec.Codec, tag string, data interface{}, err error) {
+	code := http.StatusOK
+	if err != nil {
+		code = ErrorStatus(err)
+		data = binaryError(rc, ErrorResponse(tag, err))
+	}
+	wdata, merr := rc.Marshal(data)
+	if merr != nil {
+		log.Errorf("%s encoding response: %v", tag, merr)
+		code = http.StatusInternalServerError
+		wdata, _ = rc.Marshal(binaryError(rc, &Response{
+			Code: INTERNAL_ERROR,
+			Msg:  merr.Error(),
+			Tag:  tag,
+		}))
+	}
+	c.Writer.Header().Set("Content-Type", rc.ContentType())
+	c.Writer.WriteHeader(code)
+	c.Writer.Write(wdata)
+}
+
+func binaryError(rc codec.Codec, ersp *Response) interface{} {
+	if rc == codec.Protobuf {
+		return &spb.Status{
+			Code:    int32(ersp.Code),
+			Message: ersp.Msg,
+		}
+	}
+	return ersp
+}
@@ -0,0 +1,135 @@
+package pbapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/grpc_health_v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiation(t *testing.T) {
+	s := newDemoServer("HealthService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Check": func(ctx context.Context, data []byte) (interface{}, error) {
+			var req *grpc_health_v1.HealthCheckRequest
+			if err := codec.Unmarshal(ctx, data, &req); err != nil {
+				return nil, err
+			}
+			if req.Service != "demo" {
+				return nil, errors.New("unknown service: " + req.Service)
+			}
+			return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+		},
+	})
+	s.serviceHandlers[0].Codecs = map[string]bool{"Check": true}
+	engine := compileDemoEngine(t, s, &Config{Negotiation: true}, &httpRuntime{})
+	pbreq := func(service string) string {
+		bs, _ := proto.Marshal(&grpc_health_v1.HealthCheckRequest{Service: service})
+		return string(bs)
+	}
+	mpreq, _ := codec.Msgpack.Marshal(map[string]string{"service": "demo"})
+	cases := []struct {
+		contentType string
+		accept      string
+		body        string
+		status      int
+		rspType     string
+	}{
+		{"", "", `{"service":"demo"}`, http.StatusOK, "application/json"},
+		{"application/json", "application/x-protobuf;q=0.5, application/json", `{"service":"demo"}`, http.StatusOK, "application/json"},
+		{"application/x-protobuf", "", pbreq("demo"), http.StatusOK, "application/x-protobuf"},
+		{"application/x-protobuf", "*/*", pbreq("none"), http.StatusInternalServerError, "application/x-protobuf"},
+		{"application/json", "application/x-protobuf", `{"service":"demo"}`, http.StatusOK, "application/x-protobuf"},
+		{"application/x-msgpack", "application/x-msgpack", string(mpreq), http.StatusOK, "application/x-msgpack"},
+		{"text/xml", "", "<service/>", http.StatusUnsupportedMediaType, "application/json"},
+		{"application/json", "text/html", `{"service":"demo"}`, http.StatusNotAcceptable, "application/json"},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest(http.MethodPost, "/demo/health/check", strings.NewReader(c.body))
+		if c.contentType != "" {
+			r.Header.Set("Content-Type", c.contentType)
+		}
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		if w.Code != c.status || !strings.HasPrefix(w.Header().Get("Content-Type"), c.rspType) {
+			t.Errorf("case %v: expect %v %v, got %v %v %s", i, c.status, c.rspType, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			continue
+		}
+		switch {
+		case c.rspType == "application/x-protobuf" && c.status == http.StatusOK:
+			var rsp grpc_health_v1.HealthCheckResponse
+			if err := proto.Unmarshal(w.Body.Bytes(), &rsp); err != nil || rsp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+				t.Errorf("case %v: protobuf response %v %v", i, rsp.Status, err)
+			}
+		case c.rspType == "application/x-protobuf":
+			var st spb.Status
+			if err := proto.Unmarshal(w.Body.Bytes(), &st); err != nil || st.Code != EXECUTE_SERVICE_ERROR {
+				t.Errorf("case %v: protobuf error %v %v", i, st.Code, err)
+			}
+		case c.rspType == "application/x-msgpack":
+			var rsp map[string]interface{}
+			if err := codec.Msgpack.Unmarshal(w.Body.Bytes(), &rsp); err != nil || rsp["status"] == nil {
+				t.Errorf("case %v: msgpack response %v %v", i, rsp, err)
+			}
+		}
+	}
+}
+
+type demoHealth struct{}
+
+func (demoHealth) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if req.Service != "demo" {
+		return nil, errors.New("unknown service: " + req.Service)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func TestNegotiationTyped(t *testing.T) {
+	// 自行解码json的适配器只接受json请求
+	s := newDemoServer("EchoService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Echo": func(ctx context.Context, data []byte) (interface{}, error) {
+			var req map[string]string
+			if err := json.Unmarshal(data, &req); err != nil {
+				return nil, err
+			}
+			return req, nil
+		},
+	})
+	// 生成的适配器用json.Unmarshal, 注册后由框架按请求类型解码
+	s.RegisterService(grpc_health_v1.RegisterHealthServerHandler, demoHealth{})
+	engine := compileDemoEngine(t, s, &Config{Negotiation: true}, &httpRuntime{})
+	post := func(path string, contentType string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		return w
+	}
+
+	pbreq, _ := proto.Marshal(&grpc_health_v1.HealthCheckRequest{Service: "demo"})
+	if w := post("/v1/health/check", "application/x-protobuf", string(pbreq)); w.Code != http.StatusOK {
+		t.Fatalf("protobuf request: %v %s", w.Code, w.Body.String())
+	}
+	mpreq, _ := codec.Msgpack.Marshal(map[string]string{"service": "demo"})
+	if w := post("/v1/health/check", "application/x-msgpack", string(mpreq)); w.Code != http.StatusOK {
+		t.Fatalf("msgpack request: %v %s", w.Code, w.Body.String())
+	}
+	if w := post("/v1/health/check", "application/json", `{"service":`); !strings.Contains(w.Body.String(), `"code":602`) {
+		t.Fatalf("expect parsing error: %v %s", w.Code, w.Body.String())
+	}
+	if w := post("/demo/echo/echo", "application/x-protobuf", string(pbreq)); w.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expect unsupported media type: %v %s", w.Code, w.Body.String())
+	}
+	if w := post("/demo/echo/echo", "application/json", `{"a":"b"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"a":"b"`) {
+		t.Fatalf("json request: %v %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,240 @@
+package pbapi
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/*
+插件表达式, 两种写法等价:
+1. 调用形式: "name(arg1, arg2, ...)", 参数可用单双引号包含逗号或括号
+2. 列表形式: [name, arg1, arg2, ...]. 兼容旧写法"name,arg1,arg2"
+
+参数替换规则:
+- $name或${name}: 优先取Config.Arguments[name], 其次取环境变量name, 都没有则报错
+- ${name:default}: 同上, 但都没有时取default
+- $$: 字面量$
+*/
+func ParsePluginExpr(loc string, raw []string, args map[string]string) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var items []string
+	if len(raw) == 1 {
+		expr := strings.TrimSpace(raw[0])
+		if strings.IndexByte(expr, '(') != -1 {
+			var err error
+			if items, err = splitPluginCall(expr); err != nil {
+				return nil, errors.New(fmt.Sprintf("%s: %v in %q", loc, err, raw[0]))
+			}
+		} else {
+			items = trimAll(strings.Split(expr, ","))
+		}
+	} else {
+		items = trimAll(raw)
+	}
+
+	ret := make([]string, len(items))
+	for i, item := range items {
+		if i == 0 {
+			if item == "" {
+				return nil, errors.New(fmt.Sprintf("%s: empty plugin name in %q", loc, strings.Join(raw, ",")))
+			}
+			ret[i] = item
+			continue
+		}
+		val, err := expandPluginArg(item, args)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %v in %q", loc, err, strings.Join(raw, ",")))
+		}
+		ret[i] = val
+	}
+	return ret, nil
+}
+
+func trimAll(vs []string) []string {
+	ret := make([]string, len(vs))
+	for i, v := range vs {
+		ret[i] = strings.TrimSpace(v)
+	}
+	return ret
+}
+
+func ParsePluginExprs(loc string, raws [][]string, args map[string]string) ([][]string, error) {
+	if len(raws) == 0 {
+		return raws, nil
+	}
+	ret := make([][]string, 0, len(raws))
+	for i, raw := range raws {
+		v, err := ParsePluginExpr(fmt.Sprintf("%s[%d]", loc, i), raw, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(v) > 0 {
+			ret = append(ret, v)
+		}
+	}
+	return ret, nil
+}
+
+// 解析name(arg1,arg2)形式, 返回[name, arg1, arg2]. 参数中的引号会被剥除
+func splitPluginCall(expr string) ([]string, error) {
+	lp := strings.IndexByte(expr, '(')
+	name := strings.TrimSpace(expr[:lp])
+	if name == "" {
+		return nil, errors.New("empty plugin name")
+	}
+	if strings.ContainsAny(name, " \t\"',)") {
+		return nil, errors.New(fmt.Sprintf("invalid plugin name %q", name))
+	}
+	ret := []string{name}
+
+	var (
+		buf    strings.Builder
+		quote  rune
+		closed bool
+		quoted bool // 当前参数是否含引号, 用于区分空参数与""
+		keep   int  // 最后一个引号结束时的长度, 引号内的空白不被裁剪
+	)
+	next := func() string {
+		arg := buf.String()
+		arg = arg[:keep] + strings.TrimRight(arg[keep:], " \t")
+		buf.Reset()
+		quoted, keep = false, 0
+		return arg
+	}
+	body := expr[lp+1:]
+	for i, c := range body {
+		if closed {
+			if c != ' ' && c != '\t' {
+				return nil, errors.New(fmt.Sprintf("unexpected %q after ')' at offset %d", c, lp+1+i))
+			}
+			continue
+		}
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote, keep = 0, buf.Len()
+			} else {
+				buf.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote, quoted = c, true
+		case c == ',':
+			ret = append(ret, next())
+		case c == ')':
+			empty := len(ret) == 1 && !quoted
+			if arg := next(); !empty || arg != "" {
+				ret = append(ret, arg)
+			}
+			closed = true
+		case c == '(':
+			return nil, errors.New(fmt.Sprintf("unexpected '(' at offset %d", lp+1+i))
+		case (c == ' ' || c == '\t') && buf.Len() == 0:
+			// 忽略参数前导空白
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if !closed {
+		return nil, errors.New("missing ')'")
+	}
+	return ret, nil
+}
+
+func expandPluginArg(arg string, args map[string]string) (string, error) {
+	if strings.IndexByte(arg, '$') == -1 {
+		return arg, nil
+	}
+	var buf strings.Builder
+	for i := 0; i < len(arg); {
+		c := arg[i]
+		if c != '$' {
+			buf.WriteByte(c)
+			i++
+			continue
+		}
+		i++
+		if i >= len(arg) {
+			return "", errors.New("dangling '$'")
+		}
+		var (
+			name   string
+			def    string
+			hasDef bool
+		)
+		switch {
+		case arg[i] == '$':
+			buf.WriteByte('$')
+			i++
+			continue
+		case arg[i] == '{':
+			end := strings.IndexByte(arg[i:], '}')
+			if end == -1 {
+				return "", errors.New("missing '}'")
+			}
+			name = arg[i+1 : i+end]
+			if p := strings.IndexByte(name, ':'); p != -1 {
+				name, def, hasDef = name[:p], name[p+1:], true
+			}
+			i += end + 1
+		default:
+			j := i
+			for j < len(arg) && isArgumentChar(arg[j]) {
+				j++
+			}
+			name = arg[i:j]
+			i = j
+		}
+		if name == "" {
+			return "", errors.New("empty argument name")
+		}
+		if v, ok := args[name]; ok {
+			buf.WriteString(v)
+		} else if v, ok := os.LookupEnv(name); ok {
+			buf.WriteString(v)
+		} else if hasDef {
+			buf.WriteString(def)
+		} else {
+			return "", errors.New(fmt.Sprintf("undefined argument $%s", name))
+		}
+	}
+	return buf.String(), nil
+}
+
+func isArgumentChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// 统一解析Config中全部插件表达式, 在ServeWith时执行, 确保代码传入的Config与conf.yml一致处理
+func resolveConfigPlugins(config *Config) (err error) {
+	args := config.Arguments
+	if config.RouterPlugins, err = ParsePluginExprs(CKEY+".routerPlugins", config.RouterPlugins, args); err != nil {
+		return
+	}
+	if config.ServerPlugins, err = ParsePluginExprs(CKEY+".serverPlugins", config.ServerPlugins, args); err != nil {
+		return
+	}
+	if config.AdminPlugins, err = ParsePluginExprs(CKEY+".adminPlugins", config.AdminPlugins, args); err != nil {
+		return
+	}
+	for i, rc := range config.RouterConfig {
+		if rc.Plugins, err = ParsePluginExprs(fmt.Sprintf("%s.routerConfig[%d].plugins", CKEY, i), rc.Plugins, args); err != nil {
+			return
+		}
+	}
+	for i, sc := range config.ServerConfig {
+		if sc.HttpPlugins, err = ParsePluginExprs(fmt.Sprintf("%s.serverConfig[%d].httpPlugins", CKEY, i), sc.HttpPlugins, args); err != nil {
+			return
+		}
+		if sc.WbskPlugins, err = ParsePluginExprs(fmt.Sprintf("%s.serverConfig[%d].wbskPlugins", CKEY, i), sc.WbskPlugins, args); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package pbapi
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParsePluginExpr(t *testing.T) {
+	os.Setenv("PBAPI_TEST_ENV", "env")
+	args := map[string]string{"demo": "xxxe", "VerifyToken": "xxefef"}
+	cases := []struct {
+		raw    []string
+		expect []string
+	}{
+		{[]string{"demo($demo)"}, []string{"demo", "xxxe"}},
+		{[]string{"VerifyToken($demo, ${VerifyToken})"}, []string{"VerifyToken", "xxxe", "xxefef"}},
+		{[]string{"demo()"}, []string{"demo"}},
+		{[]string{"demo('a, b', \" c \")"}, []string{"demo", "a, b", " c "}},
+		{[]string{"demo(${none:def}, $PBAPI_TEST_ENV, $$1)"}, []string{"demo", "def", "env", "$1"}},
+		{[]string{"hostsallow", "127.0.0.1", "$demo"}, []string{"hostsallow", "127.0.0.1", "xxxe"}},
+		{[]string{"hostsallow,127.0.0.1"}, []string{"hostsallow", "127.0.0.1"}},
+	}
+	for _, c := range cases {
+		ret, err := ParsePluginExpr("test", c.raw, args)
+		if err != nil {
+			t.Errorf("parse %q: %v", c.raw, err)
+		} else if !reflect.DeepEqual(ret, c.expect) {
+			t.Errorf("parse %q: expect %q, got %q", c.raw, c.expect, ret)
+		}
+	}
+
+	for _, raw := range []string{"demo($demo", "demo($none)", "(a)", "demo(a) b", "demo('a)", "demo(${demo)"} {
+		if _, err := ParsePluginExpr("service.routerPlugins[0]", []string{raw}, args); err == nil {
+			t.Errorf("parse %q: expect error", raw)
+		} else {
+			t.Log(err)
+		}
+	}
+}
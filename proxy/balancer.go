@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"errors"
+	"github.com/obase/center"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNoInstance = errors.New("no available proxy instance")
+
+// 选择代理实例, Release在请求结束后调用(用于leastconn计数)
+type Balancer interface {
+	Select() (string, error)
+	Release(addr string)
+}
+
+type instances func() ([]string, error)
+
+func staticInstances(targets []string) instances {
+	return func() ([]string, error) {
+		return targets, nil
+	}
+}
+
+// center.FetchService自带缓存, 每次调用即可感知实例变化
+func centerInstances(name string) instances {
+	return func() ([]string, error) {
+		services, _, err := center.FetchService(name)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]string, 0, len(services))
+		for _, s := range services {
+			if s != nil && s.Addr != "" {
+				ret = append(ret, s.Addr)
+			}
+		}
+		return ret, nil
+	}
+}
+
+func newBalancer(policy string, fetch instances) (Balancer, error) {
+	switch policy {
+	case "", PolicyRobin:
+		return &robinBalancer{fetch: fetch}, nil
+	case PolicyRandom:
+		return &randomBalancer{fetch: fetch}, nil
+	case PolicyLeastConn:
+		return &leastConnBalancer{fetch: fetch, conns: make(map[string]*int64)}, nil
+	}
+	return nil, errors.New("invalid proxy policy: " + policy)
+}
+
+type robinBalancer struct {
+	fetch instances
+	next  uint32
+}
+
+func (b *robinBalancer) Select() (string, error) {
+	addrs, err := b.fetch()
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", ErrNoInstance
+	}
+	idx := atomic.AddUint32(&b.next, 1)
+	return addrs[idx%uint32(len(addrs))], nil
+}
+
+func (b *robinBalancer) Release(addr string) {
+}
+
+type randomBalancer struct {
+	fetch instances
+}
+
+func (b *randomBalancer) Select() (string, error) {
+	addrs, err := b.fetch()
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", ErrNoInstance
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+func (b *randomBalancer) Release(addr string) {
+}
+
+type leastConnBalancer struct {
+	sync.RWMutex
+	fetch instances
+	conns map[string]*int64
+}
+
+func (b *leastConnBalancer) counter(addr string) *int64 {
+	b.RLock()
+	cnt, ok := b.conns[addr]
+	b.RUnlock()
+	if !ok {
+		b.Lock()
+		if cnt, ok = b.conns[addr]; !ok {
+			cnt = new(int64)
+			b.conns[addr] = cnt
+		}
+		b.Unlock()
+	}
+	return cnt
+}
+
+func (b *leastConnBalancer) Select() (string, error) {
+	addrs, err := b.fetch()
+	if err != nil {
+		return "", err
+	}
+	if len(addrs) == 0 {
+		return "", ErrNoInstance
+	}
+	// 从随机位置开始扫描, 避免连接数相同时总是命中首个实例
+	var (
+		best  string
+		least int64 = -1
+		start       = rand.Intn(len(addrs))
+	)
+	for i := range addrs {
+		addr := addrs[(start+i)%len(addrs)]
+		if n := atomic.LoadInt64(b.counter(addr)); least < 0 || n < least {
+			best, least = addr, n
+		}
+	}
+	atomic.AddInt64(b.counter(best), 1)
+	return best, nil
+}
+
+func (b *leastConnBalancer) Release(addr string) {
+	atomic.AddInt64(b.counter(addr), -1)
+}
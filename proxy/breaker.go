@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "halfopen"
+)
+
+/*
+熔断规则, 满足任一条件即打开:
+1. 统计窗口内请求数不少于MinRequests且错误率达到ErrorRatio
+2. 连续失败次数达到ConsecutiveFailures
+打开OpenTimeout后进入半开, 放行HalfOpenRequests个探测请求: 全部成功则关闭, 任一失败则重新打开
+*/
+type BreakerConfig struct {
+	ErrorRatio          float64       `json:"errorRatio" bson:"errorRatio" yaml:"errorRatio"`                            // 错误率阈值(0~1), 0表示不按错误率
+	MinRequests         int           `json:"minRequests" bson:"minRequests" yaml:"minRequests"`                         // 错误率生效的最小请求数, 默认20
+	Window              time.Duration `json:"window" bson:"window" yaml:"window"`                                        // 错误率统计窗口, 默认10秒
+	ConsecutiveFailures int           `json:"consecutiveFailures" bson:"consecutiveFailures" yaml:"consecutiveFailures"` // 连续失败阈值, 0表示不按连续失败
+	OpenTimeout         time.Duration `json:"openTimeout" bson:"openTimeout" yaml:"openTimeout"`                         // 打开到半开的等待时间, 默认30秒
+	HalfOpenRequests    int           `json:"halfOpenRequests" bson:"halfOpenRequests" yaml:"halfOpenRequests"`          // 半开探测请求数, 默认1
+}
+
+func mergeBreakerConfig(c *BreakerConfig) *BreakerConfig {
+	ret := *c
+	if ret.MinRequests == 0 {
+		ret.MinRequests = 20
+	}
+	if ret.Window == 0 {
+		ret.Window = 10 * time.Second
+	}
+	if ret.OpenTimeout == 0 {
+		ret.OpenTimeout = 30 * time.Second
+	}
+	if ret.HalfOpenRequests == 0 {
+		ret.HalfOpenRequests = 1
+	}
+	return &ret
+}
+
+// 熔断器状态快照, 用于admin接口
+type BreakerState struct {
+	Name                string    `json:"name"`
+	State               string    `json:"state"`
+	Requests            int       `json:"requests"`            // 当前窗口请求数
+	Failures            int       `json:"failures"`            // 当前窗口失败数
+	ConsecutiveFailures int       `json:"consecutiveFailures"` // 连续失败数
+	Changed             time.Time `json:"changed"`             // 最近状态变更时间
+}
+
+type Breaker struct {
+	sync.Mutex
+	config      *BreakerConfig
+	name        string
+	state       string
+	changed     time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+	probes      int // 半开状态已放行的探测数
+	successes   int // 半开状态已成功的探测数
+}
+
+func NewBreaker(name string, c *BreakerConfig) *Breaker {
+	now := time.Now()
+	return &Breaker{
+		config:      mergeBreakerConfig(c),
+		name:        name,
+		state:       StateClosed,
+		changed:     now,
+		windowStart: now,
+	}
+}
+
+func (b *Breaker) setState(state string, now time.Time) {
+	b.state = state
+	b.changed = now
+	b.windowStart = now
+	b.requests, b.failures, b.consecutive = 0, 0, 0
+	b.probes, b.successes = 0, 0
+}
+
+// 是否放行请求. 放行后必须调用Done报告结果
+func (b *Breaker) Allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	switch b.state {
+	case StateOpen:
+		if now.Sub(b.changed) < b.config.OpenTimeout {
+			return false
+		}
+		b.setState(StateHalfOpen, now)
+		fallthrough
+	case StateHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *Breaker) Done(success bool) {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	switch b.state {
+	case StateHalfOpen:
+		if !success {
+			b.setState(StateOpen, now)
+		} else if b.successes++; b.successes >= b.config.HalfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		if now.Sub(b.windowStart) >= b.config.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+		b.requests++
+		if success {
+			b.consecutive = 0
+			return
+		}
+		b.failures++
+		b.consecutive++
+		if (b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures) ||
+			(b.config.ErrorRatio > 0 && b.requests >= b.config.MinRequests && float64(b.failures) >= b.config.ErrorRatio*float64(b.requests)) {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+func (b *Breaker) State() *BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return &BreakerState{
+		Name:                b.name,
+		State:               b.state,
+		Requests:            b.requests,
+		Failures:            b.failures,
+		ConsecutiveFailures: b.consecutive,
+		Changed:             b.changed,
+	}
+}
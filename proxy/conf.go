@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	BufferPoolNone = "none" // 没有缓存池
+	BufferPoolSync = "sync" // 采用sync.Pool
+
+	ErrorHandlerNone = "none" // 没有错误处理, 由httputil.ReverseProxy默认返回502
+	ErrorHandlerBody = "body" // 将错误写到body
+
+	PolicyRobin     = "robin"     // 轮询
+	PolicyRandom    = "random"    // 随机
+	PolicyLeastConn = "leastconn" // 最少连接
+)
+
+// 对应conf.yml的httpx部分
+type Config struct {
+	ConnectTimeout         time.Duration `json:"connectTimeout" bson:"connectTimeout" yaml:"connectTimeout"`                         // 连接超时, 默认30秒
+	KeepAlive              time.Duration `json:"keepAlive" bson:"keepAlive" yaml:"keepAlive"`                                        // 连接keepalive, 默认30秒
+	MaxIdleConns           int           `json:"maxIdleConns" bson:"maxIdleConns" yaml:"maxIdleConns"`                               // 最大空闲,默认10240
+	MaxIdleConnsPerHost    int           `json:"maxIdleConnsPerHost" bson:"maxIdleConnsPerHost" yaml:"maxIdleConnsPerHost"`          // 每个主机最大空闲连接数
+	MaxConnsPerHost        int           `json:"maxConnsPerHost" bson:"maxConnsPerHost" yaml:"maxConnsPerHost"`                      // 每机最大连接数
+	IdleConnTimeout        time.Duration `json:"idleConnTimeout" bson:"idleConnTimeout" yaml:"idleConnTimeout"`                      // 空闲超时, 默认90秒
+	TLSHandshakeTimeout    time.Duration `json:"tlsHandshakeTimeout" bson:"tlsHandshakeTimeout" yaml:"tlsHandshakeTimeout"`          // TLS握手超时, 默认10秒
+	DisableCompression     bool          `json:"disableCompression" bson:"disableCompression" yaml:"disableCompression"`             // 是否禁用压缩
+	ResponseHeaderTimeout  time.Duration `json:"responseHeaderTimeout" bson:"responseHeaderTimeout" yaml:"responseHeaderTimeout"`    // 响应头超时, 默认5秒
+	ExpectContinueTimeout  time.Duration `json:"expectContinueTimeout" bson:"expectContinueTimeout" yaml:"expectContinueTimeout"`    // 期望再超时, 默认1秒
+	MaxResponseHeaderBytes int64         `json:"maxResponseHeaderBytes" bson:"maxResponseHeaderBytes" yaml:"maxResponseHeaderBytes"` // 最大响应头字节数
+	RequestTimeout         time.Duration `json:"requestTimeout" bson:"requestTimeout" yaml:"requestTimeout"`                         // 代理请求超时, 默认60秒
+	ProxyFlushInterval     time.Duration `json:"proxyFlushInterval" bson:"proxyFlushInterval" yaml:"proxyFlushInterval"`             // 反向代理刷新间隔, 0表示默认, 负表示立即刷新
+	ProxyBufferPool        string        `json:"proxyBufferPool" bson:"proxyBufferPool" yaml:"proxyBufferPool"`                      // none | sync
+	ProxyErrorHandler      string        `json:"proxyErrorHandler" bson:"proxyErrorHandler" yaml:"proxyErrorHandler"`                // none | body
+}
+
+func mergeConfig(c *Config) *Config {
+	if c == nil {
+		c = new(Config)
+	}
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = 30 * time.Second
+	}
+	if c.KeepAlive == 0 {
+		c.KeepAlive = 30 * time.Second
+	}
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = 10240
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = 90 * time.Second
+	}
+	if c.TLSHandshakeTimeout == 0 {
+		c.TLSHandshakeTimeout = 10 * time.Second
+	}
+	if c.ResponseHeaderTimeout == 0 {
+		c.ResponseHeaderTimeout = 5 * time.Second
+	}
+	if c.ExpectContinueTimeout == 0 {
+		c.ExpectContinueTimeout = 1 * time.Second
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = 60 * time.Second
+	}
+	if c.ProxyBufferPool == "" {
+		c.ProxyBufferPool = BufferPoolNone
+	}
+	if c.ProxyErrorHandler == "" {
+		c.ProxyErrorHandler = ErrorHandlerNone
+	}
+	return c
+}
+
+// 单个路由的代理目标
+type Target struct {
+	Service  string   // center注册的服务名, 与Targets二选一
+	Targets  []string // 静态地址host:port, 用于没有注册中心的环境
+	Path     string   // 目标路径
+	Https    bool     // 是否使用tls
+	Policy   string   // robin | random | leastconn, 默认robin
+	CaFile   string   // 自定义CA证书(可选)
+	CertFile string   // 客户端证书(可选)
+	KeyFile  string   // 客户端私钥(可选)
+
+	Retries       int              // 失败重试次数, 仅幂等方法(GET/HEAD/OPTIONS/PUT/DELETE/TRACE)
+	RetryBackoff  time.Duration    // 重试退避基数, 按次翻倍, 默认100毫秒
+	TryTimeout    time.Duration    // 单次尝试超时(含最后一次), 0表示仅受RequestTimeout限制
+	MaxRetryBytes int64            // 重试需缓存请求体, 超出该字节数则不重试, 默认1M
+	Breaker       *Breaker         // 熔断器(可选)
+	Fallback      http.HandlerFunc // 熔断打开时的响应, 默认503
+}
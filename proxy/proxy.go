@@ -0,0 +1,380 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/obase/pbapi/trace"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BufferBlockSize      = 32 * 1024
+	DefaultMaxRetryBytes = 1024 * 1024
+)
+
+type addrKey struct{}
+
+// gin.Context中记录的最终上游地址, 供access log使用
+const CONTEXT_KEY = "pbapi.proxy.upstream"
+
+// 当前请求选中的代理实例地址, 仅在Director/ErrorHandler/ModifyResponse中有效
+func UpstreamAddr(r *http.Request) string {
+	addr, _ := r.Context().Value(addrKey{}).(string)
+	return addr
+}
+
+/*
+代理工厂: 整个Server共享基础Transport, 热加载时重建Proxy也不会丢弃连接池.
+自定义CA或客户端证书的https目标会按证书组合单独复用一个Transport.
+*/
+type Factory struct {
+	sync.Mutex
+	config       *Config
+	transport    *http.Transport
+	tlsTransport map[string]*http.Transport
+	bufferPool   httputil.BufferPool
+	errorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+func NewFactory(c *Config) (*Factory, error) {
+	c = mergeConfig(c)
+	f := &Factory{
+		config:       c,
+		tlsTransport: make(map[string]*http.Transport),
+	}
+	f.transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   c.ConnectTimeout,
+			KeepAlive: c.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:      true,
+		MaxIdleConns:           c.MaxIdleConns,
+		MaxIdleConnsPerHost:    c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:        c.MaxConnsPerHost,
+		IdleConnTimeout:        c.IdleConnTimeout,
+		TLSHandshakeTimeout:    c.TLSHandshakeTimeout,
+		DisableCompression:     c.DisableCompression,
+		ResponseHeaderTimeout:  c.ResponseHeaderTimeout,
+		ExpectContinueTimeout:  c.ExpectContinueTimeout,
+		MaxResponseHeaderBytes: c.MaxResponseHeaderBytes,
+	}
+	switch c.ProxyBufferPool {
+	case BufferPoolNone:
+	case BufferPoolSync:
+		f.bufferPool = &syncBufferPool{Pool: sync.Pool{New: func() interface{} {
+			return make([]byte, BufferBlockSize)
+		}}}
+	default:
+		return nil, errors.New("invalid proxy buffer pool: " + c.ProxyBufferPool)
+	}
+	switch c.ProxyErrorHandler {
+	case ErrorHandlerNone:
+	case ErrorHandlerBody:
+		f.errorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "proxy error: %v", err)
+		}
+	default:
+		return nil, errors.New("invalid proxy error handler: " + c.ProxyErrorHandler)
+	}
+	return f, nil
+}
+
+func (f *Factory) Transport(t *Target) (*http.Transport, error) {
+	if !t.Https || (t.CaFile == "" && t.CertFile == "") {
+		return f.transport, nil
+	}
+	key := t.CaFile + "|" + t.CertFile + "|" + t.KeyFile
+	f.Lock()
+	defer f.Unlock()
+	if tr, ok := f.tlsTransport[key]; ok {
+		return tr, nil
+	}
+	tlsConfig := new(tls.Config)
+	if t.CaFile != "" {
+		pem, err := ioutil.ReadFile(t.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("invalid proxy ca file: " + t.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	tr := f.transport.Clone()
+	tr.TLSClientConfig = tlsConfig
+	f.tlsTransport[key] = tr
+	return tr, nil
+}
+
+func (f *Factory) New(t *Target) (*Proxy, error) {
+	var fetch instances
+	if len(t.Targets) > 0 {
+		fetch = staticInstances(t.Targets)
+	} else if t.Service != "" {
+		fetch = centerInstances(t.Service)
+	} else {
+		return nil, errors.New("proxy target requires service or targets")
+	}
+	balancer, err := newBalancer(strings.ToLower(t.Policy), fetch)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := f.Transport(t)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "http"
+	if t.Https {
+		scheme = "https"
+	}
+	path := t.Path
+	errorHandler := f.errorHandler
+	backoff := t.RetryBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	maxRetryBytes := t.MaxRetryBytes
+	if maxRetryBytes <= 0 {
+		maxRetryBytes = DefaultMaxRetryBytes
+	}
+	return &Proxy{
+		Balancer:      balancer,
+		Timeout:       f.config.RequestTimeout,
+		Retries:       t.Retries,
+		RetryBackoff:  backoff,
+		TryTimeout:    t.TryTimeout,
+		MaxRetryBytes: maxRetryBytes,
+		Breaker:       t.Breaker,
+		Fallback:      t.Fallback,
+		ReverseProxy: &httputil.ReverseProxy{
+			Transport:     transport,
+			FlushInterval: f.config.ProxyFlushInterval,
+			Director: func(req *http.Request) {
+				req.URL.Scheme = scheme
+				req.URL.Host = UpstreamAddr(req)
+				req.URL.Path = path
+				req.Host = req.URL.Host // 不透传客户端Host, 否则虚拟主机的上游无法路由
+				trace.Inject(req.Context(), req.Header)
+				if _, ok := req.Header["User-Agent"]; !ok {
+					// explicitly disable User-Agent so it's not set to default value
+					req.Header.Set("User-Agent", "")
+				}
+			},
+			BufferPool: f.bufferPool,
+			// 5xx计为失败, 非最后一次尝试则丢弃响应转入ErrorHandler以便重试
+			ModifyResponse: func(rsp *http.Response) error {
+				if rsp.StatusCode >= http.StatusInternalServerError {
+					if st := tryStateOf(rsp.Request); st != nil {
+						st.failed = true
+						if !st.last {
+							return errRetryStatus
+						}
+					}
+				}
+				return nil
+			},
+			// 非最后一次尝试只记录错误, 不写响应
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				st := tryStateOf(r)
+				if st != nil {
+					st.failed = true
+					if !st.last {
+						return
+					}
+				}
+				if errorHandler != nil {
+					errorHandler(w, r, err)
+				} else {
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			},
+		},
+	}, nil
+}
+
+func (f *Factory) Close() {
+	f.Lock()
+	defer f.Unlock()
+	f.transport.CloseIdleConnections()
+	for _, tr := range f.tlsTransport {
+		tr.CloseIdleConnections()
+	}
+}
+
+var errRetryStatus = errors.New("retryable upstream status")
+
+type tryStateKey struct{}
+
+// 单次尝试的状态, 通过请求context在ReverseProxy回调间传递
+type tryState struct {
+	last   bool // 是否最后一次尝试, 只有最后一次才写错误响应
+	failed bool
+	addr   string
+}
+
+func tryStateOf(r *http.Request) *tryState {
+	st, _ := r.Context().Value(tryStateKey{}).(*tryState)
+	return st
+}
+
+type Proxy struct {
+	*httputil.ReverseProxy
+	Balancer      Balancer
+	Timeout       time.Duration // 单次代理请求超时, 0表示不限制
+	Retries       int
+	RetryBackoff  time.Duration
+	TryTimeout    time.Duration
+	MaxRetryBytes int64 // 缓存请求体上限, 超出则不重试
+	Breaker       *Breaker
+	Fallback      http.HandlerFunc
+}
+
+func Idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Forward(w, r)
+}
+
+// 转发并返回最后一次尝试的上游地址, 熔断或没有可用实例时为空
+func (p *Proxy) Forward(w http.ResponseWriter, r *http.Request) (upstream string) {
+	if p.Breaker != nil && !p.Breaker.Allow() {
+		if p.Fallback != nil {
+			p.Fallback(w, r)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		return
+	}
+
+	ctx := r.Context()
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	// 重试需要重放请求体
+	retries := p.Retries
+	var body []byte
+	if retries > 0 && !Idempotent(r.Method) {
+		retries = 0
+	}
+	if retries > 0 && r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > p.MaxRetryBytes {
+			retries = 0
+		} else if data, err := ioutil.ReadAll(io.LimitReader(r.Body, p.MaxRetryBytes+1)); err != nil {
+			retries = 0
+			r.Body.Close()
+		} else if int64(len(data)) > p.MaxRetryBytes {
+			// 超出上限不再缓存, 已读部分与剩余部分拼接后只转发一次
+			retries = 0
+			r.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(data), r.Body), Closer: r.Body}
+		} else {
+			body = data
+			r.Body.Close()
+		}
+	}
+
+	var failed bool
+	for try := 0; ; try++ {
+		st := &tryState{last: try >= retries}
+		failed = p.try(w, r, ctx, st, body)
+		upstream = st.addr
+		if !failed || st.last {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.RetryBackoff << uint(try)):
+			continue
+		}
+		// 整体超时, 最后一次尝试输出错误
+		st = &tryState{last: true}
+		p.try(w, r, ctx, st, body)
+		upstream = st.addr
+		break
+	}
+	if p.Breaker != nil {
+		p.Breaker.Done(!failed)
+	}
+	return
+}
+
+func (p *Proxy) try(w http.ResponseWriter, r *http.Request, ctx context.Context, st *tryState, body []byte) bool {
+	addr, err := p.Balancer.Select()
+	if err != nil {
+		if st.last {
+			p.ErrorHandler(w, r, err)
+		}
+		return true
+	}
+	defer p.Balancer.Release(addr)
+	st.addr = addr
+
+	ctx = context.WithValue(context.WithValue(ctx, addrKey{}, addr), tryStateKey{}, st)
+	ctx, span := trace.FromContext(ctx).StartChild(ctx, "proxy "+addr, trace.KindClient)
+	if span != nil {
+		defer func() {
+			if st.failed {
+				span.Error = "upstream failed"
+			}
+			span.End()
+		}()
+	}
+	if p.TryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.TryTimeout)
+		defer cancel()
+	}
+	req := r.WithContext(ctx)
+	if body != nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	p.ReverseProxy.ServeHTTP(w, req)
+	return st.failed
+}
+
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+type syncBufferPool struct {
+	sync.Pool
+}
+
+func (s *syncBufferPool) Get() []byte {
+	return s.Pool.Get().([]byte)
+}
+
+func (s *syncBufferPool) Put(v []byte) {
+	s.Pool.Put(v)
+}
@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProxyHttps(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.Path))
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "https://")
+
+	ca, err := ioutil.TempFile("", "pbapi-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	ca.Close()
+
+	f, err := NewFactory(&Config{ProxyErrorHandler: ErrorHandlerBody})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := f.New(&Target{
+		Targets: []string{host},
+		Path:    "/target",
+		Https:   true,
+		CaFile:  ca.Name(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/gw", nil))
+	if w.Code != http.StatusOK || w.Body.String() != host+"/target" {
+		t.Fatalf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBalancer(t *testing.T) {
+	addrs := []string{"a", "b", "c"}
+	for _, policy := range []string{PolicyRobin, PolicyRandom, PolicyLeastConn} {
+		b, err := newBalancer(policy, staticInstances(addrs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits := make(map[string]int)
+		for i := 0; i < 300; i++ {
+			addr, err := b.Select()
+			if err != nil {
+				t.Fatal(err)
+			}
+			hits[addr]++
+			if policy != PolicyLeastConn {
+				b.Release(addr)
+			}
+		}
+		// robin与leastconn(不释放)应当完全均匀
+		if policy != PolicyRandom && (hits["a"] != 100 || hits["b"] != 100 || hits["c"] != 100) {
+			t.Errorf("%v unbalanced: %v", policy, hits)
+		}
+	}
+	b, _ := newBalancer(PolicyRobin, staticInstances(nil))
+	if _, err := b.Select(); err != ErrNoInstance {
+		t.Errorf("expect ErrNoInstance, got %v", err)
+	}
+}
+
+func TestRetryBreaker(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(&hits, 1)%3 != 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	f, err := NewFactory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	breaker := NewBreaker("/gw", &BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	p, err := f.New(&Target{
+		Targets:      []string{strings.TrimPrefix(upstream.URL, "http://")},
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+		Breaker:      breaker,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 前两次502被重试, 第三次成功且请求体被重放
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/gw", strings.NewReader("hello")))
+	if w.Code != http.StatusOK || w.Body.String() != "hello" || hits != 3 {
+		t.Fatalf("unexpected response: %v %s, hits %v", w.Code, w.Body.String(), hits)
+	}
+
+	// POST不重试, 失败后熔断打开
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gw", nil))
+	if w.Code != http.StatusBadGateway || hits != 4 {
+		t.Fatalf("unexpected response: %v, hits %v", w.Code, hits)
+	}
+	if st := breaker.State(); st.State != StateOpen {
+		t.Fatalf("expect open, got %v", st.State)
+	}
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/gw", nil))
+	if w.Code != http.StatusServiceUnavailable || hits != 4 {
+		t.Fatalf("expect fallback, got %v, hits %v", w.Code, hits)
+	}
+}
+
+func TestTryTimeoutRetryBody(t *testing.T) {
+	var hits int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if r.Method == http.MethodPost {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	f, err := NewFactory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := f.New(&Target{
+		Targets:       []string{strings.TrimPrefix(upstream.URL, "http://")},
+		Retries:       2,
+		RetryBackoff:  time.Millisecond,
+		TryTimeout:    50 * time.Millisecond,
+		MaxRetryBytes: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// POST只有一次尝试, 单次超时同样生效
+	start := time.Now()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/gw", nil))
+	if w.Code != http.StatusBadGateway || time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expect try timeout, got %v after %v", w.Code, time.Since(start))
+	}
+
+	// 请求体超出上限不重试, 完整转发一次
+	atomic.StoreInt32(&hits, 0)
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/gw", strings.NewReader("hello world"))
+	r.ContentLength = -1
+	p.ServeHTTP(w, r)
+	if w.Body.String() != "hello world" || hits != 1 {
+		t.Fatalf("unexpected response: %v %s, hits %v", w.Code, w.Body.String(), hits)
+	}
+
+	// 上限内的请求体被重放
+	atomic.StoreInt32(&hits, 0)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/gw", strings.NewReader("hello")))
+	if w.Body.String() != "hello" || hits != 3 {
+		t.Fatalf("unexpected response: %v %s, hits %v", w.Code, w.Body.String(), hits)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := NewBreaker("test", &BreakerConfig{ErrorRatio: 0.5, MinRequests: 4, OpenTimeout: 10 * time.Millisecond})
+	for _, ok := range []bool{true, false, true, false} {
+		if !b.Allow() {
+			t.Fatal("closed breaker rejected")
+		}
+		b.Done(ok)
+	}
+	if b.Allow() {
+		t.Fatal("open breaker allowed")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !b.Allow() || b.Allow() {
+		t.Fatal("half-open should allow exactly one probe")
+	}
+	b.Done(true)
+	if st := b.State(); st.State != StateClosed {
+		t.Fatalf("expect closed, got %v", st.State)
+	}
+}
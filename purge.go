@@ -0,0 +1,84 @@
+package pbapi
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/cache"
+	"net/http"
+)
+
+var errCacheNotStarted = errors.New("http cache not started")
+
+/*
+主动删除http缓存, 用于写操作后使缓存立即失效, http服务启动前调用返回错误:
+1. PurgeCache: 缓存key, 默认为METHOD:path:rawQuery(POST/PATCH/PUT附加请求体MD5), 同时删除其Vary条目
+2. PurgeCachePrefix: 请求路径前缀, 例如/demo/echo
+3. PurgeCacheTags: 处理器通过cache.Tag()或响应头X-Cache-Tags设置的标签
+*/
+func (server *Server) PurgeCache(key string) error {
+	if server.httpCache == nil {
+		return errCacheNotStarted
+	}
+	return server.httpCache.Purge(key)
+}
+
+func (server *Server) PurgeCachePrefix(path string) error {
+	if server.httpCache == nil {
+		return errCacheNotStarted
+	}
+	return server.httpCache.PurgePrefix(path)
+}
+
+func (server *Server) PurgeCacheTags(tags ...string) error {
+	if server.httpCache == nil {
+		return errCacheNotStarted
+	}
+	return server.httpCache.PurgeTags(tags...)
+}
+
+type purgeRequest struct {
+	Keys     []string `json:"keys"`
+	Prefixes []string `json:"prefixes"`
+	Tags     []string `json:"tags"`
+}
+
+func newPurgeHandlerFunc(c cache.Cache) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req := new(purgeRequest)
+		if err := ctx.ShouldBindJSON(req); err != nil {
+			ctx.JSON(http.StatusBadRequest, &Response{
+				Code: PARSING_REQUEST_ERROR,
+				Msg:  err.Error(),
+			})
+			return
+		}
+		err := purge(c, req)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, &Response{
+				Code: EXECUTE_SERVICE_ERROR,
+				Msg:  err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, &Response{
+			Code: SUCCESS,
+		})
+	}
+}
+
+func purge(c cache.Cache, req *purgeRequest) error {
+	for _, k := range req.Keys {
+		if err := c.Purge(k); err != nil {
+			return err
+		}
+	}
+	for _, p := range req.Prefixes {
+		if err := c.PurgePrefix(p); err != nil {
+			return err
+		}
+	}
+	if len(req.Tags) > 0 {
+		return c.PurgeTags(req.Tags...)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/obase/pbapi/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPurgeCache(t *testing.T) {
+	calls := 0
+	s := newDemoServer("EchoService", map[string]func(context.Context, []byte) (interface{}, error){
+		"Echo": func(ctx context.Context, data []byte) (interface{}, error) {
+			calls++
+			cache.Tag(ctx, "echo")
+			return string(data), nil
+		},
+	})
+	if err := s.PurgeCacheTags("echo"); err == nil {
+		t.Fatal("expect error before start")
+	}
+	config := &Config{
+		AdminPath:    "/admin",
+		AdminPlugins: [][]string{{"hostsallow", "192.0.2.1"}}, // httptest默认来源
+		RouterConfig: []*RouterConfig{{Path: "/demo/echo/echo", Cache: 60}},
+	}
+	c := cache.New(&cache.Config{Type: cache.MEMORY})
+	defer c.Close()
+	s.httpCache = c
+	engine := compileDemoEngine(t, s, config, &httpRuntime{cache: c})
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+	echo := func(expect int) {
+		post("/demo/echo/echo", "hello")
+		if calls != expect {
+			t.Fatalf("expect %v calls, got %v", expect, calls)
+		}
+	}
+
+	echo(1)
+	echo(1)
+	if w := post("/admin/cache/purge", `{"tags":["echo"]}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":0`) {
+		t.Fatalf("purge tags: %v %s", w.Code, w.Body.String())
+	}
+	echo(2)
+	if w := post("/admin/cache/purge", `{"prefixes":["/demo/echo"]}`); w.Code != http.StatusOK {
+		t.Fatalf("purge prefix: %v %s", w.Code, w.Body.String())
+	}
+	echo(3)
+	if w := post("/admin/cache/purge", `{"tags":`); w.Code != http.StatusBadRequest {
+		t.Errorf("expect bad request: %v %s", w.Code, w.Body.String())
+	}
+	if err := s.PurgeCacheTags("echo"); err != nil {
+		t.Fatal(err)
+	}
+	echo(4)
+
+	// 配置了期限时适配器收到派生的ctx, 标签仍然生效
+	config.Timeout = time.Second
+	engine = compileDemoEngine(t, s, config, &httpRuntime{cache: c})
+	c.PurgePrefix("/demo/echo")
+	echo(5)
+	echo(5)
+	if err := s.PurgeCacheTags("echo"); err != nil {
+		t.Fatal(err)
+	}
+	echo(6)
+
+	// 未配置adminPlugins时不注册purge
+	config.AdminPlugins = nil
+	engine = compileDemoEngine(t, s, config, &httpRuntime{cache: c})
+	if w := post("/admin/cache/purge", `{"tags":["echo"]}`); w.Code != http.StatusNotFound {
+		t.Errorf("expect not found: %v %s", w.Code, w.Body.String())
+	}
+	// 非法的admin插件参数拒绝编译而非panic
+	config.AdminPlugins = [][]string{{"cors", "maxAge:x"}}
+	if _, err := s.compileHttpEngine(config, &httpRuntime{cache: c}); err == nil || !strings.Contains(err.Error(), "cors") {
+		t.Errorf("expect plugin error, got %v", err)
+	}
+}
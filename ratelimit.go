@@ -0,0 +1,337 @@
+package pbapi
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/log"
+	"github.com/obase/redis.v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HEADER_RETRY_AFTER = "Retry-After"
+	MD_RETRY_AFTER     = "retry-after"
+
+	RATELIMIT_IP     = "ip"
+	RATELIMIT_GLOBAL = "global"
+	RATELIMIT_HEADER = "header:" // 例如"header:X-App-Id", grpc取同名metadata(小写)
+	RATELIMIT_REDIS  = "redis:"  // 例如"redis:ratelimit", 引用redis.v2已Setup的key(conf.yml顶层redis或service.cache)
+	RATELIMIT_NAME   = "name:"   // 令牌桶名称(亦为redis键前缀), 默认ratelimit
+)
+
+/*
+ratelimit的参数规则: ratelimit(rate, [burst], [ip|global|header:<name>], [redis:<key>], [name:<prefix>], [proxy:<可信代理>]...)
+1. rate: 每秒令牌数, 可带单位: 10/s, 600/m, 3600/h
+2. burst: 桶容量, 默认为每秒令牌数(至少1)
+3. 限流键: ip(默认, 可信代理规则同hostsallow), global, header:<name>
+4. redis:<key>: 使用redis分布式令牌桶, redis不可用时退化为本地限流
+注意: 各路由独立计数, 全局routerPlugins由各路由共享. 热加载后参数未变的路由沿用已有令牌桶
+*/
+type Ratelimit struct {
+	Rate    float64 // 每秒令牌数
+	Burst   int
+	Key     string
+	Header  string
+	Proxies *Hostsallow
+	limiter limiter
+}
+
+type limiter interface {
+	// 取一个令牌, 失败时返回需等待的时间
+	Take(key string) (bool, time.Duration)
+}
+
+func parseRate(v string) (float64, error) {
+	v = strings.TrimSpace(v)
+	unit := time.Second
+	if p := strings.IndexByte(v, '/'); p != -1 {
+		switch strings.TrimSpace(v[p+1:]) {
+		case "s":
+		case "m":
+			unit = time.Minute
+		case "h":
+			unit = time.Hour
+		default:
+			return 0, errors.New("invalid rate unit: " + v)
+		}
+		v = strings.TrimSpace(v[:p])
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid rate: " + v)
+	}
+	return n / unit.Seconds(), nil
+}
+
+func NewRatelimit(args []string) (*Ratelimit, error) {
+	return newRatelimit(nil, "", args)
+}
+
+// states按scope(结点路径, 全局插件为空)与参数保留本地令牌桶, 为nil时新建
+func newRatelimit(states *runtimeStates, scope string, args []string) (*Ratelimit, error) {
+	if len(args) == 0 {
+		return nil, errors.New("ratelimit requires rate")
+	}
+	rate, err := parseRate(args[0])
+	if err != nil {
+		return nil, err
+	}
+	r := &Ratelimit{
+		Rate: rate,
+		Key:  RATELIMIT_IP,
+	}
+	var (
+		proxies  []string
+		redisKey string
+		name     = "ratelimit"
+	)
+	for _, arg := range args[1:] {
+		arg = strings.TrimSpace(arg)
+		switch {
+		case arg == "":
+		case arg == RATELIMIT_IP || arg == RATELIMIT_GLOBAL:
+			r.Key = arg
+		case strings.HasPrefix(arg, RATELIMIT_HEADER):
+			r.Key, r.Header = RATELIMIT_HEADER, strings.TrimSpace(arg[len(RATELIMIT_HEADER):])
+			if r.Header == "" {
+				return nil, errors.New("ratelimit empty header: " + arg)
+			}
+		case strings.HasPrefix(arg, RATELIMIT_REDIS):
+			redisKey = strings.TrimSpace(arg[len(RATELIMIT_REDIS):])
+		case strings.HasPrefix(arg, RATELIMIT_NAME):
+			name = strings.TrimSpace(arg[len(RATELIMIT_NAME):])
+		case strings.HasPrefix(arg, HOSTSALLOW_PROXY):
+			proxies = append(proxies, arg)
+		default:
+			burst, err := strconv.Atoi(arg)
+			if err != nil || burst <= 0 {
+				return nil, errors.New("ratelimit invalid argument: " + arg)
+			}
+			r.Burst = burst
+		}
+	}
+	if r.Burst == 0 {
+		r.Burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	r.Proxies = NewHostsallow(proxies)
+	// 键前缀包含scope与参数摘要, 避免不同路由或配置共用令牌桶
+	id := scope + " " + strings.Join(args, ",")
+	sum := md5.Sum([]byte(id))
+	prefix := name + ":" + hex.EncodeToString(sum[:4]) + ":"
+	local := states.get("ratelimit:"+id, func() interface{} {
+		return newLocalLimiter(r.Rate, r.Burst)
+	}).(*localLimiter)
+	if redisKey != "" {
+		r.limiter = &redisLimiter{
+			get: func() redis.Redis {
+				return redis.Get(redisKey) // 可能由cache延迟初始化, 故每次获取
+			},
+			prefix: prefix,
+			rate:   r.Rate,
+			burst:  r.Burst,
+			local:  local,
+		}
+	} else {
+		r.limiter = local
+	}
+	return r, nil
+}
+
+func (r *Ratelimit) Take(key string) (bool, time.Duration) {
+	return r.limiter.Take(key)
+}
+
+// 向上取整的秒数, 至少1秒
+func retryAfter(wait time.Duration) string {
+	secs := int64(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
+func RatelimitRouterPlugin(args []string) gin.HandlersChain {
+	return ratelimitRouterPlugin(nil, "", args)
+}
+
+func ratelimitRouterPlugin(states *runtimeStates, scope string, args []string) gin.HandlersChain {
+	r, err := newRatelimit(states, scope, args)
+	if err != nil {
+		panic(err) // 编译阶段捕获, 启动失败或拒绝热加载
+	}
+	return gin.HandlersChain{func(ctx *gin.Context) {
+		var key string
+		switch r.Key {
+		case RATELIMIT_IP:
+			key = r.Proxies.ClientIP(RemoteHost(ctx.Request.RemoteAddr), ctx.Request.Header[HEADER_X_FORWARDED_FOR])
+		case RATELIMIT_HEADER:
+			key = ctx.Request.Header.Get(r.Header)
+		}
+		if ok, wait := r.Take(key); !ok {
+			ctx.Header(HEADER_RETRY_AFTER, retryAfter(wait))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, &Response{
+				Code: RATE_LIMITED_ERROR,
+				Msg:  "rate limit exceeded",
+			})
+		}
+	}}
+}
+
+func RatelimitServerPlugin(args []string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	r, err := NewRatelimit(args)
+	if err != nil {
+		panic(err)
+	}
+	header := strings.ToLower(r.Header)
+	take := func(ctx context.Context) error {
+		var key string
+		md, _ := metadata.FromIncomingContext(ctx)
+		switch r.Key {
+		case RATELIMIT_IP:
+			var remote string
+			if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+				remote = RemoteHost(p.Addr.String())
+			}
+			key = r.Proxies.ClientIP(remote, md.Get(MD_X_FORWARDED_FOR))
+		case RATELIMIT_HEADER:
+			if vs := md.Get(header); len(vs) > 0 {
+				key = vs[0]
+			}
+		}
+		if ok, wait := r.Take(key); !ok {
+			grpc.SetHeader(ctx, metadata.Pairs(MD_RETRY_AFTER, retryAfter(wait)))
+			return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := take(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}, func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := take(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 本地令牌桶, 定期清理已补满的桶
+type localLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	idle    time.Duration // 补满所需时间, 超过即可清理
+	buckets map[string]*bucket
+	sweep   time.Time
+}
+
+func newLocalLimiter(rate float64, burst int) *localLimiter {
+	return &localLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		idle:    time.Duration(float64(burst) / rate * float64(time.Second)),
+		buckets: make(map[string]*bucket),
+		sweep:   time.Now(),
+	}
+}
+
+func (l *localLimiter) Take(key string) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	now := time.Now()
+	if now.Sub(l.sweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) >= l.idle {
+				delete(l.buckets, k)
+			}
+		}
+		l.sweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// 返回需等待的微秒数, 0表示取得令牌
+const redisTokenBucket = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local v = redis.call('HMGET', KEYS[1], 't', 'ts')
+local tokens = tonumber(v[1]) or burst
+local ts = tonumber(v[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000000)
+	ts = now
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000000 / rate)
+end
+redis.call('HMSET', KEYS[1], 't', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`
+
+type redisLimiter struct {
+	get    func() redis.Redis
+	prefix string
+	rate   float64
+	burst  int
+	local  *localLimiter
+}
+
+func (l *redisLimiter) Take(key string) (bool, time.Duration) {
+	rdb := l.get()
+	if rdb == nil {
+		return l.local.Take(key)
+	}
+	now := time.Now().UnixNano() / int64(time.Microsecond)
+	wait, _, err := redis.Int64(rdb.Eval(redisTokenBucket, 1, l.prefix+key,
+		strconv.FormatFloat(l.rate, 'f', -1, 64), l.burst, now))
+	if err != nil {
+		log.Errorf("ratelimit redis error: %v", err)
+		return l.local.Take(key)
+	}
+	if wait > 0 {
+		return false, time.Duration(wait) * time.Microsecond
+	}
+	return true, 0
+}
+
+func (r *Ratelimit) String() string {
+	return fmt.Sprintf("ratelimit(%v/s, %v, %v)", r.Rate, r.Burst, r.Key)
+}
@@ -0,0 +1,204 @@
+package pbapi
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/redis.v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRatelimit(t *testing.T) {
+	if _, err := NewRatelimit([]string{"10/d"}); err == nil {
+		t.Error("expect invalid rate unit")
+	}
+	r, err := NewRatelimit([]string{"60/m", "2", "header:X-App-Id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Rate != 1 || r.Burst != 2 || r.Header != "X-App-Id" {
+		t.Fatalf("unexpected ratelimit: %v", r)
+	}
+	engine := gin.New()
+	engine.GET("/test", append(RatelimitRouterPlugin([]string{"1/h", "2", "header:X-App-Id"}), func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})...)
+	for i, expect := range []int{200, 200, 429} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-App-Id", "a")
+		engine.ServeHTTP(w, req)
+		if w.Code != expect {
+			t.Fatalf("request %v: expect %v, got %v", i, expect, w.Code)
+		}
+		if expect == 429 && w.Header().Get(HEADER_RETRY_AFTER) == "" {
+			t.Fatal("missing Retry-After")
+		}
+	}
+	// 不同键独立计数
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-App-Id", "b")
+	engine.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %v", w.Code)
+	}
+}
+
+func TestRatelimitBucket(t *testing.T) {
+	l := newLocalLimiter(2, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Take("a"); !ok {
+			t.Fatalf("take %v: expect ok", i)
+		}
+	}
+	ok, wait := l.Take("a")
+	if ok || wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("expect wait about 500ms, got %v %v", ok, wait)
+	}
+	// 按速率补充令牌
+	time.Sleep(wait)
+	if ok, _ := l.Take("a"); !ok {
+		t.Fatal("expect refilled")
+	}
+	if ok, _ := l.Take("b"); !ok {
+		t.Fatal("expect independent key")
+	}
+	for wait, expect := range map[time.Duration]string{0: "1", 200 * time.Millisecond: "1", 1500 * time.Millisecond: "2", 60 * time.Second: "60"} {
+		if v := retryAfter(wait); v != expect {
+			t.Errorf("retryAfter(%v): expect %v, got %v", wait, expect, v)
+		}
+	}
+}
+
+// 只实现Eval, 按顺序返回预设结果
+type evalRedis struct {
+	redis.Redis
+	keys    []interface{}
+	replies []interface{}
+	err     error
+}
+
+func (r *evalRedis) Eval(script string, keys int, keysArgs ...interface{}) (interface{}, error) {
+	if script != redisTokenBucket || keys != 1 {
+		return nil, errors.New("unexpected script")
+	}
+	r.keys = append(r.keys, keysArgs[0])
+	if r.err != nil {
+		return nil, r.err
+	}
+	ret := r.replies[0]
+	r.replies = r.replies[1:]
+	return ret, nil
+}
+
+func TestRatelimitRedis(t *testing.T) {
+	r, err := NewRatelimit([]string{"1/s", "1", "redis:test", "name:test-redis"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := r.limiter.(*redisLimiter)
+	rdb := &evalRedis{replies: []interface{}{int64(0), int64(250000)}}
+	rl.get = func() redis.Redis {
+		return rdb
+	}
+	if ok, _ := r.Take("a"); !ok {
+		t.Fatal("expect ok")
+	}
+	// 脚本返回等待的微秒数
+	if ok, wait := r.Take("a"); ok || wait != 250*time.Millisecond {
+		t.Fatalf("expect wait 250ms, got %v %v", ok, wait)
+	}
+	if len(rdb.keys) != 2 || !strings.HasPrefix(rdb.keys[0].(string), "test-redis:") || !strings.HasSuffix(rdb.keys[0].(string), ":a") {
+		t.Fatalf("unexpected keys: %v", rdb.keys)
+	}
+	// redis出错或未配置时退化为本地限流
+	rdb.err = errors.New("down")
+	if ok, _ := r.Take("a"); !ok {
+		t.Fatal("expect local fallback ok")
+	}
+	rl.get = func() redis.Redis {
+		return nil
+	}
+	if ok, _ := r.Take("a"); ok {
+		t.Fatal("expect local fallback limited")
+	}
+}
+
+func TestRatelimitReload(t *testing.T) {
+	s := NewServer()
+	ok := func(ctx *gin.Context) { ctx.String(http.StatusOK, "ok") }
+	s.GET("/a", ok)
+	s.GET("/b", ok)
+	get := func(engine http.Handler, path string) int {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+	runtime := &httpRuntime{states: new(runtimeStates)}
+	compile := func(config *Config) http.Handler {
+		engine, err := s.compileHttpEngine(mergeConfig(config), runtime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return engine
+	}
+
+	// 全局插件只编译一次, 各路由共用令牌桶
+	engine := compile(&Config{RouterPlugins: [][]string{{"ratelimit", "1/m", "1", "global"}}})
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect ok: %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/b", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(HEADER_RETRY_AFTER) != "60" || !strings.Contains(w.Body.String(), `"code":606`) {
+		t.Fatalf("expect limited: %v %v %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	// 相同参数的路由插件各路由独立计数
+	plugins := [][]string{{"ratelimit", "1/h", "1", "global"}}
+	routes := &Config{RouterConfig: []*RouterConfig{{Path: "/a", Plugins: plugins}, {Path: "/b", Plugins: plugins}}}
+	engine = compile(routes)
+	for i, expect := range []struct {
+		path string
+		code int
+	}{{"/a", 200}, {"/b", 200}, {"/a", 429}, {"/b", 429}} {
+		if code := get(engine, expect.path); code != expect.code {
+			t.Fatalf("request %v %v: expect %v, got %v", i, expect.path, expect.code, code)
+		}
+	}
+	// 重新编译(热加载)后沿用令牌桶
+	engine = compile(routes)
+	if code := get(engine, "/a"); code != http.StatusTooManyRequests {
+		t.Fatalf("expect limited after reload: %v", code)
+	}
+	// 编译失败不影响已有状态
+	if _, err := s.compileHttpEngine(mergeConfig(&Config{RouterPlugins: [][]string{{"none"}}}), runtime); err == nil {
+		t.Fatal("expect invalid plugin")
+	}
+	if code := get(compile(routes), "/b"); code != http.StatusTooManyRequests {
+		t.Fatalf("expect limited after rejected reload: %v", code)
+	}
+	// 移除后再加入的路由重新计数
+	compile(&Config{RouterConfig: []*RouterConfig{{Path: "/b", Plugins: plugins}}})
+	engine = compile(routes)
+	if code := get(engine, "/a"); code != http.StatusOK {
+		t.Fatalf("expect new bucket for re-added route: %v", code)
+	}
+	if code := get(engine, "/b"); code != http.StatusTooManyRequests {
+		t.Fatalf("expect kept bucket: %v", code)
+	}
+	// 不同server(运行期)互不影响
+	if code := get(compile(routes), "/b"); code != http.StatusTooManyRequests {
+		t.Fatalf("expect limited: %v", code)
+	}
+	runtime = &httpRuntime{states: new(runtimeStates)}
+	if code := get(compile(routes), "/b"); code != http.StatusOK {
+		t.Fatalf("expect independent runtime: %v", code)
+	}
+}
@@ -0,0 +1,131 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/log"
+	"github.com/obase/pbapi/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"runtime/debug"
+)
+
+const (
+	PANIC_HTTP = "http"
+	PANIC_WBSK = "wbsk"
+	PANIC_GRPC = "grpc"
+
+	INTERNAL_ERROR_MSG = "internal server error"
+)
+
+// panic上报钩子, 例如对接告警. method为http路由, websocket标签或grpc FullMethod
+type PanicHook func(ctx context.Context, kind string, method string, err interface{}, stack []byte)
+
+type recovery struct {
+	metrics *metrics.Metrics
+	hook    PanicHook
+}
+
+func (r *recovery) handle(ctx context.Context, kind string, method string, err interface{}) {
+	stack := debug.Stack()
+	log.Errorf("%s panic %s: %v\n%s", kind, method, err, stack)
+	if r.metrics != nil {
+		r.metrics.Panic(kind, method)
+	}
+	if r.hook != nil {
+		defer func() {
+			if herr := recover(); herr != nil {
+				log.Errorf("panic hook panic: %v", herr)
+			}
+		}()
+		r.hook(ctx, kind, method, err, stack)
+	}
+}
+
+// 置于engine最外层, 兜底plugins/filter/路由处理器
+func (r *recovery) HandlerFunc() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if err := recover(); err != nil {
+				// 反向代理以ErrAbortHandler中断响应, 交由net/http处理
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				method := c.FullPath()
+				if method == "" {
+					method = c.Request.URL.Path
+				}
+				r.handle(c, PANIC_HTTP, method, err)
+				if c.Writer.Written() {
+					c.Abort()
+				} else {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, &Response{
+						Code: INTERNAL_ERROR,
+						Msg:  INTERNAL_ERROR_MSG,
+					})
+				}
+			}
+		}()
+		c.Next()
+	}
+}
+
+// 服务适配器内恢复, 转为INTERNAL_ERROR响应. websocket连接因此不会中断
+func (r *recovery) Adapter(kind string, tag string, fn func(context.Context, []byte) (interface{}, error)) func(context.Context, []byte) (interface{}, error) {
+	return func(ctx context.Context, data []byte) (rsp interface{}, err error) {
+		defer func() {
+			if perr := recover(); perr != nil {
+				r.handle(ctx, kind, tag, perr)
+				rsp, err = nil, &Response{
+					Code: INTERNAL_ERROR,
+					Msg:  INTERNAL_ERROR_MSG,
+					Tag:  tag,
+				}
+			}
+		}()
+		return fn(ctx, data)
+	}
+}
+
+// 流式适配器内恢复, 以INTERNAL_ERROR结束流
+func (r *recovery) Stream(kind string, tag string, fn func(grpc.ServerStream) error) func(grpc.ServerStream) error {
+	return func(ss grpc.ServerStream) (err error) {
+		defer func() {
+			if perr := recover(); perr != nil {
+				r.handle(ss.Context(), kind, tag, perr)
+				err = &Response{
+					Code: INTERNAL_ERROR,
+					Msg:  INTERNAL_ERROR_MSG,
+					Tag:  tag,
+				}
+			}
+		}()
+		return fn(ss)
+	}
+}
+
+func (r *recovery) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (rsp interface{}, err error) {
+		defer func() {
+			if perr := recover(); perr != nil {
+				r.handle(ctx, PANIC_GRPC, info.FullMethod, perr)
+				rsp, err = nil, status.Error(codes.Internal, INTERNAL_ERROR_MSG)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func (r *recovery) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if perr := recover(); perr != nil {
+				r.handle(ss.Context(), PANIC_GRPC, info.FullMethod, perr)
+				err = status.Error(codes.Internal, INTERNAL_ERROR_MSG)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
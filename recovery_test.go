@@ -0,0 +1,60 @@
+package pbapi
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecovery(t *testing.T) {
+	s := newDemoServer("BoomService", map[string]func(context.Context, []byte) (interface{}, error){"Boom": func(ctx context.Context, data []byte) (interface{}, error) {
+		panic("boom")
+	}})
+	s.GET("/boom", func(ctx *gin.Context) { panic("boom") })
+	var hooked []string
+	s.PanicHook(func(ctx context.Context, kind string, method string, err interface{}, stack []byte) {
+		hooked = append(hooked, kind+" "+method)
+	})
+	config := &Config{
+		Metrics:      &metrics.Config{Path: "/metrics"},
+		AdminPlugins: [][]string{{"hostsallow", "192.0.2.1"}},
+	}
+	m := metrics.New(config.Metrics)
+	engine := compileDemoEngine(t, s, config, &httpRuntime{metrics: m})
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"code":609`) {
+		t.Fatalf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+	// 适配器内的panic转为响应包
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/demo/boom/boom", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":609`) {
+		t.Fatalf("unexpected response: %v %s", w.Code, w.Body.String())
+	}
+
+	unary := (&recovery{metrics: m, hook: s.panicHook}).UnaryServerInterceptor()
+	_, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/demo.BoomService/Boom"}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("expect Internal, got %v", err)
+	}
+	if strings.Join(hooked, ",") != "http /boom,http BoomService.Boom,grpc /demo.BoomService/Boom" {
+		t.Fatalf("unexpected hooks: %v", hooked)
+	}
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(w.Body.String(), `pbapi_panics_total{kind="http",method="/boom"} 1`) {
+		t.Fatalf("panic not counted: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,169 @@
+package pbapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/obase/conf"
+	"github.com/obase/log"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+热加载:
+1. SIGHUP或conf.yml变化(reloadPeriod轮询)时重新读取conf.yml
+2. 仅routerConfig, serverConfig(http/wbsk部分), routerPlugins, adminPlugins, arguments生效. 端口,缓存,grpc等设置仍需USR2重启
+3. 重新编译gin.Engine后原子替换, 旧engine上的请求照常完成. 编译失败则拒绝本次加载并保留旧engine
+*/
+type EngineHolder struct {
+	value atomic.Value
+}
+
+func NewEngineHolder(h http.Handler) *EngineHolder {
+	ret := new(EngineHolder)
+	ret.Store(h)
+	return ret
+}
+
+func (h *EngineHolder) Store(v http.Handler) {
+	h.value.Store(&v) // atomic.Value要求类型一致, 故存放指针
+}
+
+func (h *EngineHolder) Load() http.Handler {
+	return *(h.value.Load().(*http.Handler))
+}
+
+func (h *EngineHolder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Load().ServeHTTP(w, r)
+}
+
+// conf.yml路径, 与obase/conf的查找次序一致: $CONF_YAML -> 可执行文件目录 -> 工作目录
+func ConfPath() string {
+	if path := os.Getenv(conf.CONF_YAML_ENV); path != "" {
+		return path
+	}
+	loc, _ := exec.LookPath(os.Args[0])
+	path := filepath.Join(filepath.Dir(loc), conf.CONF_YAML_FILE)
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		return path
+	}
+	dir, _ := os.Getwd()
+	path = filepath.Join(dir, conf.CONF_YAML_FILE)
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		return path
+	}
+	return ""
+}
+
+// 重新读取conf.yml并返回新的Config及其原始内容. conf.yml不存在则返回nil. 不改动全局conf, 由调用方在生效后conf.Setup
+func ReloadConfig() (ret *Config, vs map[string]interface{}, err error) {
+	path := ConfPath()
+	if path == "" {
+		return
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err = yaml.Unmarshal([]byte(conf.Escape(string(bs))), &vs); err != nil {
+		return
+	}
+	defer func() {
+		// conf的转换函数遇到非法值会panic
+		if perr := recover(); perr != nil {
+			ret, vs, err = nil, nil, errors.New(fmt.Sprintf("%v", perr))
+		}
+	}()
+	ret = loadConfig(func(key string) (interface{}, bool) {
+		return conf.Elem(vs, key)
+	})
+	return
+}
+
+// 以运行中的配置为基础, 仅替换可热加载的部分. 只解析新读取的插件表达式, 运行中的表达式已解析过, 不能重复展开
+func reloadableConfig(running *Config, loaded *Config) (*Config, error) {
+	ret := *running
+	if loaded != nil {
+		if err := resolveConfigPlugins(loaded); err != nil {
+			return nil, err
+		}
+		ret.Arguments = loaded.Arguments
+		ret.RouterConfig = loaded.RouterConfig
+		ret.ServerConfig = loaded.ServerConfig
+		ret.RouterPlugins = loaded.RouterPlugins
+		ret.AdminPlugins = loaded.AdminPlugins
+	}
+	return &ret, nil
+}
+
+type reloader struct {
+	sync.Mutex
+	running *Config
+	compile func(config *Config) (http.Handler, error)
+	holder  *EngineHolder
+}
+
+func (r *reloader) Reload() {
+	r.Lock()
+	defer r.Unlock()
+	defer log.Flush()
+
+	loaded, vs, err := ReloadConfig()
+	if err != nil {
+		log.Errorf("reload rejected, load conf error: %v", err)
+		return
+	}
+	config, err := reloadableConfig(r.running, loaded)
+	if err != nil {
+		log.Errorf("reload rejected, resolve plugins error: %v", err)
+		return
+	}
+	engine, err := r.compile(config)
+	if err != nil {
+		log.Errorf("reload rejected, http server compile error: %v", err)
+		return
+	}
+	// 编译成功才更新全局conf, 拒绝的加载不影响conf读取方
+	if vs != nil {
+		conf.Setup(vs)
+	}
+	r.holder.Store(engine)
+	r.running = config
+	log.Infof("reload success: %v", ConfPath())
+}
+
+// 轮询conf.yml的修改时间与大小, 变化即触发热加载
+func (r *reloader) Watch(ctx context.Context, period time.Duration) {
+	path := ConfPath()
+	if period <= 0 || path == "" {
+		return
+	}
+	stat := func() (time.Time, int64) {
+		if fi, err := os.Stat(path); err == nil {
+			return fi.ModTime(), fi.Size()
+		}
+		return time.Time{}, 0
+	}
+	mtime, size := stat()
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if t, s := stat(); !t.Equal(mtime) || s != size {
+				mtime, size = t, s
+				r.Reload()
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pbapi
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/obase/conf"
+	"github.com/obase/pbapi/proxy"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pbapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.yml")
+	os.Setenv("CONF_YAML", path)
+	defer os.Unsetenv("CONF_YAML")
+
+	s := NewServer()
+	s.GET("/a", func(ctx *gin.Context) { ctx.String(http.StatusOK, "a") })
+
+	// 运行中的表达式已解析, $为字面值, 热加载不能再次展开
+	running := mergeConfig(&Config{ServerPlugins: [][]string{{"hostsallow", "a$b"}}})
+	engine, err := s.compileHttpEngine(running, &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{
+		running: running,
+		holder:  NewEngineHolder(engine),
+		compile: func(config *Config) (http.Handler, error) {
+			return s.compileHttpEngine(config, &httpRuntime{})
+		},
+	}
+	status := func() int {
+		w := httptest.NewRecorder()
+		r.holder.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a", nil))
+		return w.Code
+	}
+	reload := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r.Reload()
+	}
+
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("before reload: %v", code)
+	}
+	reload("service:\n  routerConfig:\n    - {path: \"/a\", off: true}\n")
+	if code := status(); code != http.StatusNotFound {
+		t.Fatalf("after off: %v", code)
+	}
+	// 非法插件, 拒绝加载并保留旧engine
+	reload("service:\n  routerConfig:\n    - {path: \"/a\", off: false, plugins: [\"none()\"]}\n")
+	if code := status(); code != http.StatusNotFound {
+		t.Fatalf("after rejected: %v", code)
+	}
+	// 拒绝的加载不更新全局conf
+	if v, _ := conf.Get(CKEY + ".routerConfig.0.plugins"); v != nil {
+		t.Fatalf("conf updated by rejected reload: %v", v)
+	}
+	reload("service:\n  routerConfig:\n    - {path: \"/a\", off: false}\n")
+	if code := status(); code != http.StatusOK {
+		t.Fatalf("after on: %v", code)
+	}
+	if v := r.running.ServerPlugins; len(v) != 1 || v[0][1] != "a$b" {
+		t.Fatalf("server plugins changed: %v", v)
+	}
+}
+
+func TestReloadBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pbapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "conf.yml")
+	os.Setenv("CONF_YAML", path)
+	defer os.Unsetenv("CONF_YAML")
+
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	proxies, err := proxy.NewFactory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxies.Close()
+
+	s := NewServer()
+	running := mergeConfig(&Config{
+		AdminPath: "/admin",
+		RouterConfig: []*RouterConfig{{
+			Path:         "/gw",
+			Methods:      []string{http.MethodGet},
+			ProxyPath:    "/x",
+			ProxyTargets: []string{host},
+			ProxyBreaker: &proxy.BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Hour},
+		}},
+	})
+	runtime := &httpRuntime{proxies: proxies, states: new(runtimeStates)}
+	engine, err := s.compileHttpEngine(running, runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &reloader{
+		running: running,
+		holder:  NewEngineHolder(engine),
+		compile: func(config *Config) (http.Handler, error) {
+			return s.compileHttpEngine(config, runtime)
+		},
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.holder.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	reload := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r.Reload()
+	}
+
+	// 首次失败即熔断
+	get("/gw")
+	if w := get("/gw"); w.Code != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expect open breaker: %v %v", w.Code, calls)
+	}
+	// 配置未变的熔断器跨热加载保持打开
+	route := "service:\n  routerConfig:\n    - {path: \"/gw\", methods: [\"GET\"], proxyPath: \"/x\", proxyTargets: [\"" + host + "\"], proxyBreaker: {consecutiveFailures: 1, openTimeout: \"%v\"}}\n"
+	reload(fmt.Sprintf(route, "1h"))
+	if w := get("/gw"); w.Code != http.StatusServiceUnavailable || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("expect open breaker after reload: %v %v", w.Code, calls)
+	}
+	if w := get("/admin/breakers"); !strings.Contains(w.Body.String(), `"state":"open"`) {
+		t.Fatalf("expect open breaker in admin: %s", w.Body.String())
+	}
+	// 修改熔断配置则重建
+	reload(fmt.Sprintf(route, "2h"))
+	if get("/gw"); atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("expect new breaker after config change: %v", calls)
+	}
+}
@@ -3,6 +3,7 @@ package pbapi
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/cache"
 	"net/http"
 )
 
@@ -45,10 +46,14 @@ type FlatNode struct {
 	Method string
 
 	// 补全RouterSetting信息,最后才一并处理
-	Access  bool
-	Off     bool
-	Cache   int64
-	Plugins [][]string
+	Access               bool
+	Off                  bool
+	Cache                int64
+	StaleWhileRevalidate int64
+	StaleIfError         int64
+	CacheKey             *cache.KeySpec
+	MaxRequestBytes      int64
+	Plugins              [][]string
 }
 
 /*
@@ -73,6 +78,25 @@ func newRouter(path string, use gin.HandlersChain) *Router {
 	}
 }
 
+// 浅复制路由树顶层, 便于每次编译时附加service结点而不污染用户注册的路由
+func (r *Router) clone() *Router {
+	ret := &Router{
+		path:    r.path,
+		filters: r.filters,
+		handler: make(map[string]map[string]*Node, len(r.handler)),
+		child:   make([]*Router, len(r.child)),
+	}
+	for method, mnodes := range r.handler {
+		cnodes := make(map[string]*Node, len(mnodes))
+		for path, node := range mnodes {
+			cnodes[path] = node
+		}
+		ret.handler[method] = cnodes
+	}
+	copy(ret.child, r.child)
+	return ret
+}
+
 func (r *Router) Group(path string, use ...gin.HandlerFunc) *Router {
 	sr := newRouter(path, use)
 	r.child = append(r.child, sr)
@@ -6,16 +6,21 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/obase/center"
 	"github.com/obase/log"
 	"github.com/obase/pbapi/access"
 	"github.com/obase/pbapi/cache"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/compress"
+	"github.com/obase/pbapi/metrics"
+	"github.com/obase/pbapi/proxy"
+	"github.com/obase/pbapi/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/keepalive"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"os"
+	"sync"
+	"time"
 )
 
 /*
@@ -34,29 +39,49 @@ import (
 
 func NewServer() *Server {
 	server := &Server{
-		Router:        newRouter("", nil),
-		routerPlugins: make(map[string]RouterPlugin),
-		serverPlugins: make(map[string]ServerPlugin),
+		Router:             newRouter("", nil),
+		routerPlugins:      make(map[string]RouterPlugin),
+		serverPlugins:      make(map[string]ServerPlugin),
+		interceptorPlugins: make(map[string]InterceptorPlugin),
+		scopedPlugins:      make(map[string]scopedRouterPlugin),
+		responseEncoders:   make(map[string]ResponseEncoder),
 	}
 
-	// 默认加载的的ServerPlugins
-	server.serverPlugins["hostsallow"] = HostsallowServerPlugin
+	// 默认加载的的InterceptorPlugins
+	server.interceptorPlugins["hostsallow"] = HostsallowInterceptorPlugin
+	server.interceptorPlugins["ratelimit"] = RatelimitServerPlugin
+	server.interceptorPlugins["jwt"] = JwtServerPlugin
 	// 默认加载的FilterPlugins
 	server.routerPlugins["hostsallow"] = HostsallowRouterPlugin
+	server.routerPlugins["ratelimit"] = RatelimitRouterPlugin
+	server.routerPlugins["jwt"] = JwtRouterPlugin
+	server.routerPlugins[CORS_PLUGIN] = CorsRouterPlugin
+	// 需按路由保留状态的内置插件, 优先于同名routerPlugins
+	server.scopedPlugins["ratelimit"] = ratelimitRouterPlugin
+	// 默认加载的ResponseEncoders
+	server.responseEncoders[ENCODER_ENVELOPE] = EnvelopeEncoder
+	server.responseEncoders[ENCODER_STATUS] = StatusEncoder
+	server.responseEncoders[ENCODER_RAW] = RawEncoder
+	server.responseEncoders[ENCODER_PROBLEM] = ProblemEncoder
 
 	return server
 }
 
 type Server struct {
-	*Router                                 // 用于兼容gin.IRouter实现, 其中Router.use()设置的filter适用全部入口
-	httpRouterCK    []func(*Router)         // http router回调, 兼容旧API, 可以使用router自身API替换
-	grpcServerCK    []func(*grpc.Server)    // grpc server回调, 对外扩展
-	routerPlugins   map[string]RouterPlugin // 入口过滤器插件机制, 一般仅用于conf.yml的httpRules/plugins设置
-	serverPlugins   map[string]ServerPlugin
-	routerOptions   []RouterOption
-	serverOptions   []grpc.ServerOption
-	serviceOptions  []ServiceOption // 默认设置
-	serviceHandlers []*ServiceHandler
+	*Router                                    // 用于兼容gin.IRouter实现, 其中Router.use()设置的filter适用全部入口
+	httpRouterCK       []func(*Router)         // http router回调, 兼容旧API, 可以使用router自身API替换
+	grpcServerCK       []func(*grpc.Server)    // grpc server回调, 对外扩展
+	routerPlugins      map[string]RouterPlugin // 入口过滤器插件机制, 一般仅用于conf.yml的httpRules/plugins设置
+	serverPlugins      map[string]ServerPlugin // 与interceptorPlugins共用conf.yml的serverPlugins名称空间
+	interceptorPlugins map[string]InterceptorPlugin
+	scopedPlugins      map[string]scopedRouterPlugin // 内置插件的按路由保留状态版本, RouterPlugin()同名覆盖时移除
+	routerOptions      []RouterOption
+	serverOptions      []grpc.ServerOption
+	serviceOptions     []ServiceOption // 默认设置
+	serviceHandlers    []*ServiceHandler
+	panicHook          PanicHook // panic上报钩子, 可选
+	responseEncoders   map[string]ResponseEncoder
+	httpCache          cache.Cache // http服务启动后设置, 供PurgeCache等主动删除
 }
 
 // 重置全部属性,避免占用内存
@@ -65,6 +90,9 @@ func (server *Server) dispose() {
 	server.httpRouterCK = nil
 	server.grpcServerCK = nil
 	server.routerPlugins = nil
+	server.serverPlugins = nil
+	server.interceptorPlugins = nil
+	server.scopedPlugins = nil
 	server.serverOptions = nil
 	server.serviceOptions = nil
 	server.serviceHandlers = nil
@@ -87,6 +115,15 @@ func defaultServiceSetting(sh *ServiceHandler) *ServiceSetting {
 			WbskPath: path,
 		}
 	}
+	// 流式方法默认开启SSE(仅server-streaming), websocket需要配置
+	for k, _ := range sh.Streams {
+		path := DefaultPathGenerator(sh.PackageName, sh.ServiceName, k)
+		s.Methods[k] = &MethodSetting{
+			WbskOff:  true,
+			WbskPath: path,
+			SsePath:  path,
+		}
+	}
 	return s
 }
 
@@ -132,20 +169,44 @@ func (server *Server) RouterOption(options ...RouterOption) {
 
 func (server *Server) RouterPlugin(name string, rf RouterPlugin) {
 	server.routerPlugins[name] = rf
+	delete(server.scopedPlugins, name)
 }
 
 func (server *Server) ServerPlugin(name string, rf ServerPlugin) {
 	server.serverPlugins[name] = rf
 }
 
+func (server *Server) InterceptorPlugin(name string, rf InterceptorPlugin) {
+	server.interceptorPlugins[name] = rf
+}
+
+func (server *Server) ResponseEncoder(name string, enc ResponseEncoder) {
+	server.responseEncoders[name] = enc
+}
+
+// 设置panic上报钩子, 在日志与指标之后调用
+func (server *Server) PanicHook(hook PanicHook) {
+	server.panicHook = hook
+}
+
 func (server *Server) RegisterService(handler RegisterServiceHandler, service interface{}, options ...ServiceOption) {
 	sdesc, pname, sname, adapters := handler(service)
+	// 生成代码提供的方法改由框架解码
+	codecs := make(map[string]bool)
+	for mname, adapt := range typedAdapters(sdesc, service) {
+		if _, ok := adapters[mname]; ok {
+			adapters[mname] = adapt
+			codecs[mname] = true
+		}
+	}
 	server.serviceHandlers = append(server.serviceHandlers, &ServiceHandler{
 		ServiceDesc: sdesc,
 		ServiceImpl: service,
 		PackageName: pname,
 		ServiceName: sname,
 		Adapters:    adapters,
+		Streams:     streamAdapters(sdesc, service),
+		Codecs:      codecs,
 		Options:     options,
 	})
 }
@@ -157,6 +218,11 @@ func (server *Server) Serve() error {
 func (server *Server) ServeWith(config *Config) error {
 
 	config = mergeConfig(config)
+	if err := resolveConfigPlugins(config); err != nil {
+		log.Errorf("resolve plugins error: %v", err)
+		log.Flush()
+		return err
+	}
 
 	// 没有配置任何启动,直接退出. 注意: 没有默认80之类的设置
 	if config.GrpcPort == 0 && config.HttpPort == 0 {
@@ -169,12 +235,15 @@ func (server *Server) ServeWith(config *Config) error {
 		httpServer   *http.Server
 		httpListener net.Listener
 		httpCache    cache.Cache
+		httpProxies  *proxy.Factory
 		cancelfun    context.CancelFunc
 		accesslog    *log.Logger
 		err          error
 		grpcfunc     func() // 用于延迟启动
 		httpfunc     func() // 用于延迟启动
-
+		httpReloader *reloader
+		srvMetrics   *metrics.Metrics
+		srvTracer    *trace.Tracer
 	)
 
 	runctx, cancelfun := context.WithCancel(context.Background())
@@ -194,56 +263,56 @@ func (server *Server) ServeWith(config *Config) error {
 		if httpCache != nil {
 			httpCache.Close()
 		}
+		if httpProxies != nil {
+			httpProxies.Close()
+		}
 		if cancelfun != nil {
 			cancelfun()
 		}
 		if accesslog != nil {
 			accesslog.Close()
 		}
+		if srvTracer != nil {
+			srvTracer.Close()
+		}
 	}()
 
 	// 计算setting
 	for _, handler := range server.serviceHandlers {
-		// 生成默认
-		ss := defaultServiceSetting(handler)
-		// merge全局
-		for _, so := range server.serviceOptions {
-			so(ss)
-		}
-		// merge局部
-		for _, so := range handler.Options {
-			so(ss)
-		}
-		// merge配置
-		for _, so := range MergeServerConfig(config.ServerConfig) {
-			so(ss)
-		}
-		handler.setting = ss
+		handler.setting = server.serviceSetting(handler, config)
+	}
+
+	// 指标统计, http与grpc共用
+	srvMetrics = metrics.New(config.Metrics)
+	// 链路追踪, http与grpc共用
+	srvTracer, err = trace.NewTracer(config.Trace)
+	if err != nil {
+		log.Errorf("create tracer error: %v", err)
+		return err
+	}
+	// access log, http与grpc共用
+	accesslog, err = access.NewLogger(runctx, config.Accesslog)
+	if err != nil {
+		log.Errorf("create access logger error: %v", err)
+		return err
+	}
+	srvAccess, err := access.New(accesslog, config.Accesslog)
+	if err != nil {
+		log.Errorf("create access logger error: %v", err)
+		return err
 	}
 
 	// 创建grpc服务器
 	if config.GrpcPort > 0 {
 
-		var serverOptions []grpc.ServerOption
-		if len(config.ServerPlugins) > 0 {
-			for _, v := range config.ServerPlugins {
-				if len(v) > 0 {
-					if plugin := server.serverPlugins[v[0]]; plugin != nil {
-						if option := plugin(v[1:]); option != nil {
-							serverOptions = append(serverOptions, option)
-						}
-					} else {
-						return errors.New(fmt.Sprintf("invalid server plugin: %v", v))
-					}
-				}
-			}
-		}
-		if len(server.serverOptions) > 0 {
-			for _, option := range server.serverOptions {
-				if option != nil {
-					serverOptions = append(serverOptions, option)
-				}
-			}
+		serverOptions, err := server.compileServerOptions(config, &grpcRuntime{
+			metrics:   srvMetrics,
+			tracer:    srvTracer,
+			accesslog: srvAccess,
+		})
+		if err != nil {
+			log.Errorf("grpc server compile error: %v", err)
+			return err
 		}
 		// 设置keepalive超时
 		if config.GrpcKeepAlive != 0 {
@@ -286,92 +355,26 @@ func (server *Server) ServeWith(config *Config) error {
 	// 创建http服务器
 	if config.HttpPort > 0 {
 
-		// 安装http相关配置
-		var upgrader *websocket.Upgrader
-		for _, handler := range server.serviceHandlers {
-			for mname, adapt := range handler.Adapters {
-				ms := handler.setting.Methods[mname]
-				// for http
-				if ms == nil || !ms.HttpOff {
-					// 确保plugins优先filter
-					var filter gin.HandlersChain
-					if len(ms.HttpPlugins) > 0 {
-						for _, v := range ms.HttpPlugins {
-							if len(v) > 0 {
-								plugin := server.routerPlugins[v[0]]
-								if plugin != nil {
-									for _, f := range plugin(v[1:]) {
-										if f != nil {
-											filter = append(filter, f)
-										}
-									}
-								} else {
-									return errors.New(fmt.Sprintf("invalid router plugin: %v", v))
-								}
-							}
-						}
-					}
-					if len(ms.HttpFilter) > 0 {
-						filter = append(filter, ms.HttpFilter...)
-					}
-					server.Router.handle(MethodPost, ms.HttpPath, &Node{
-						PackageName: handler.PackageName,
-						ServiceName: handler.ServiceName,
-						MethodName:  mname,
-						Filter:      filter,
-						Handler:     CreateHandlerFunc4Http(handler.ServiceName+"."+mname, adapt),
-					})
-				}
-				// for wbsk
-				if ms == nil || !ms.WbskOff {
-					// http get实现websocket
-					if upgrader == nil {
-						upgrader = CreateWebsocketUpgrader(config)
-					}
-					// 确保plugins优先filter
-					var filter gin.HandlersChain
-					if len(ms.WbskPlugins) > 0 {
-						for _, v := range ms.WbskPlugins {
-							if len(v) > 0 {
-								plugin := server.routerPlugins[v[0]]
-								if plugin != nil {
-									for _, f := range plugin(v[1:]) {
-										if f != nil {
-											filter = append(filter, f)
-										}
-									}
-								} else {
-									return errors.New(fmt.Sprintf("invalid router plugin: %v", v))
-								}
-							}
-						}
-					}
-					if len(ms.WbskFilter) > 0 {
-						filter = append(filter, ms.WbskFilter...)
-					}
-
-					server.Router.handle(MethodGet, ms.WbskPath, &Node{
-						PackageName: handler.PackageName,
-						ServiceName: handler.ServiceName,
-						MethodName:  mname,
-						Filter:      filter,
-						Handler:     CreateHandlerFunc4Wbsk(handler.ServiceName+"."+mname, upgrader, adapt),
-					})
-				}
-			}
-		}
-
-		for _, ck := range server.httpRouterCK {
-			ck(server.Router)
-		}
 		httpCache = cache.New(config.Cache)
-		accesslog, err = access.NewLogger(runctx, config.Accesslog)
+		server.httpCache = httpCache
+		httpProxies, err = proxy.NewFactory(config.Httpx)
 		if err != nil {
-			log.Errorf("create access logger error: %v", err)
+			log.Errorf("create http proxy error: %v", err)
 			return err
 		}
+		if srvMetrics != nil {
+			srvMetrics.RegisterCache(httpCache)
+		}
+		runtime := &httpRuntime{
+			cache:     httpCache,
+			accesslog: srvAccess,
+			proxies:   httpProxies,
+			metrics:   srvMetrics,
+			tracer:    srvTracer,
+			states:    new(runtimeStates),
+		}
 		// 核心转换生成ServeMux
-		engine, err := server.compileRouterEngine(config, httpCache, access.NewHandlerFunc(accesslog))
+		engine, err := server.compileHttpEngine(config, runtime)
 		if err != nil {
 			log.Errorf("http server compile error: %v", err)
 			return err
@@ -381,8 +384,29 @@ func (server *Server) ServeWith(config *Config) error {
 			registerServiceHttp(engine, config)
 		}
 
+		holder := NewEngineHolder(engine)
+		httpReloader = &reloader{
+			running: config,
+			holder:  holder,
+			compile: func(config *Config) (http.Handler, error) {
+				engine, err := server.compileHttpEngine(config, runtime)
+				if err != nil {
+					return nil, err
+				}
+				if config.Name != "" {
+					engine.GET(HTTP_HEALTH_PATH, CheckHttpHealth)
+				}
+				return engine, nil
+			},
+		}
+		go httpReloader.Watch(runctx, config.ReloadPeriod)
+
 		httpServer = &http.Server{
-			Handler: engine,
+			Handler:           holder,
+			ReadTimeout:       config.HttpReadTimeout,
+			ReadHeaderTimeout: config.HttpReadHeaderTimeout,
+			WriteTimeout:      config.HttpWriteTimeout,
+			IdleTimeout:       config.HttpIdleTimeout,
 		}
 		// 创建监听端口
 		httpListener, err = graceListenHttp(config.HttpHost, config.HttpPort, config.HttpKeepAlive)
@@ -409,8 +433,10 @@ func (server *Server) ServeWith(config *Config) error {
 			}
 		}
 	}
-	// 释放无用缓存
-	server.dispose()
+	// 释放无用缓存, 热加载需要保留全部注册信息
+	if httpReloader == nil {
+		server.dispose()
+	}
 
 	// 延迟启动
 	if grpcfunc != nil {
@@ -420,18 +446,364 @@ func (server *Server) ServeWith(config *Config) error {
 		go httpfunc()
 	}
 	// 优雅关闭http与grpc服务
-	graceShutdownOrRestart(grpcServer, grpcListener, httpServer, httpListener)
+	var reload func()
+	if httpReloader != nil {
+		reload = httpReloader.Reload
+	}
+	graceShutdownOrRestart(grpcServer, grpcListener, httpServer, httpListener, reload)
 
 	return nil
 }
 
-func (server *Server) compileRouterEngine(config *Config, cache cache.Cache, accesslog gin.HandlerFunc) (*gin.Engine, error) {
+func (server *Server) serviceSetting(handler *ServiceHandler, config *Config) *ServiceSetting {
+	// 生成默认
+	ss := defaultServiceSetting(handler)
+	// merge全局
+	for _, so := range server.serviceOptions {
+		so(ss)
+	}
+	// merge局部
+	for _, so := range handler.Options {
+		so(ss)
+	}
+	// merge配置
+	for _, so := range MergeServerConfig(config.ServerConfig) {
+		so(ss)
+	}
+	return ss
+}
+
+// http服务运行期共享的组件, 热加载重新编译时沿用
+type httpRuntime struct {
+	cache     cache.Cache
+	accesslog *access.Access
+	proxies   *proxy.Factory
+	metrics   *metrics.Metrics
+	tracer    *trace.Tracer
+	states    *runtimeStates
+}
+
+/*
+跨编译(热加载)沿用的运行期状态, 例如限流令牌桶与代理熔断器, 按路由与配置区分.
+编译成功后只保留本次用到的键, 已删除或修改的路由随之清理; 编译失败则丢弃本次新建的状态
+*/
+type runtimeStates struct {
+	sync.Mutex
+	cur  map[string]interface{}
+	next map[string]interface{}
+}
+
+// 为nil时不保留状态, 每次新建
+func (s *runtimeStates) get(key string, create func() interface{}) interface{} {
+	if s == nil {
+		return create()
+	}
+	s.Lock()
+	defer s.Unlock()
+	if v, ok := s.next[key]; ok {
+		return v
+	}
+	v, ok := s.cur[key]
+	if !ok {
+		v = create()
+	}
+	if s.next == nil {
+		s.next = make(map[string]interface{})
+	}
+	s.next[key] = v
+	return v
+}
+
+func (s *runtimeStates) done(ok bool) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if ok {
+		s.cur = s.next
+	}
+	s.next = nil
+	s.Unlock()
+}
+
+// 按路由保留状态的路由插件, scope为结点路径(全局插件为空)
+type scopedRouterPlugin func(states *runtimeStates, scope string, args []string) gin.HandlersChain
+
+// grpc服务内置的拦截器组件
+type grpcRuntime struct {
+	metrics   *metrics.Metrics
+	tracer    *trace.Tracer
+	accesslog *access.Access
+}
+
+/*
+基于server.Router的副本附加service结点再编译, 确保可以反复执行(热加载).
+注意: grpc部分仍以启动时的handler.setting为准
+*/
+func (server *Server) compileHttpEngine(config *Config, runtime *httpRuntime) (engine *gin.Engine, err error) {
+
+	defer func() {
+		// Router.handle()遇到冲突路径会panic, 热加载时不能因此退出
+		if perr := recover(); perr != nil {
+			engine, err = nil, errors.New(fmt.Sprintf("%v", perr))
+		}
+		runtime.states.done(err == nil)
+	}()
+
+	root := server.Router.clone()
+
+	// 安装http相关配置
+	var upgrader *websocket.Upgrader
+	rec := &recovery{metrics: runtime.metrics, hook: server.panicHook}
+	for _, handler := range server.serviceHandlers {
+		setting := server.serviceSetting(handler, config)
+		for mname, adapt := range handler.Adapters {
+			ms := setting.Methods[mname]
+			maxRequestBytes := config.MaxRequestBytes
+			if ms != nil && ms.MaxRequestBytes != 0 {
+				maxRequestBytes = ms.MaxRequestBytes
+			}
+			tag := handler.ServiceName + "." + mname
+			timeout := methodTimeout(config, ms)
+			encoderName := config.ResponseEncoder
+			if ms != nil && ms.ResponseEncoder != "" {
+				encoderName = ms.ResponseEncoder
+			}
+			if encoderName == "" {
+				encoderName = ENCODER_ENVELOPE
+			}
+			encoder := server.responseEncoders[encoderName]
+			if encoder == nil {
+				return nil, errors.New(fmt.Sprintf("invalid response encoder: %v", encoderName))
+			}
+			codecConfig := config.Codec
+			if ms != nil && ms.Codec != nil {
+				codecConfig = ms.Codec
+			}
+			cd, err := codec.New(codecConfig)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%v: %v", tag, err))
+			}
+			// for http
+			if ms == nil || !ms.HttpOff {
+				// 确保plugins优先filter
+				filter, err := server.compileRouterPlugins(ms.HttpPlugins, ms.HttpPath, runtime.states, nil)
+				if err != nil {
+					return nil, err
+				}
+				if len(ms.HttpFilter) > 0 {
+					filter = append(filter, ms.HttpFilter...)
+				}
+				if config.Negotiation {
+					filter = append(gin.HandlersChain{newNegotiateHandlerFunc(tag, cd, handler.Codecs[mname])}, filter...)
+				} else if cd != codec.Default {
+					filter = append(gin.HandlersChain{newCodecHandlerFunc(cd)}, filter...)
+				}
+				// 限制请求体先于plugins与cache
+				if maxRequestBytes > 0 {
+					filter = append(gin.HandlersChain{newBodyLimitHandlerFunc(tag, maxRequestBytes)}, filter...)
+				}
+				root.handle(MethodPost, ms.HttpPath, &Node{
+					PackageName: handler.PackageName,
+					ServiceName: handler.ServiceName,
+					MethodName:  mname,
+					Filter:      filter,
+					Handler:     CreateEncodedHandlerFunc4Http(tag, encoder, WithTimeout(tag, timeout, rec.Adapter(PANIC_HTTP, tag, adapt))),
+				})
+			}
+			// for wbsk
+			if ms == nil || !ms.WbskOff {
+				// http get实现websocket
+				if upgrader == nil {
+					upgrader = CreateWebsocketUpgrader(config)
+				}
+				// 确保plugins优先filter
+				filter, err := server.compileRouterPlugins(ms.WbskPlugins, ms.WbskPath, runtime.states, nil)
+				if err != nil {
+					return nil, err
+				}
+				if len(ms.WbskFilter) > 0 {
+					filter = append(filter, ms.WbskFilter...)
+				}
+				if cd != codec.Default {
+					filter = append(gin.HandlersChain{newCodecHandlerFunc(cd)}, filter...)
+				}
+				// 每条消息的access log, 先于plugins注入观察者
+				if ms.WbskAccess && runtime.accesslog != nil {
+					filter = append(gin.HandlersChain{newWbskAccessHandlerFunc(runtime.accesslog, tag)}, filter...)
+				}
+
+				root.handle(MethodGet, ms.WbskPath, &Node{
+					PackageName: handler.PackageName,
+					ServiceName: handler.ServiceName,
+					MethodName:  mname,
+					Filter:      filter,
+					Handler:     CreateLimitedHandlerFunc4Wbsk(tag, upgrader, maxRequestBytes, config.WbskReadTimeout, WithTimeout(tag, timeout, rec.Adapter(PANIC_WBSK, tag, adapt))),
+				})
+			}
+		}
+		// 流式方法: 不受timeout及responseEncoder影响
+		for mname, sa := range handler.Streams {
+			ms := setting.Methods[mname]
+			tag := handler.ServiceName + "." + mname
+			maxRequestBytes := config.MaxRequestBytes
+			if ms.MaxRequestBytes != 0 {
+				maxRequestBytes = ms.MaxRequestBytes
+			}
+			codecConfig := config.Codec
+			if ms.Codec != nil {
+				codecConfig = ms.Codec
+			}
+			cd, err := codec.New(codecConfig)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%v: %v", tag, err))
+			}
+			sse := sa.ServerStreams && !sa.ClientStreams && !ms.SseOff
+			if sse && !ms.WbskOff && ms.SsePath == ms.WbskPath {
+				return nil, errors.New(fmt.Sprintf("%v: sse and websocket share path %v, set ssePath or wbskPath", tag, ms.SsePath))
+			}
+			if sse {
+				filter, err := server.compileRouterPlugins(ms.HttpPlugins, ms.SsePath, runtime.states, nil)
+				if err != nil {
+					return nil, err
+				}
+				filter = append(filter, ms.HttpFilter...)
+				if cd != codec.Default {
+					filter = append(gin.HandlersChain{newCodecHandlerFunc(cd)}, filter...)
+				}
+				root.handle(MethodGet, ms.SsePath, &Node{
+					PackageName: handler.PackageName,
+					ServiceName: handler.ServiceName,
+					MethodName:  mname,
+					Filter:      filter,
+					Handler:     CreateLimitedHandlerFunc4Sse(tag, maxRequestBytes, rec.Stream(PANIC_HTTP, tag, sa.Handler)),
+				})
+			}
+			if !ms.WbskOff {
+				if upgrader == nil {
+					upgrader = CreateWebsocketUpgrader(config)
+				}
+				filter, err := server.compileRouterPlugins(ms.WbskPlugins, ms.WbskPath, runtime.states, nil)
+				if err != nil {
+					return nil, err
+				}
+				filter = append(filter, ms.WbskFilter...)
+				if cd != codec.Default {
+					filter = append(gin.HandlersChain{newCodecHandlerFunc(cd)}, filter...)
+				}
+				root.handle(MethodGet, ms.WbskPath, &Node{
+					PackageName: handler.PackageName,
+					ServiceName: handler.ServiceName,
+					MethodName:  mname,
+					Filter:      filter,
+					Handler:     CreateStreamHandlerFunc4Wbsk(tag, upgrader, maxRequestBytes, config.WbskReadTimeout, sa.ClientStreams, rec.Stream(PANIC_WBSK, tag, sa.Handler)),
+				})
+			}
+		}
+	}
+
+	for _, ck := range server.httpRouterCK {
+		ck(root)
+	}
+
+	return server.compileRouterEngine(root, config, runtime)
+}
+
+// 次序: conf.ServerPlugins -> server.serverOptions, 所有拦截器(内置在前, 插件在后)最后串联为一个ServerOption
+func (server *Server) compileServerOptions(config *Config, runtime *grpcRuntime) ([]grpc.ServerOption, error) {
+	var (
+		serverOptions []grpc.ServerOption
+		unarys        []grpc.UnaryServerInterceptor
+		streams       []grpc.StreamServerInterceptor
+	)
+	// recovery位于最外层, 其余拦截器及插件的panic同样恢复
+	rec := &recovery{metrics: runtime.metrics, hook: server.panicHook}
+	unarys = append(unarys, rec.UnaryServerInterceptor())
+	streams = append(streams, rec.StreamServerInterceptor())
+	if runtime.metrics != nil {
+		unarys = append(unarys, runtime.metrics.UnaryServerInterceptor())
+		streams = append(streams, runtime.metrics.StreamServerInterceptor())
+	}
+	if runtime.tracer != nil {
+		unarys = append(unarys, runtime.tracer.UnaryServerInterceptor())
+		streams = append(streams, runtime.tracer.StreamServerInterceptor())
+	}
+	if runtime.accesslog != nil {
+		// 按ServerConfig的grpcAccess开启, 以FullMethod索引
+		methods := make(map[string]bool)
+		for _, handler := range server.serviceHandlers {
+			if handler.setting == nil {
+				continue
+			}
+			for mname, ms := range handler.setting.Methods {
+				if ms.GrpcAccess {
+					methods["/"+handler.ServiceDesc.ServiceName+"/"+mname] = true
+				}
+			}
+		}
+		if len(methods) > 0 {
+			enabled := func(fullMethod string) bool {
+				return methods[fullMethod]
+			}
+			unarys = append(unarys, runtime.accesslog.UnaryServerInterceptor(enabled))
+			streams = append(streams, runtime.accesslog.StreamServerInterceptor(enabled))
+		}
+	}
+	// 按ServerConfig的timeout设置unary方法执行期限, 同样仅启动时生效
+	timeouts := make(map[string]time.Duration)
+	for _, handler := range server.serviceHandlers {
+		if handler.setting == nil {
+			continue
+		}
+		for mname, ms := range handler.setting.Methods {
+			if d := methodTimeout(config, ms); d > 0 {
+				timeouts["/"+handler.ServiceDesc.ServiceName+"/"+mname] = d
+			}
+		}
+	}
+	if len(timeouts) > 0 {
+		unarys = append(unarys, timeoutUnaryServerInterceptor(timeouts))
+	}
+	for _, v := range config.ServerPlugins {
+		if len(v) > 0 {
+			if plugin := server.serverPlugins[v[0]]; plugin != nil {
+				if option := plugin(v[1:]); option != nil {
+					serverOptions = append(serverOptions, option)
+				}
+			} else if plugin := server.interceptorPlugins[v[0]]; plugin != nil {
+				unary, stream := plugin(v[1:])
+				if unary != nil {
+					unarys = append(unarys, unary)
+				}
+				if stream != nil {
+					streams = append(streams, stream)
+				}
+			} else {
+				return nil, errors.New(fmt.Sprintf("invalid server plugin: %v", v))
+			}
+		}
+	}
+	for _, option := range server.serverOptions {
+		if option != nil {
+			serverOptions = append(serverOptions, option)
+		}
+	}
+	if len(unarys) > 0 {
+		serverOptions = append(serverOptions, grpc.ChainUnaryInterceptor(unarys...))
+	}
+	if len(streams) > 0 {
+		serverOptions = append(serverOptions, grpc.ChainStreamInterceptor(streams...))
+	}
+	return serverOptions, nil
+}
+
+func (server *Server) compileRouterEngine(root *Router, config *Config, runtime *httpRuntime) (*gin.Engine, error) {
 
 	// 确保conf.yml的routerConfig可以覆盖server.routerOptions
 	var routerOptions = MergeRouterConfig(config.RouterConfig)
 
 	// 遍历所有结点,处理plugins/cache/access/off, 但不包括proxy. 因为它涉及替换与新加
-	var flatnodes = server.Router.Flattern()
+	var flatnodes = root.Flattern()
 
 	// 第1步处理非proxy的结点
 	for _, node := range flatnodes {
@@ -454,18 +826,66 @@ func (server *Server) compileRouterEngine(config *Config, cache cache.Cache, acc
 		} else {
 			node.Plugins = rs.Plugins
 			node.Cache = rs.Cache
+			node.StaleWhileRevalidate = rs.StaleWhileRevalidate
+			node.StaleIfError = rs.StaleIfError
+			node.CacheKey = rs.CacheKey
+			node.MaxRequestBytes = rs.MaxRequestBytes
 			node.Access = rs.Access
 		}
 	}
 
 	// 第2步附加需proxy的结点
+	var breakers []*proxy.Breaker
 	for _, rc := range config.RouterConfig {
 		// 设置了代理并且没有关闭
 		if rc.ProxyPath != "" && !rc.Off {
+			// 外部代理按路由创建一次, 各method共用以便负载统计
+			var handler gin.HandlerFunc
+			if rc.ProxyService != "" || len(rc.ProxyTargets) > 0 {
+				if runtime.proxies == nil {
+					return nil, errors.New(fmt.Sprintf("proxy not supported: %v", rc.Path))
+				}
+				target := &proxy.Target{
+					Service:      rc.ProxyService,
+					Targets:      rc.ProxyTargets,
+					Path:         rc.ProxyPath,
+					Https:        rc.ProxyHttps,
+					Policy:       rc.ProxyPolicy,
+					CaFile:       rc.ProxyCaFile,
+					CertFile:     rc.ProxyCertFile,
+					KeyFile:      rc.ProxyKeyFile,
+					Retries:      rc.ProxyRetries,
+					RetryBackoff: rc.ProxyRetryBackoff,
+					TryTimeout:   rc.ProxyTryTimeout,
+				}
+				if rc.MaxRequestBytes > 0 {
+					target.MaxRetryBytes = rc.MaxRequestBytes
+				} else if config.MaxRequestBytes > 0 {
+					target.MaxRetryBytes = config.MaxRequestBytes
+				}
+				if rc.ProxyBreaker != nil {
+					// 熔断状态跨热加载保留, 配置变化才重建
+					bc := *rc.ProxyBreaker
+					target.Breaker = runtime.states.get(fmt.Sprintf("breaker:%v %+v", rc.Path, bc), func() interface{} {
+						return proxy.NewBreaker(rc.Path, &bc)
+					}).(*proxy.Breaker)
+					target.Fallback = createFallbackHandler(rc.ProxyFallback)
+					breakers = append(breakers, target.Breaker)
+				}
+				rp, err := runtime.proxies.New(target)
+				if err != nil {
+					return nil, errors.New(fmt.Sprintf("invalid proxy %v: %v", rc.Path, err))
+				}
+				handler = func(ctx *gin.Context) {
+					if upstream := rp.Forward(ctx.Writer, ctx.Request); upstream != "" {
+						ctx.Set(proxy.CONTEXT_KEY, upstream)
+					}
+				}
+			}
 			// 必须先剔除已经禁用的方法
 			for _, method := range rc.Methods {
 				var node *FlatNode
-				if rc.ProxyService == "" {
+				if handler == nil {
 					node = cloneProxyTarget(flatnodes, rc.ProxyPath, method)
 					if node == nil {
 						return nil, errors.New(fmt.Sprintf("invalid proxy target %v", rc.ProxyPath))
@@ -475,23 +895,18 @@ func (server *Server) compileRouterEngine(config *Config, cache cache.Cache, acc
 					node.Method = method
 				} else {
 					// 外部代理
-					var proxy *httputil.ReverseProxy
-					if rc.ProxyHttps {
-						proxy = center.HttpProxyHandler(rc.ProxyService, rc.ProxyPath)
-					} else {
-						proxy = center.HttpProxyHandler(rc.ProxyService, rc.ProxyPath)
-					}
-
 					node = &FlatNode{
-						Path:   rc.Path,
-						Method: method,
-						Handler: func(ctx *gin.Context) {
-							proxy.ServeHTTP(ctx.Writer, ctx.Request)
-						},
+						Path:    rc.Path,
+						Method:  method,
+						Handler: handler,
 					}
 				}
 				node.Plugins = rc.Plugins
 				node.Cache = rc.Cache
+				node.StaleWhileRevalidate = rc.StaleWhileRevalidate
+				node.StaleIfError = rc.StaleIfError
+				node.CacheKey = rc.CacheKey
+				node.MaxRequestBytes = rc.MaxRequestBytes
 				node.Access = rc.Access
 
 				flatnodes = append(flatnodes, node)
@@ -502,41 +917,94 @@ func (server *Server) compileRouterEngine(config *Config, cache cache.Cache, acc
 	// 至此,floatnode包含了所有结点(包括off),对称转换为engine的相关操作
 	gin.SetMode(gin.ReleaseMode) // 线上应该设置为release模式
 	engine := gin.New()
+	// 最外层恢复panic, 先于所有路由注册
+	engine.Use((&recovery{metrics: runtime.metrics, hook: server.panicHook}).HandlerFunc())
+	// 压缩位于cache等之外, 缓存未压缩内容, 按各请求协商结果压缩
+	compressor, err := compress.New(config.Compress)
+	if err != nil {
+		return nil, err
+	}
+	if compressor != nil {
+		engine.Use(compressor.HandlerFunc(rejectCompress))
+	}
 
-	var routerFilter gin.HandlersChain
-	for _, v := range config.RouterPlugins {
-		if len(v) > 0 {
-			plugin := server.routerPlugins[v[0]]
-			if plugin != nil {
-				for _, f := range plugin(v[1:]) {
-					if f != nil {
-						routerFilter = append(routerFilter, f)
-					}
-				}
-			} else {
-				return nil, errors.New(fmt.Sprintf("invalid router plugin: %v", v))
-			}
+	// 全局插件只编译一次, 各结点共享同一份状态(例如ratelimit的令牌桶)
+	globalPlugins, err := server.compileRouterPluginList(config.RouterPlugins, "", runtime.states)
+	if err != nil {
+		return nil, err
+	}
+	routerFilter := filterPlugins(globalPlugins, nil)
+	// 结点配置了cors时替换全局cors
+	notCors := func(name string) bool {
+		return name != CORS_PLUGIN
+	}
+	isCors := func(name string) bool {
+		return name == CORS_PLUGIN
+	}
+	globalFilterNoCors := filterPlugins(globalPlugins, notCors)
+	globalCors := hasPlugin(config.RouterPlugins, CORS_PLUGIN)
+
+	if config.AdminPath != "" || runtime.metrics != nil {
+		if err := server.registerAdmin(engine, config, routerFilter, breakers, runtime); err != nil {
+			return nil, err
 		}
 	}
 
+	globalFilter := routerFilter
+	// 需自动注册OPTIONS预检的路径及其处理链
+	preflights := make(map[string]gin.HandlersChain)
+	var preflightPaths []string
+	options := make(map[string]bool)
 	for _, node := range flatnodes {
 		if node.Off {
 			continue
 		}
+		accesslog := runtime.accesslog.HandlerFunc(&access.Node{
+			Package: node.PackageName,
+			Service: node.ServiceName,
+			Method:  node.MethodName,
+			Path:    node.Path,
+		})
+		// 指标统计与链路追踪置于routerPlugins之前, 被拦截的请求同样记录
+		var routerFilter gin.HandlersChain
+		if runtime.metrics != nil {
+			routerFilter = append(routerFilter, newMetricsHandlerFunc(runtime.metrics, node))
+		}
+		if runtime.tracer != nil {
+			routerFilter = append(routerFilter, newTraceHandlerFunc(runtime.tracer, node))
+		}
+		// 限制请求体先于plugins, cache与proxy. 服务接口已按方法限制, 其余路由沿用全局
+		maxRequestBytes := node.MaxRequestBytes
+		if maxRequestBytes == 0 && node.ServiceName == "" {
+			maxRequestBytes = config.MaxRequestBytes
+		}
+		if maxRequestBytes > 0 && !isStaticMethod(node.Method) {
+			routerFilter = append(routerFilter, newBodyLimitHandlerFunc(node.Path, maxRequestBytes))
+		}
+		nodeCors := hasPlugin(node.Plugins, CORS_PLUGIN)
+		if nodeCors {
+			routerFilter = append(routerFilter, globalFilterNoCors...)
+		} else {
+			routerFilter = append(routerFilter, globalFilter...)
+		}
 		/*相关filter次序: config.routerPlugins > flatnode.plugins(来自routerConfig) > flatnode.filter(来自Service或者Router)*/
-		var nodeFilter gin.HandlersChain
-		for _, v := range node.Plugins {
-			if len(v) > 0 {
-				plugin := server.routerPlugins[v[0]]
-				if plugin != nil {
-					for _, f := range plugin(v[1:]) {
-						if f != nil {
-							nodeFilter = append(nodeFilter, f)
-						}
-					}
+		nodePlugins, err := server.compileRouterPluginList(node.Plugins, node.Path, runtime.states)
+		if err != nil {
+			return nil, err
+		}
+		nodeFilter := filterPlugins(nodePlugins, nil)
+		if node.Method == MethodOptions {
+			options[node.Path] = true
+		} else if (nodeCors || globalCors) && !isStaticMethod(node.Method) {
+			if _, ok := preflights[node.Path]; !ok {
+				var corsFilter gin.HandlersChain
+				if nodeCors {
+					corsFilter = filterPlugins(nodePlugins, isCors)
 				} else {
-					return nil, errors.New(fmt.Sprintf("invalid router plugin: %v", v))
+					corsFilter = filterPlugins(globalPlugins, isCors)
 				}
+				preflights[node.Path] = newHandlersChain(node.Access, accesslog, corsFilter, nil, nil, corsPreflightHandler)
+				preflightPaths = append(preflightPaths, node.Path)
 			}
 		}
 
@@ -569,12 +1037,91 @@ func (server *Server) compileRouterEngine(config *Config, cache cache.Cache, acc
 				engine.StaticFS(node.Path, node.FileSystem)
 			}
 		default:
-			engine.Handle(node.Method, node.Path, newHandlersChain(node.Access, accesslog, routerFilter, nodeFilter, node.Filter, node.Handler)...)
+			handler := node.Handler
+			if node.Cache > 0 && runtime.cache != nil {
+				policy := &cache.Policy{
+					Seconds:              node.Cache,
+					StaleWhileRevalidate: node.StaleWhileRevalidate,
+					StaleIfError:         node.StaleIfError,
+					Key:                  node.CacheKey,
+				}
+				// 后台刷新与合并等待的期限, 未设置方法期限时取缓存时长
+				if config.Timeout > 0 {
+					policy.Timeout = config.Timeout
+				}
+				handler = runtime.cache.CacheWith(policy, handler)
+			}
+			engine.Handle(node.Method, node.Path, newHandlersChain(node.Access, accesslog, routerFilter, nodeFilter, node.Filter, handler)...)
+		}
+	}
+	// 已显式注册OPTIONS的路径不再自动注册
+	for _, path := range preflightPaths {
+		if !options[path] {
+			engine.OPTIONS(path, preflights[path]...)
 		}
 	}
 	return engine, nil
 }
 
+// 编译路由插件, scope为所在路由(见scopedRouterPlugin), match为nil表示全部
+func (server *Server) compileRouterPlugins(plugins [][]string, scope string, states *runtimeStates, match func(name string) bool) (gin.HandlersChain, error) {
+	list, err := server.compileRouterPluginList(plugins, scope, states)
+	if err != nil {
+		return nil, err
+	}
+	return filterPlugins(list, match), nil
+}
+
+// 编译后的路由插件, 保留名称以便按名称筛选(例如替换cors), 避免同一插件重复编译出多份状态
+type compiledPlugin struct {
+	name  string
+	chain gin.HandlersChain
+}
+
+func (server *Server) compileRouterPluginList(plugins [][]string, scope string, states *runtimeStates) (ret []compiledPlugin, err error) {
+	var cur []string
+	defer func() {
+		// 插件参数非法时panic, 转为错误以拒绝启动或热加载
+		if perr := recover(); perr != nil {
+			ret, err = nil, errors.New(fmt.Sprintf("invalid router plugin: %v, %v", cur, perr))
+		}
+	}()
+	for _, v := range plugins {
+		cur = v
+		if len(v) > 0 {
+			if scoped := server.scopedPlugins[v[0]]; scoped != nil {
+				ret = append(ret, compiledPlugin{name: v[0], chain: scoped(states, scope, v[1:])})
+				continue
+			}
+			plugin := server.routerPlugins[v[0]]
+			if plugin == nil {
+				return nil, errors.New(fmt.Sprintf("invalid router plugin: %v", v))
+			}
+			ret = append(ret, compiledPlugin{name: v[0], chain: plugin(v[1:])})
+		}
+	}
+	return ret, nil
+}
+
+func filterPlugins(list []compiledPlugin, match func(name string) bool) gin.HandlersChain {
+	var ret gin.HandlersChain
+	for _, p := range list {
+		if match != nil && !match(p.name) {
+			continue
+		}
+		for _, f := range p.chain {
+			if f != nil {
+				ret = append(ret, f)
+			}
+		}
+	}
+	return ret
+}
+
+func isStaticMethod(method string) bool {
+	return method == MethodStatic || method == MethodStaticFile || method == MethodStaticFS
+}
+
 func cloneProxyTarget(fnodes []*FlatNode, path string, method string) *FlatNode {
 	for _, fnode := range fnodes {
 		if path == fnode.Path && method == fnode.Method {
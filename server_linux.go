@@ -73,7 +73,8 @@ func graceListenHttp(host string, port int, keepalive time.Duration) (net.Listen
 	return &KeepAliveTCPListener{TCPListener: tln.(*net.TCPListener), KeepAlivePeriod: keepalive}, nil
 }
 
-func graceShutdownOrRestart(grpcServer *grpc.Server, grpcListener net.Listener, httpServer *http.Server, httpListener net.Listener) {
+// reload非空时SIGHUP表示热加载, 否则与SIGINT/SIGTERM一样优雅关闭
+func graceShutdownOrRestart(grpcServer *grpc.Server, grpcListener net.Listener, httpServer *http.Server, httpListener net.Listener, reload func()) {
 	sch := make(chan os.Signal, 1)
 	defer signal.Stop(sch)
 
@@ -81,6 +82,11 @@ func graceShutdownOrRestart(grpcServer *grpc.Server, grpcListener net.Listener,
 	for {
 		sig := <-sch
 
+		if sig == syscall.SIGHUP && reload != nil {
+			reload()
+			continue
+		}
+
 		switch sig {
 		case syscall.SIGUSR2:
 			var (
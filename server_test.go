@@ -1,8 +1,14 @@
 package pbapi
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/cache"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -15,3 +21,82 @@ func TestNewServer(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// 创建带有demo包下指定服务的Server, 供http测试使用
+func newDemoServer(service string, adapters map[string]func(context.Context, []byte) (interface{}, error)) *Server {
+	s := NewServer()
+	s.serviceHandlers = append(s.serviceHandlers, &ServiceHandler{
+		PackageName: "demo",
+		ServiceName: service,
+		Adapters:    adapters,
+	})
+	return s
+}
+
+// 合并默认配置后编译http引擎, 失败时终止测试
+func compileDemoEngine(t *testing.T, s *Server, config *Config, runtime *httpRuntime) *gin.Engine {
+	t.Helper()
+	engine, err := s.compileHttpEngine(mergeConfig(config), runtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestMaxRequestBytes(t *testing.T) {
+	echo := func(ctx context.Context, data []byte) (interface{}, error) {
+		return string(data), nil
+	}
+	s := newDemoServer("EchoService", map[string]func(context.Context, []byte) (interface{}, error){"Echo": echo, "Big": echo})
+	// router路由同样受限, 读取超出时得到ErrRequestTooLarge
+	raw := func(ctx *gin.Context) {
+		bs, err := ioutil.ReadAll(ctx.Request.Body)
+		if err == ErrRequestTooLarge {
+			writeRequestTooLarge(ctx, ctx.Request.URL.Path)
+			return
+		}
+		ctx.String(http.StatusOK, string(bs))
+	}
+	s.POST("/raw", raw)
+	s.POST("/raw/big", raw)
+	config := &Config{
+		MaxRequestBytes: 8,
+		ServerConfig:    []*ServerConfig{{Method: "Big", MaxRequestBytes: 1024}},
+		RouterConfig: []*RouterConfig{
+			{Path: "/demo/echo/echo", Cache: 60},
+			{Path: "/raw", Cache: 60},
+			{Path: "/raw/big", MaxRequestBytes: 1024},
+		},
+	}
+	c := cache.New(&cache.Config{Type: cache.MEMORY})
+	defer c.Close()
+	engine := compileDemoEngine(t, s, config, &httpRuntime{cache: c})
+	for _, v := range []struct {
+		path    string
+		body    string
+		chunked bool
+		expect  int
+	}{
+		{"/demo/echo/echo", "12345678", false, http.StatusOK},
+		{"/demo/echo/echo", "123456789", false, http.StatusRequestEntityTooLarge},
+		{"/demo/echo/echo", "123456789", true, http.StatusRequestEntityTooLarge}, // 无Content-Length, 经过cache读取时判断
+		{"/demo/echo/big", "123456789", false, http.StatusOK},
+		{"/raw", "123456789", false, http.StatusRequestEntityTooLarge},
+		{"/raw", "123456789", true, http.StatusRequestEntityTooLarge},
+		{"/raw", "12345678", true, http.StatusOK},
+		{"/raw/big", "123456789", false, http.StatusOK},
+	} {
+		r := httptest.NewRequest(http.MethodPost, v.path, strings.NewReader(v.body))
+		if v.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, r)
+		if w.Code != v.expect {
+			t.Fatalf("%v %v: expect %v, got %v %s", v.path, v.body, v.expect, w.Code, w.Body.String())
+		}
+		if v.expect != http.StatusOK && !strings.Contains(w.Body.String(), `"code":601`) {
+			t.Fatalf("unexpected body: %s", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package pbapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/obase/log"
+	"github.com/obase/pbapi/codec"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	SSE_REQUEST_PARAM = "request" // sse请求消息(json)所在的query参数
+	SSE_EVENT_ERROR   = "error"   // 失败结束, data为Response
+	SSE_EVENT_END     = "end"     // 正常结束, 客户端应关闭EventSource避免重连
+)
+
+/*
+流式方法的适配器, Handler即grpc生成的StreamHandler绑定服务实现:
+1. server-streaming: http GET的SSE, 或websocket(首条消息为请求, 每个结果一条消息)
+2. client-streaming/bidi: websocket会话, 每条消息为一个请求, 空消息表示客户端流结束
+*/
+type StreamAdapter struct {
+	ServerStreams bool
+	ClientStreams bool
+	Handler       func(stream grpc.ServerStream) error
+}
+
+func streamAdapters(sdesc *grpc.ServiceDesc, service interface{}) map[string]*StreamAdapter {
+	if sdesc == nil || len(sdesc.Streams) == 0 {
+		return nil
+	}
+	ret := make(map[string]*StreamAdapter)
+	for _, sd := range sdesc.Streams {
+		handler := sd.Handler // 闭包必须绑定当次迭代的handler
+		ret[sd.StreamName] = &StreamAdapter{
+			ServerStreams: sd.ServerStreams,
+			ClientStreams: sd.ClientStreams,
+			Handler: func(stream grpc.ServerStream) error {
+				return handler(service, stream)
+			},
+		}
+	}
+	return ret
+}
+
+// 携带gin.Context的值(codec, jwt claims, span等), 连接断开或处理结束时取消
+type streamContext struct {
+	context.Context
+	c *gin.Context
+}
+
+func (s *streamContext) Value(key interface{}) interface{} {
+	if v := s.c.Value(key); v != nil {
+		return v
+	}
+	return s.Context.Value(key)
+}
+
+func newStreamContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	return &streamContext{Context: ctx, c: c}, cancel
+}
+
+// 数据为空时保持零值消息, 同unary适配器
+func unmarshalStream(ctx context.Context, data []byte, m interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return codec.Unmarshal(ctx, data, m)
+}
+
+type sseStream struct {
+	ctx     context.Context
+	c       *gin.Context
+	request []byte
+	recved  bool
+	started bool
+	id      int
+}
+
+func (s *sseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseStream) SetHeader(md metadata.MD) error {
+	if s.started {
+		return errors.New("sse stream already started")
+	}
+	header := s.c.Writer.Header()
+	for k, vs := range md {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	return nil
+}
+
+func (s *sseStream) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.start()
+	return nil
+}
+
+func (s *sseStream) SetTrailer(md metadata.MD) {
+}
+
+// 仅一条请求消息
+func (s *sseStream) RecvMsg(m interface{}) error {
+	if s.recved {
+		return io.EOF
+	}
+	s.recved = true
+	return unmarshalStream(s.ctx, s.request, m)
+}
+
+func (s *sseStream) SendMsg(m interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	data, err := codec.FromContext(s.ctx).Marshal(m)
+	if err != nil {
+		return err
+	}
+	s.id++
+	return s.write(fmt.Sprintf("id: %d\n", s.id), data)
+}
+
+func (s *sseStream) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	header := s.c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // 禁止nginx缓冲
+	s.c.Writer.WriteHeader(http.StatusOK)
+}
+
+func (s *sseStream) write(head string, data []byte) error {
+	s.start()
+	if _, err := s.c.Writer.WriteString(head + "data: " + string(data) + "\n\n"); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+/*
+server-streaming方法的SSE处理器: 请求消息取自query参数request, 每个结果为一个事件(data为json),
+结束时发送end事件, 失败时发送error事件(data为Response)
+*/
+func CreateHandlerFunc4Sse(tag string, fn func(grpc.ServerStream) error) gin.HandlerFunc {
+	return CreateLimitedHandlerFunc4Sse(tag, 0, fn)
+}
+
+// readLimit限制query参数request的字节数(<=0不限制), 超出返回413
+func CreateLimitedHandlerFunc4Sse(tag string, readLimit int64, fn func(grpc.ServerStream) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		request := c.Query(SSE_REQUEST_PARAM)
+		if readLimit > 0 && int64(len(request)) > readLimit {
+			log.Errorf("%s reading request: %v", tag, ErrRequestTooLarge)
+			writeRequestTooLarge(c, tag)
+			return
+		}
+		ctx, cancel := newStreamContext(c)
+		defer cancel()
+
+		s := &sseStream{
+			ctx:     ctx,
+			c:       c,
+			request: []byte(request),
+		}
+		if err := fn(s); err != nil {
+			log.Errorf("%s execute service: %v", tag, err)
+			if ctx.Err() == nil {
+				wdata, _ := json.Marshal(ErrorResponse(tag, err))
+				s.write("event: "+SSE_EVENT_ERROR+"\n", wdata)
+			}
+			return
+		}
+		s.write("event: "+SSE_EVENT_END+"\n", nil)
+	}
+}
+
+type wbskStream struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	conn          *websocket.Conn
+	tag           string
+	clientStreams bool
+	readTimeout   time.Duration
+	recved        bool
+	eof           bool
+	wlock         sync.Mutex
+}
+
+func (s *wbskStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *wbskStream) SetHeader(md metadata.MD) error {
+	return nil
+}
+
+func (s *wbskStream) SendHeader(md metadata.MD) error {
+	return nil
+}
+
+func (s *wbskStream) SetTrailer(md metadata.MD) {
+}
+
+func (s *wbskStream) read() ([]byte, error) {
+	if s.readTimeout > 0 {
+		s.conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	}
+	_, rdata, err := s.conn.ReadMessage()
+	if err != nil {
+		s.cancel()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return rdata, nil
+}
+
+func (s *wbskStream) RecvMsg(m interface{}) error {
+	if s.eof || (s.recved && !s.clientStreams) {
+		return io.EOF
+	}
+	rdata, err := s.read()
+	if err != nil {
+		s.eof = true
+		return err
+	}
+	if !s.recved && !s.clientStreams {
+		// 之后不再有请求, 继续读取以处理控制消息并感知断开
+		go s.watch()
+	}
+	s.recved = true
+	if s.clientStreams && len(rdata) == 0 {
+		// 客户端流结束, 同样继续读取以感知断开
+		s.eof = true
+		go s.watch()
+		return io.EOF
+	}
+	return unmarshalStream(s.ctx, rdata, m)
+}
+
+func (s *wbskStream) watch() {
+	s.conn.SetReadDeadline(time.Time{})
+	for {
+		if _, _, err := s.conn.ReadMessage(); err != nil {
+			s.cancel()
+			return
+		}
+	}
+}
+
+func (s *wbskStream) SendMsg(m interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	data, err := codec.Encode(codec.FromContext(s.ctx), m)
+	if err != nil {
+		return err
+	}
+	return s.write(&Response{
+		Code: SUCCESS,
+		Data: data,
+		Tag:  s.tag,
+	})
+}
+
+func (s *wbskStream) write(rsp *Response) error {
+	wdata, err := json.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, wdata)
+}
+
+/*
+流式方法的websocket处理器, 每个结果为一条Response{code: 0, data}消息.
+失败时发送Response错误消息, 结束后以1000关闭连接.
+readLimit/readTimeout同CreateLimitedHandlerFunc4Wbsk, readTimeout仅作用于请求消息
+*/
+func CreateStreamHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, readLimit int64, readTimeout time.Duration, clientStreams bool, fn func(grpc.ServerStream) error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			log.Errorf("upgrade connection: %v, %v", tag, err)
+			return
+		}
+		defer conn.Close()
+		conn.UnderlyingConn().SetDeadline(time.Time{})
+		if readLimit > 0 {
+			conn.SetReadLimit(readLimit)
+		}
+		ctx, cancel := newStreamContext(c)
+		defer cancel()
+
+		s := &wbskStream{
+			ctx:           ctx,
+			cancel:        cancel,
+			conn:          conn,
+			tag:           tag,
+			clientStreams: clientStreams,
+			readTimeout:   readTimeout,
+		}
+		if err = fn(s); err != nil {
+			log.Errorf("%s execute service: %v", tag, err)
+			if ctx.Err() != nil {
+				return
+			}
+			s.write(ErrorResponse(tag, err))
+		}
+		s.wlock.Lock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		s.wlock.Unlock()
+	}
+}
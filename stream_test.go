@@ -0,0 +1,214 @@
+package pbapi
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/obase/pbapi/grpc_health_v1"
+	"google.golang.org/grpc"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamService struct{}
+
+func (streamService) watch(srv interface{}, stream grpc.ServerStream) error {
+	req := new(grpc_health_v1.HealthCheckRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	if req.Service != "demo" {
+		return errors.New("unknown service: " + req.Service)
+	}
+	for i := 0; i < 3; i++ {
+		if err := stream.SendMsg(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (streamService) chat(srv interface{}, stream grpc.ServerStream) error {
+	for {
+		req := new(grpc_health_v1.HealthCheckRequest)
+		if err := stream.RecvMsg(req); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.SendMsg(req); err != nil {
+			return err
+		}
+	}
+}
+
+func newStreamServer(s *Server) {
+	impl := streamService{}
+	s.RegisterService(func(service interface{}) (*grpc.ServiceDesc, string, string, map[string]func(context.Context, []byte) (interface{}, error)) {
+		return &grpc.ServiceDesc{
+			ServiceName: "demo.StreamService",
+			HandlerType: (*interface{})(nil),
+			Streams: []grpc.StreamDesc{
+				{StreamName: "Watch", Handler: impl.watch, ServerStreams: true},
+				{StreamName: "Chat", Handler: impl.chat, ServerStreams: true, ClientStreams: true},
+			},
+		}, "demo", "StreamService", nil
+	}, impl)
+}
+
+func TestStreamSse(t *testing.T) {
+	s := NewServer()
+	newStreamServer(s)
+	engine, err := s.compileHttpEngine(mergeConfig(&Config{}), &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(engine)
+	defer ts.Close()
+
+	cases := []struct {
+		request string
+		expect  []string
+	}{
+		{`{"service":"demo"}`, []string{"id: 1\ndata: {\"status\":1}\n\n", "id: 3\n", "event: end\n"}},
+		{`{"service":"none"}`, []string{"event: error\ndata: {\"code\":603"}},
+	}
+	for _, c := range cases {
+		rsp, err := http.Get(ts.URL + "/demo/stream/watch?request=" + url.QueryEscape(c.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		if !strings.HasPrefix(rsp.Header.Get("Content-Type"), "text/event-stream") {
+			t.Errorf("unexpected content type: %v", rsp.Header.Get("Content-Type"))
+		}
+		for _, e := range c.expect {
+			if !strings.Contains(string(body), e) {
+				t.Errorf("%v: expect %q in %q", c.request, e, body)
+			}
+		}
+	}
+	// bidi方法没有SSE
+	if rsp, err := http.Get(ts.URL + "/demo/stream/chat"); err != nil || rsp.StatusCode != http.StatusNotFound {
+		t.Errorf("expect no sse for bidi: %v %v", rsp.StatusCode, err)
+	}
+
+	// 请求参数受maxRequestBytes限制
+	engine, err = s.compileHttpEngine(mergeConfig(&Config{MaxRequestBytes: 8}), &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/demo/stream/watch?request="+url.QueryEscape(`{"service":"demo"}`), nil))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":601`) {
+		t.Errorf("expect request too large, got %v %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStreamWbsk(t *testing.T) {
+	s := NewServer()
+	newStreamServer(s)
+	// sse与websocket同路径时拒绝
+	if _, err := s.compileHttpEngine(mergeConfig(&Config{ServerConfig: []*ServerConfig{{WbskOff: false, SetWbskOff: true}}}), &httpRuntime{}); err == nil {
+		t.Fatal("expect sse and websocket path conflict")
+	}
+	engine, err := s.compileHttpEngine(mergeConfig(&Config{ServerConfig: []*ServerConfig{
+		{WbskOff: false, SetWbskOff: true},
+		{Method: "Watch", SsePath: "/demo/stream/watch/sse"},
+	}}), &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(engine)
+	defer ts.Close()
+	wsurl := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	// server-streaming: 首条消息为请求
+	conn, _, err := websocket.DefaultDialer.Dial(wsurl+"/demo/stream/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"service":"demo"}`))
+	for i := 0; i < 3; i++ {
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != `{"code":0,"data":{"status":1},"tag":"StreamService.Watch"}` {
+			t.Fatalf("watch message %v: %s %v", i, msg, err)
+		}
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expect normal closure, got %v", err)
+	}
+	conn.Close()
+
+	// bidi: 逐条应答, 空消息结束
+	conn, _, err = websocket.DefaultDialer.Dial(wsurl+"/demo/stream/chat", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, name := range []string{"a", "b"} {
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"service":"`+name+`"}`))
+		if _, msg, err := conn.ReadMessage(); err != nil || !strings.Contains(string(msg), `"data":{"service":"`+name+`"}`) {
+			t.Fatalf("chat %v: %s %v", name, msg, err)
+		}
+	}
+	conn.WriteMessage(websocket.TextMessage, nil)
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("expect normal closure, got %v", err)
+	}
+}
+
+func TestStreamWbskDisconnect(t *testing.T) {
+	s := NewServer()
+	done := make(chan error, 1)
+	// 客户端流结束后等待断开
+	wait := func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(new(grpc_health_v1.HealthCheckRequest)); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+		<-stream.Context().Done()
+		done <- stream.Context().Err()
+		return nil
+	}
+	s.RegisterService(func(service interface{}) (*grpc.ServiceDesc, string, string, map[string]func(context.Context, []byte) (interface{}, error)) {
+		return &grpc.ServiceDesc{
+			ServiceName: "demo.WaitService",
+			HandlerType: (*interface{})(nil),
+			Streams: []grpc.StreamDesc{
+				{StreamName: "Wait", Handler: wait, ClientStreams: true},
+			},
+		}, "demo", "WaitService", nil
+	}, nil)
+	engine, err := s.compileHttpEngine(mergeConfig(&Config{ServerConfig: []*ServerConfig{{WbskOff: false, SetWbskOff: true}}}), &httpRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(engine)
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/demo/wait/wait", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"service":"demo"}`))
+	conn.WriteMessage(websocket.TextMessage, nil)
+	conn.Close()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expect canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect context canceled after disconnect")
+	}
+}
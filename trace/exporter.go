@@ -0,0 +1,103 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+type Config struct {
+	Exporter    string  `json:"exporter" bson:"exporter" yaml:"exporter"`          // none | stdout | file | 自定义注册的名称, 默认none不启用
+	Path        string  `json:"path" bson:"path" yaml:"path"`                      // file导出路径
+	Service     string  `json:"service" bson:"service" yaml:"service"`             // 服务名, 默认取service.name
+	SampleRatio float64 `json:"sampleRatio" bson:"sampleRatio" yaml:"sampleRatio"` // 新建trace的采样率(0~1], 默认1. 上游已带traceparent则沿用其采样标记
+}
+
+func mergeConfig(c *Config) *Config {
+	ret := *c
+	if ret.SampleRatio <= 0 {
+		ret.SampleRatio = 1
+	}
+	return &ret
+}
+
+// 导出器, Export在请求goroutine中同步调用, 实现需并发安全且尽量不阻塞
+type Exporter interface {
+	Export(span *Span)
+	Close()
+}
+
+type ExporterFactory func(c *Config) (Exporter, error)
+
+var (
+	exportersMutex sync.RWMutex
+	exporters      = map[string]ExporterFactory{
+		ExporterStdout: func(c *Config) (Exporter, error) {
+			return NewWriterExporter(os.Stdout), nil
+		},
+		ExporterFile: func(c *Config) (Exporter, error) {
+			if c.Path == "" {
+				return nil, errors.New("trace file exporter requires path")
+			}
+			file, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, err
+			}
+			return NewWriterExporter(file), nil
+		},
+	}
+)
+
+// 注册自定义导出器, 例如对接jaeger/zipkin/otlp
+func RegisterExporter(name string, f ExporterFactory) {
+	exportersMutex.Lock()
+	exporters[name] = f
+	exportersMutex.Unlock()
+}
+
+func NewExporter(c *Config) (Exporter, error) {
+	exportersMutex.RLock()
+	f, ok := exporters[c.Exporter]
+	exportersMutex.RUnlock()
+	if !ok {
+		return nil, errors.New("invalid trace exporter: " + c.Exporter)
+	}
+	return f(c)
+}
+
+// 每个span输出一行json
+type WriterExporter struct {
+	sync.Mutex
+	writer io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{writer: w}
+}
+
+func (e *WriterExporter) Export(span *Span) {
+	bs, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	bs = append(bs, '\n')
+	e.Lock()
+	e.writer.Write(bs)
+	e.Unlock()
+}
+
+func (e *WriterExporter) Close() {
+	e.Lock()
+	defer e.Unlock()
+	if f, ok := e.writer.(*os.File); ok && f != os.Stdout && f != os.Stderr {
+		f.Close()
+	}
+}
@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func extractMetadata(ctx context.Context) SpanContext {
+	var sc SpanContext
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vs := md.Get(HEADER_TRACEPARENT); len(vs) > 0 {
+			sc, _ = ParseTraceparent(vs[0])
+		}
+	}
+	return sc
+}
+
+func (t *Tracer) startServer(ctx context.Context, fullMethod string) (context.Context, *Span) {
+	ctx, span := t.Start(ctx, fullMethod, KindServer, extractMetadata(ctx))
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		span.SetAttribute("net.peer", p.Addr.String())
+	}
+	return ctx, span
+}
+
+func endSpan(span *Span, err error) {
+	span.SetAttribute("grpc.code", status.Code(err).String())
+	span.SetError(err)
+	span.End()
+}
+
+func (t *Tracer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := t.startServer(ctx, info.FullMethod)
+		rsp, err := handler(ctx, req)
+		endSpan(span, err)
+		return rsp, err
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (t *Tracer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := t.startServer(ss.Context(), info.FullMethod)
+		err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		endSpan(span, err)
+		return err
+	}
+}
+
+// 客户端拦截器: 若ctx带有span则派生client span, 并通过metadata传播traceparent
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, span := FromContext(ctx).StartChild(ctx, method, KindClient)
+		if span == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, HEADER_TRACEPARENT, span.Context.Traceparent())
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		endSpan(span, err)
+		return err
+	}
+}
+
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, span := FromContext(ctx).StartChild(ctx, method, KindClient)
+		if span == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		ctx = metadata.AppendToOutgoingContext(ctx, HEADER_TRACEPARENT, span.Context.Traceparent())
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		// 流的生命周期不可知, 只记录建立过程
+		endSpan(span, err)
+		return cs, err
+	}
+}
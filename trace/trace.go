@@ -0,0 +1,237 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HEADER_TRACEPARENT = "traceparent" // W3C Trace Context, http头不区分大小写, grpc的metadata一律小写
+
+	// 必须是字符串: gin.Context.Value只对字符串键查找Keys, 适配器收到的context即gin.Context
+	CONTEXT_KEY = "pbapi.trace.span"
+
+	KindServer = "server"
+	KindClient = "client"
+
+	FlagSampled byte = 0x01
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// 传播的上下文, 对应traceparent: 00-{traceId}-{spanId}-{flags}
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+func ParseTraceparent(v string) (sc SpanContext, err error) {
+	ps := strings.Split(strings.TrimSpace(v), "-")
+	if len(ps) < 4 || len(ps[0]) != 2 || ps[0] == "ff" || len(ps[1]) != 32 || len(ps[2]) != 16 || len(ps[3]) != 2 {
+		err = errors.New("invalid traceparent: " + v)
+		return
+	}
+	// 版本00必须恰好4段, 更高版本允许追加字段
+	if ps[0] == "00" && len(ps) != 4 {
+		err = errors.New("invalid traceparent: " + v)
+		return
+	}
+	var flags [1]byte
+	if _, err = hex.Decode(sc.TraceID[:], []byte(ps[1])); err != nil {
+		return
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(ps[2])); err != nil {
+		return
+	}
+	if _, err = hex.Decode(flags[:], []byte(ps[3])); err != nil {
+		return
+	}
+	sc.Flags = flags[0]
+	if !sc.IsValid() {
+		err = errors.New("invalid traceparent: " + v)
+	}
+	return
+}
+
+type Span struct {
+	tracer     *Tracer
+	Context    SpanContext       `json:"-"`
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	ParentID   string            `json:"parentId,omitempty"`
+	Service    string            `json:"service,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Start      time.Time         `json:"start"`
+	Duration   int64             `json:"duration"` // 微秒
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func (s *Span) SetAttribute(key string, val string) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = val
+}
+
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Error = err.Error()
+}
+
+// 结束并导出, 未采样的span只传播不导出
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Duration = int64(time.Since(s.Start) / time.Microsecond)
+	if s.Context.Sampled() && s.tracer != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
+
+func (s *Span) Tracer() *Tracer {
+	if s == nil {
+		return nil
+	}
+	return s.tracer
+}
+
+// 派生子span, 例如代理或grpc客户端调用. 当前没有span则返回原ctx与nil
+func (s *Span) StartChild(ctx context.Context, name string, kind string) (context.Context, *Span) {
+	if s == nil || s.tracer == nil {
+		return ctx, nil
+	}
+	return s.tracer.Start(ctx, name, kind, s.Context)
+}
+
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(CONTEXT_KEY).(*Span)
+	return span
+}
+
+func NewContext(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, CONTEXT_KEY, span)
+}
+
+// 当前的traceId, 没有则为空
+func TraceIDFromContext(ctx context.Context) string {
+	if span := FromContext(ctx); span != nil {
+		return span.TraceID
+	}
+	return ""
+}
+
+func Inject(ctx context.Context, header http.Header) {
+	if span := FromContext(ctx); span != nil {
+		header.Set(HEADER_TRACEPARENT, span.Context.Traceparent())
+	}
+}
+
+func Extract(header http.Header) SpanContext {
+	sc, _ := ParseTraceparent(header.Get(HEADER_TRACEPARENT))
+	return sc
+}
+
+type Tracer struct {
+	config   *Config
+	exporter Exporter
+	sampler  uint64 // 按traceId低8字节采样的阈值
+}
+
+func NewTracer(c *Config) (*Tracer, error) {
+	if c == nil || c.Exporter == "" || c.Exporter == ExporterNone {
+		return nil, nil
+	}
+	c = mergeConfig(c)
+	exporter, err := NewExporter(c)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tracer{
+		config:   c,
+		exporter: exporter,
+	}
+	if c.SampleRatio >= 1 {
+		t.sampler = math.MaxUint64
+	} else if c.SampleRatio > 0 {
+		t.sampler = uint64(c.SampleRatio * math.MaxUint64)
+	}
+	return t, nil
+}
+
+/*
+创建span: parent有效则沿用其traceId与采样标记, 否则新建trace并按sampleRatio采样.
+返回的ctx携带该span
+*/
+func (t *Tracer) Start(ctx context.Context, name string, kind string, parent SpanContext) (context.Context, *Span) {
+	span := &Span{
+		tracer:  t,
+		Service: t.config.Service,
+		Name:    name,
+		Kind:    kind,
+		Start:   time.Now(),
+	}
+	if parent.IsValid() {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Flags = parent.Flags
+		span.ParentID = parent.SpanID.String()
+	} else {
+		rand.Read(span.Context.TraceID[:])
+		if binary.BigEndian.Uint64(span.Context.TraceID[8:]) <= t.sampler && t.sampler > 0 {
+			span.Context.Flags = FlagSampled
+		}
+	}
+	rand.Read(span.Context.SpanID[:])
+	span.TraceID = span.Context.TraceID.String()
+	span.SpanID = span.Context.SpanID.String()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return NewContext(ctx, span), span
+}
+
+func (t *Tracer) Close() {
+	if t != nil {
+		t.exporter.Close()
+	}
+}
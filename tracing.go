@@ -0,0 +1,43 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/trace"
+	"net/http"
+	"strconv"
+)
+
+// span名称: 服务结点与grpc的FullMethod一致, 其余为"METHOD path"
+func spanName(node *FlatNode) string {
+	if node.ServiceName != "" {
+		if node.PackageName != "" {
+			return "/" + node.PackageName + "." + node.ServiceName + "/" + node.MethodName
+		}
+		return "/" + node.ServiceName + "/" + node.MethodName
+	}
+	return node.Method + " " + node.Path
+}
+
+/*
+结点的链路追踪句柄: 从traceparent头继承上游trace, span同时放入gin.Context与Request.Context,
+前者供适配器(收到的context即gin.Context)使用, 后者供代理转发使用
+*/
+func newTraceHandlerFunc(t *trace.Tracer, node *FlatNode) gin.HandlerFunc {
+	name := spanName(node)
+	return func(ctx *gin.Context) {
+		rctx, span := t.Start(ctx.Request.Context(), name, trace.KindServer, trace.Extract(ctx.Request.Header))
+		span.SetAttribute("http.method", ctx.Request.Method)
+		span.SetAttribute("http.target", ctx.Request.URL.Path)
+		ctx.Request = ctx.Request.WithContext(rctx)
+		ctx.Set(trace.CONTEXT_KEY, span)
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		span.SetAttribute("http.status_code", strconv.Itoa(status))
+		if status >= http.StatusInternalServerError {
+			span.Error = http.StatusText(status)
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,92 @@
+package pbapi
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/obase/pbapi/proxy"
+	"github.com/obase/pbapi/trace"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type memoryExporter struct {
+	sync.Mutex
+	spans []*trace.Span
+}
+
+func (e *memoryExporter) Export(span *trace.Span) {
+	e.Lock()
+	e.spans = append(e.spans, span)
+	e.Unlock()
+}
+
+func (e *memoryExporter) Close() {
+}
+
+func TestTracing(t *testing.T) {
+	exporter := new(memoryExporter)
+	trace.RegisterExporter("memory", func(c *trace.Config) (trace.Exporter, error) {
+		return exporter, nil
+	})
+	tracer, err := trace.NewTracer(&trace.Config{Exporter: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upstreamParent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get(trace.HEADER_TRACEPARENT)
+	}))
+	defer upstream.Close()
+	proxies, err := proxy.NewFactory(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxies.Close()
+
+	var adapterTrace string
+	s := NewServer()
+	s.GET("/a", func(ctx *gin.Context) {
+		adapterTrace = trace.TraceIDFromContext(ctx)
+	})
+	config := mergeConfig(&Config{
+		RouterConfig: []*RouterConfig{{
+			Path:         "/gw",
+			Methods:      []string{http.MethodGet},
+			ProxyPath:    "/b",
+			ProxyTargets: []string{strings.TrimPrefix(upstream.URL, "http://")},
+		}},
+	})
+	engine, err := s.compileHttpEngine(config, &httpRuntime{tracer: tracer, proxies: proxies})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 继承上游trace
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.Header.Set(trace.HEADER_TRACEPARENT, parent)
+	engine.ServeHTTP(httptest.NewRecorder(), r)
+	if adapterTrace != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("adapter trace id: %v", adapterTrace)
+	}
+	if len(exporter.spans) != 1 || exporter.spans[0].ParentID != "00f067aa0ba902b7" || exporter.spans[0].Name != "GET /a" {
+		t.Fatalf("unexpected spans: %+v", exporter.spans)
+	}
+
+	// 代理向上游传播client span
+	exporter.spans = nil
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/gw", nil))
+	if len(exporter.spans) != 2 {
+		t.Fatalf("expect 2 spans, got %v", len(exporter.spans))
+	}
+	client, server := exporter.spans[0], exporter.spans[1]
+	if client.Kind != trace.KindClient || client.ParentID != server.SpanID || client.TraceID != server.TraceID {
+		t.Fatalf("unexpected spans: %+v %+v", client, server)
+	}
+	if upstreamParent != client.Context.Traceparent() {
+		t.Fatalf("upstream traceparent: %v", upstreamParent)
+	}
+}
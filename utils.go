@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/obase/log"
+	"github.com/obase/pbapi/codec"
+	"github.com/obase/pbapi/trace"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 )
 
 const (
@@ -138,50 +143,139 @@ func JoinPath(buf *bytes.Buffer, path string) {
 	}
 }
 
+var ErrRequestTooLarge = errors.New("request body too large")
+
+// 限制读取字节数, 超出后返回ErrRequestTooLarge. 与http.MaxBytesReader相同, 但错误可比较
+type limitedBody struct {
+	io.ReadCloser
+	remain int64
+	err    error
+}
+
+func (b *limitedBody) Read(p []byte) (n int, err error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// 多读1字节以判断是否超出
+	if int64(len(p)) > b.remain+1 {
+		p = p[:b.remain+1]
+	}
+	n, err = b.ReadCloser.Read(p)
+	if int64(n) <= b.remain {
+		b.remain -= int64(n)
+		b.err = err
+		return
+	}
+	n, b.remain, b.err = int(b.remain), 0, ErrRequestTooLarge
+	return n, b.err
+}
+
+func writeRequestTooLarge(c *gin.Context, tag string) {
+	c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, &Response{
+		Code: READING_REQUEST_ERROR,
+		Msg:  ErrRequestTooLarge.Error(),
+		Tag:  tag,
+	})
+}
+
+// 请求体Content-Encoding不支持
+func rejectCompress(c *gin.Context, status int, err error) {
+	c.AbortWithStatusJSON(status, &Response{
+		Code: READING_REQUEST_ERROR,
+		Msg:  err.Error(),
+	})
+}
+
+// 限制请求体大小, 须置于cache之前. Content-Length超出直接返回413, 否则由读取时判断
+func newBodyLimitHandlerFunc(tag string, max int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > max {
+			writeRequestTooLarge(c, tag)
+			return
+		}
+		if c.Request.Body != nil && c.Request.Body != http.NoBody {
+			c.Request.Body = &limitedBody{ReadCloser: c.Request.Body, remain: max}
+		}
+	}
+}
+
 func CreateHandlerFunc4Http(tag string, fn func(context.Context, []byte) (interface{}, error)) gin.HandlerFunc {
+	return CreateEncodedHandlerFunc4Http(tag, EnvelopeEncoder, fn)
+}
+
+// encode决定响应格式, 见ResponseEncoder
+func CreateEncodedHandlerFunc4Http(tag string, encode ResponseEncoder, fn func(context.Context, []byte) (interface{}, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var (
 			rdata []byte
-			wdata []byte
 			rsp   interface{}
 			err   error
 		)
 		rdata, err = ioutil.ReadAll(c.Request.Body)
+		if err == ErrRequestTooLarge {
+			log.Errorf("%s reading request: %v", tag, err)
+			writeRequestTooLarge(c, tag)
+			return
+		}
+		rc := codec.ResponseFromContext(c)
 		if err == nil {
 			rsp, err = fn(c, rdata)
 			if err == nil {
-				wdata, _ = json.Marshal(&Response{
-					Code: SUCCESS,
-					Data: rsp,
-					Tag:  tag,
-				})
-			} else {
+				rsp, err = codec.Encode(rc, rsp)
+			}
+			if err != nil {
 				log.Errorf("%s execute service: %v", tag, err)
-				if ersp, ok := err.(*Response); ok {
-					wdata, _ = json.Marshal(ersp)
-				} else {
-					wdata, _ = json.Marshal(&Response{
-						Code: EXECUTE_SERVICE_ERROR,
-						Msg:  err.Error(),
-						Tag:  tag,
-					})
-				}
 			}
 		} else {
 			log.Errorf("%s reading request: %v", tag, err)
-			wdata, _ = json.Marshal(&Response{
+			err = &Response{
 				Code: READING_REQUEST_ERROR,
 				Msg:  err.Error(),
 				Tag:  tag,
-			})
+			}
+		}
+		if codec.IsBinary(rc) {
+			writeBinary(c, rc, tag, rsp, err)
+			return
+		}
+		encode(c, tag, rsp, err)
+	}
+}
+
+const WBSK_OBSERVERS_KEY = "pbapi.wbsk.observers"
+
+// websocket消息观察者, 每条消息处理完毕后回调. 由中间件通过AddWbskObserver注入
+type WbskObserver func(c *gin.Context, rdata []byte, wdata []byte, err error, start time.Time)
+
+func AddWbskObserver(c *gin.Context, o WbskObserver) {
+	var obs []WbskObserver
+	if v, ok := c.Get(WBSK_OBSERVERS_KEY); ok {
+		obs, _ = v.([]WbskObserver)
+	}
+	c.Set(WBSK_OBSERVERS_KEY, append(obs, o))
+}
+
+func notifyWbskObservers(c *gin.Context, rdata []byte, wdata []byte, err error, start time.Time) {
+	if v, ok := c.Get(WBSK_OBSERVERS_KEY); ok {
+		obs, _ := v.([]WbskObserver)
+		for _, o := range obs {
+			o(c, rdata, wdata, err, start)
 		}
-		c.Writer.Header()["Content-Type"] = JsonContentType
-		c.Writer.WriteHeader(http.StatusOK)
-		c.Writer.Write(wdata)
 	}
 }
 
 func CreateHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, fn func(context.Context, []byte) (interface{}, error)) gin.HandlerFunc {
+	return CreateLimitedHandlerFunc4Wbsk(tag, upgrader, 0, 0, fn)
+}
+
+/*
+readLimit: 单条消息最大字节数, 超出时以1009关闭连接, <=0不限制
+readTimeout: 等待下一条消息的超时, <=0不限制
+*/
+func CreateLimitedHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, readLimit int64, readTimeout time.Duration, fn func(context.Context, []byte) (interface{}, error)) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -189,6 +283,14 @@ func CreateHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, fn func(co
 			log.Errorf("upgrade connection: %v, %v", tag, err)
 			return
 		}
+		defer conn.Close()
+		// 解除http.Server为本次请求设置的读写超时, 改由消息级控制
+		conn.UnderlyingConn().SetDeadline(time.Time{})
+		if readLimit > 0 {
+			conn.SetReadLimit(readLimit)
+		}
+		// 连接的span作为每条消息span的父级
+		connSpan := trace.FromContext(c)
 		for {
 			var (
 				mtype int
@@ -197,12 +299,28 @@ func CreateHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, fn func(co
 				rsp   interface{}
 				err   error
 			)
+			if readTimeout > 0 {
+				conn.SetReadDeadline(time.Now().Add(readTimeout))
+			}
 			mtype, rdata, err = conn.ReadMessage()
 			if err != nil {
 				log.Errorf("%s reading message: %v", tag, err)
 				return
 			}
+			start := time.Now()
+			_, span := connSpan.StartChild(c, tag, trace.KindServer)
+			if span != nil {
+				c.Set(trace.CONTEXT_KEY, span)
+			}
 			rsp, err = fn(c, rdata)
+			if err == nil {
+				rsp, err = codec.Encode(codec.FromContext(c), rsp)
+			}
+			if span != nil {
+				span.SetError(err)
+				span.End()
+				c.Set(trace.CONTEXT_KEY, connSpan)
+			}
 			if err == nil {
 				wdata, _ = json.Marshal(&Response{
 					Code: SUCCESS,
@@ -221,6 +339,7 @@ func CreateHandlerFunc4Wbsk(tag string, upgrader *websocket.Upgrader, fn func(co
 					})
 				}
 			}
+			notifyWbskObservers(c, rdata, wdata, err, start)
 			err = conn.WriteMessage(mtype, wdata)
 			if err != nil {
 				log.Errorf("%s writing message: %v", tag, err)
@@ -249,11 +368,17 @@ func CreateWebsocketUpgrader(conf *Config) *websocket.Upgrader {
 
 /*响应结果*/
 const (
-	SUCCESS               = 0
-	UNKNOWN               = -1
-	READING_REQUEST_ERROR = 601 // 读取request失败
-	PARSING_REQUEST_ERROR = 602 // 解析request失败
-	EXECUTE_SERVICE_ERROR = 603 // 执行service失败
+	SUCCESS                 = 0
+	UNKNOWN                 = -1
+	READING_REQUEST_ERROR   = 601 // 读取request失败
+	PARSING_REQUEST_ERROR   = 602 // 解析request失败
+	EXECUTE_SERVICE_ERROR   = 603 // 执行service失败
+	ACCESS_DENIED_ERROR     = 604 // 拒绝访问
+	CIRCUIT_OPEN_ERROR      = 605 // 熔断打开
+	RATE_LIMITED_ERROR      = 606 // 请求限流
+	UNAUTHORIZED_ERROR      = 607 // 认证失败
+	DEADLINE_EXCEEDED_ERROR = 608 // 执行超时
+	INTERNAL_ERROR          = 609 // 内部错误(panic)
 )
 
 type Response struct {